@@ -0,0 +1,107 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+// NameAggregate summarises every certificate seen for a single name
+type NameAggregate struct {
+	Name              string `json:"name"`
+	RegistrableDomain string `json:"registrable_domain"`
+	FirstSeen         string `json:"first_seen"`
+	LastSeen          string `json:"last_seen"`
+	CertCount         int    `json:"cert_count"`
+
+	firstSeen, lastSeen time.Time
+}
+
+// aggregateNames groups certs by each name they cover. first_seen and
+// last_seen are the earliest and latest entry timestamps for the name
+func aggregateNames(certs []record) []NameAggregate {
+	byName := make(map[string]*NameAggregate)
+
+	for _, c := range certs {
+		entered, err := c.EntryTime()
+		if err != nil {
+			continue
+		}
+
+		for _, n := range c.Names() {
+			a, ok := byName[n]
+			if !ok {
+				a = &NameAggregate{Name: n, RegistrableDomain: registrableDomain(n)}
+				byName[n] = a
+			}
+			a.CertCount++
+
+			if a.firstSeen.IsZero() || entered.Before(a.firstSeen) {
+				a.firstSeen = entered
+				a.FirstSeen = c.EntryTimestamp
+			}
+			if entered.After(a.lastSeen) {
+				a.lastSeen = entered
+				a.LastSeen = c.EntryTimestamp
+			}
+		}
+	}
+
+	aggregates := make([]NameAggregate, 0, len(byName))
+	for _, a := range byName {
+		aggregates = append(aggregates, *a)
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		return aggregates[i].Name < aggregates[j].Name
+	})
+	return aggregates
+}
+
+// loadCerts reads the output of a previous gcrt run: a JSON array, an
+// --envelope or ndjson
+func loadCerts(path string) ([]client.CertResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var certs []client.CertResponse
+	dec := json.NewDecoder(f)
+	for {
+		var v json.RawMessage
+		if err := dec.Decode(&v); err == io.EOF {
+			return certs, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch v = bytes.TrimSpace(v); {
+		case len(v) > 0 && v[0] == '[':
+			var array []client.CertResponse
+			if err := json.Unmarshal(v, &array); err != nil {
+				return nil, err
+			}
+			certs = append(certs, array...)
+		default:
+			var line struct {
+				client.CertResponse
+				SchemaVersion string                `json:"schema_version"`
+				Certificates  []client.CertResponse `json:"certificates"`
+			}
+			if err := json.Unmarshal(v, &line); err != nil {
+				return nil, err
+			}
+			if len(line.SchemaVersion) > 0 {
+				certs = append(certs, line.Certificates...)
+			} else {
+				certs = append(certs, line.CertResponse)
+			}
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// the heuristics of gcrt analyze
+const (
+	heuristicBurst      = "burst"
+	heuristicNewIssuer  = "new_issuer"
+	heuristicLookalike  = "lookalike"
+	heuristicShortLived = "short_lived"
+)
+
+// burstHistory is how far back the issuance a day is compared against goes
+const burstHistory = 90 * 24 * time.Hour
+
+var analyzeOpts struct {
+	burstFactor     float64
+	burstMin        int
+	newIssuerWithin string
+	shortLived      string
+	minScore        int
+}
+
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze",
+	Short: "Flag suspicious issuance in the certificates found",
+	Long: `analyze runs a search like gcrt does and looks through the certificates
+found for the patterns of phishing and compromise, outputting a list of
+findings scored from 0 to 100, highest first:
+
+  burst        a day with --burst-factor times more certs issued than the
+               daily average of the 90 days before
+  new_issuer   a CA that first issued a cert for the domain within
+               --new-issuer-within, after others issued its earlier ones
+  lookalike    names on the certs that look like the domain searched:
+               homographs, confusable characters, typos, the domain's name
+               combined with other words, or the name under another suffix
+  short_lived  certs valid for less than --short-lived`,
+	Example: `  gcrt analyze -d %.example.com -o table
+  gcrt analyze -d %.example.com --min-score 50 --new-issuer-within 14d`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := newAnalysis(time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		o := opts
+		o.analysis = a
+
+		out, err := o.openOutput(false)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		runErr := runTraced(ctx, o, out)
+		if err := out.Close(); err != nil && runErr == nil {
+			runErr = err
+		}
+		return runErr
+	},
+}
+
+func init() {
+	analyzeCmd.Flags().Float64Var(&analyzeOpts.burstFactor, "burst-factor", 5, "Flag days with this many times the average daily issuance of the 90 days before")
+	analyzeCmd.Flags().IntVar(&analyzeOpts.burstMin, "burst-min", 5, "The fewest certs issued in a day that can be a burst")
+	analyzeCmd.Flags().StringVar(&analyzeOpts.newIssuerWithin, "new-issuer-within", "30d", "Flag CAs whose first cert for the domain was issued within this time, e.g. 30d or 2w")
+	analyzeCmd.Flags().StringVar(&analyzeOpts.shortLived, "short-lived", "6d", "Flag certs valid for less than this, e.g. 6d or 12h")
+	analyzeCmd.Flags().IntVar(&analyzeOpts.minScore, "min-score", 0, "Only output findings scored at least this, from 0 to 100: 25 and up is low, 50 medium, 75 high and 100 critical")
+	cmd.AddCommand(analyzeCmd)
+}
+
+// analysis is the configuration of gcrt analyze
+type analysis struct {
+	now             time.Time
+	burstFactor     float64
+	burstMin        int
+	newIssuerWithin time.Duration
+	shortLived      time.Duration
+	minScore        int
+}
+
+func newAnalysis(now time.Time) (*analysis, error) {
+	a := &analysis{now: now, burstFactor: analyzeOpts.burstFactor, burstMin: analyzeOpts.burstMin, minScore: analyzeOpts.minScore}
+	if a.burstFactor <= 1 {
+		return nil, fmt.Errorf("invalid --burst-factor %g, must be more than 1", a.burstFactor)
+	}
+	if a.burstMin < 1 {
+		return nil, fmt.Errorf("invalid --burst-min %d, must be at least 1", a.burstMin)
+	}
+	var err error
+	if a.newIssuerWithin, err = parseDuration(analyzeOpts.newIssuerWithin); err != nil || a.newIssuerWithin <= 0 {
+		return nil, fmt.Errorf("invalid --new-issuer-within %q, e.g. 30d or 2w", analyzeOpts.newIssuerWithin)
+	}
+	if a.shortLived, err = parseDuration(analyzeOpts.shortLived); err != nil || a.shortLived <= 0 {
+		return nil, fmt.Errorf("invalid --short-lived %q, e.g. 6d or 12h", analyzeOpts.shortLived)
+	}
+	return a, nil
+}
+
+// Finding is something suspicious gcrt analyze found
+type Finding struct {
+	Heuristic string `json:"heuristic"`
+	Score     int    `json:"score"`
+	Severity  string `json:"severity"`
+	// Subject is what the finding is about: a day, an issuer, a name or a
+	// cert's common name
+	Subject string `json:"subject"`
+	Detail  string `json:"detail"`
+	Certs   []int  `json:"certs"`
+}
+
+// analysisReport is the gcrt analyze output
+type analysisReport struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Domains     []string  `json:"domains"`
+	Certs       int       `json:"certs"`
+	Findings    []Finding `json:"findings"`
+}
+
+// severityForScore is the severity band a score falls in
+func severityForScore(score int) Severity {
+	s := Severity(score / 25)
+	if s > SeverityCritical {
+		s = SeverityCritical
+	}
+	return s
+}
+
+func newFinding(heuristic string, score int, subject, detail string, ids []int) Finding {
+	if score > 100 {
+		score = 100
+	}
+	sort.Ints(ids)
+	return Finding{Heuristic: heuristic, Score: score, Severity: severityForScore(score).String(), Subject: subject, Detail: detail, Certs: ids}
+}
+
+// analysisDomains are the registrable domains the certs are judged
+// against: those of the domains searched, or for other searches the one
+// most of the names found are under
+func analysisDomains(domains []string, records []record) []string {
+	seen := make(map[string]bool)
+	var bases []string
+	for _, d := range domains {
+		d = strings.TrimLeft(d, "%.")
+		if b := registrableDomain(d); len(b) > 0 && !strings.Contains(b, "%") && !seen[b] {
+			seen[b] = true
+			bases = append(bases, b)
+		}
+	}
+	if len(bases) > 0 {
+		return bases
+	}
+
+	counts := make(map[string]int)
+	best := ""
+	for _, r := range records {
+		for _, n := range r.Names() {
+			b := registrableDomain(n)
+			counts[b]++
+			if counts[b] > counts[best] || (counts[b] == counts[best] && b < best) {
+				best = b
+			}
+		}
+	}
+	if len(best) == 0 {
+		return nil
+	}
+	return []string{best}
+}
+
+// analyse runs every heuristic over the records, highest scoring findings
+// first
+func (a *analysis) analyse(records []record, bases []string) []Finding {
+	var findings []Finding
+	findings = append(findings, a.bursts(records)...)
+	for _, b := range bases {
+		findings = append(findings, a.newIssuers(records, b)...)
+	}
+	findings = append(findings, a.lookalikes(records, bases)...)
+	findings = append(findings, a.shortLivedCerts(records)...)
+
+	kept := findings[:0]
+	for _, f := range findings {
+		if f.Score >= a.minScore {
+			kept = append(kept, f)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		if kept[i].Score != kept[j].Score {
+			return kept[i].Score > kept[j].Score
+		}
+		if kept[i].Heuristic != kept[j].Heuristic {
+			return kept[i].Heuristic < kept[j].Heuristic
+		}
+		return kept[i].Subject < kept[j].Subject
+	})
+	return kept
+}
+
+// bursts flags the days more certs were issued than usual. Days without
+// at least burstHistory of issuance before them aren't judged, as a new
+// domain's first certs are bound to come at once
+func (a *analysis) bursts(records []record) []Finding {
+	var issued []time.Time
+	byDay := make(map[string][]int)
+	for _, r := range records {
+		notBefore, err := r.NotBeforeTime()
+		if err != nil {
+			continue
+		}
+		issued = append(issued, notBefore)
+		day := notBefore.Format("2006-01-02")
+		byDay[day] = append(byDay[day], r.ID)
+	}
+	if len(issued) == 0 {
+		return nil
+	}
+	sort.Slice(issued, func(i, j int) bool { return issued[i].Before(issued[j]) })
+
+	var findings []Finding
+	for day, ids := range byDay {
+		if len(ids) < a.burstMin {
+			continue
+		}
+		start, _ := time.Parse("2006-01-02", day)
+		from := start.Add(-burstHistory)
+		if issued[0].After(from) {
+			continue
+		}
+		before := 0
+		for _, t := range issued {
+			if !t.Before(from) && t.Before(start) {
+				before++
+			}
+		}
+		// a quiet domain is taken to issue a cert over the period, so a
+		// single day's handful isn't an infinite increase
+		average := math.Max(float64(before), 1) / (burstHistory.Hours() / 24)
+		ratio := float64(len(ids)) / average
+		if ratio < a.burstFactor {
+			continue
+		}
+
+		score := 50
+		switch {
+		case ratio >= 4*a.burstFactor:
+			score = 80
+		case ratio >= 2*a.burstFactor:
+			score = 65
+		}
+		if len(ids) >= 4*a.burstMin {
+			score += 10
+		}
+		detail := fmt.Sprintf("%d certs issued against an average of %.2f a day over the 90 days before", len(ids), float64(before)/(burstHistory.Hours()/24))
+		findings = append(findings, newFinding(heuristicBurst, score, day, detail, ids))
+	}
+	return findings
+}
+
+// issuerOrg is the organisation of an issuer_name, or the whole name when
+// it has none, so a CA's intermediates count as one issuer
+func issuerOrg(issuer string) string {
+	for _, part := range strings.Split(issuer, ", ") {
+		if strings.HasPrefix(part, orgPrefix) {
+			return strings.Trim(strings.TrimPrefix(part, orgPrefix), `"`)
+		}
+	}
+	return issuer
+}
+
+// newIssuers flags the CAs that only started issuing certs for base within
+// newIssuerWithin, when others had issued it certs before then
+func (a *analysis) newIssuers(records []record, base string) []Finding {
+	type issuer struct {
+		first time.Time
+		ids   []int
+		valid bool
+	}
+	issuers := make(map[string]*issuer)
+	cutoff := a.now.Add(-a.newIssuerWithin)
+	earlier := 0
+	for _, r := range records {
+		covers := false
+		for _, n := range r.Names() {
+			if registrableDomain(n) == base {
+				covers = true
+				break
+			}
+		}
+		notBefore, err := r.NotBeforeTime()
+		if !covers || err != nil {
+			continue
+		}
+		org := issuerOrg(r.IssuerName)
+		i, ok := issuers[org]
+		if !ok {
+			i = &issuer{first: notBefore}
+			issuers[org] = i
+		}
+		if notBefore.Before(i.first) {
+			i.first = notBefore
+		}
+		i.ids = append(i.ids, r.ID)
+		if notAfter, err := r.NotAfterTime(); err == nil && a.now.Before(notAfter) {
+			i.valid = true
+		}
+		if notBefore.Before(cutoff) {
+			earlier++
+		}
+	}
+	if earlier == 0 {
+		return nil
+	}
+
+	var established []string
+	for org, i := range issuers {
+		if i.first.Before(cutoff) {
+			established = append(established, org)
+		}
+	}
+	sort.Strings(established)
+
+	var findings []Finding
+	for org, i := range issuers {
+		if i.first.Before(cutoff) {
+			continue
+		}
+		score := 60
+		// a domain that sticks to one or two CAs makes a new one stand out
+		if len(established) <= 2 && earlier >= 5 {
+			score += 15
+		}
+		if i.valid {
+			score += 10
+		}
+		detail := fmt.Sprintf("first issued a cert for %s %d day(s) ago, its %d earlier certs came from %s", base, int(a.now.Sub(i.first).Hours()/24), earlier, strings.Join(established, ", "))
+		findings = append(findings, newFinding(heuristicNewIssuer, score, org, detail, i.ids))
+	}
+	return findings
+}
+
+// lookalikeScores are the scores of each kind of lookalike name
+var lookalikeScores = map[string]int{
+	lookalikeHomograph:  90,
+	lookalikeConfusable: 85,
+	lookalikeTypo:       75,
+	lookalikeCombo:      45,
+	lookalikeTLD:        15,
+}
+
+// lookalikes flags the names on the certs that look like one of the bases
+// without being under it
+func (a *analysis) lookalikes(records []record, bases []string) []Finding {
+	type match struct {
+		kind, base string
+		distance   int
+		ids        []int
+		valid      bool
+	}
+	matches := make(map[string]*match)
+	var names []string
+	for _, r := range records {
+		valid := false
+		if notAfter, err := r.NotAfterTime(); err == nil && a.now.Before(notAfter) {
+			valid = true
+		}
+		for _, n := range r.Names() {
+			if m, ok := matches[n]; ok {
+				m.ids = append(m.ids, r.ID)
+				m.valid = m.valid || valid
+				continue
+			}
+			for _, b := range bases {
+				if kind, d := lookalike(n, b); len(kind) > 0 {
+					matches[n] = &match{kind: kind, base: b, distance: d, ids: []int{r.ID}, valid: valid}
+					names = append(names, n)
+					break
+				}
+			}
+		}
+	}
+
+	findings := make([]Finding, 0, len(names))
+	for _, n := range names {
+		m := matches[n]
+		score := lookalikeScores[m.kind]
+		if m.kind == lookalikeTypo && m.distance > 1 {
+			score -= 15
+		}
+		if m.valid {
+			score += 10
+		}
+		detail := fmt.Sprintf("%s lookalike of %s", m.kind, m.base)
+		if m.kind == lookalikeTypo {
+			detail = fmt.Sprintf("typo lookalike of %s, %d edit(s) away", m.base, m.distance)
+		}
+		findings = append(findings, newFinding(heuristicLookalike, score, n, detail, m.ids))
+	}
+	return findings
+}
+
+// shortLivedCerts flags the certs valid for less than shortLived
+func (a *analysis) shortLivedCerts(records []record) []Finding {
+	var findings []Finding
+	for _, r := range records {
+		notBefore, err := r.NotBeforeTime()
+		if err != nil {
+			continue
+		}
+		notAfter, err := r.NotAfterTime()
+		if err != nil {
+			continue
+		}
+		validity := notAfter.Sub(notBefore)
+		if validity >= a.shortLived {
+			continue
+		}
+		score := 30
+		if validity < 24*time.Hour {
+			score = 50
+		}
+		detail := fmt.Sprintf("valid for %s, from %s", validity.Round(time.Minute), r.NotBefore)
+		findings = append(findings, newFinding(heuristicShortLived, score, r.CommonName, detail, []int{r.ID}))
+	}
+	return findings
+}
+
+// writeAnalysis writes the findings for the certs found
+func (o options) writeAnalysis(w io.Writer, records []record) error {
+	a := o.analysis
+	bases := analysisDomains(o.domains, records)
+	findings := a.analyse(records, bases)
+	if o.count {
+		fmt.Fprintf(w, "Number of findings: %d\n", len(findings))
+		return nil
+	}
+	if bases == nil {
+		bases = []string{}
+	}
+	report := analysisReport{GeneratedAt: a.now, Domains: bases, Certs: len(records), Findings: findings}
+	return writeAnalysisReport(w, o.output, report)
+}
+
+// writeAnalysisReport writes the report as JSON, one row per finding for
+// the tabular formats
+func writeAnalysisReport(w io.Writer, format string, report analysisReport) error {
+	header := []string{"score", "severity", "heuristic", "subject", "detail", "certs"}
+	rows := make([][]string, len(report.Findings))
+	for i, f := range report.Findings {
+		ids := make([]string, len(f.Certs))
+		for j, id := range f.Certs {
+			ids[j] = strconv.Itoa(id)
+		}
+		rows[i] = []string{strconv.Itoa(f.Score), f.Severity, f.Heuristic, f.Subject, f.Detail, strings.Join(ids, " ")}
+	}
+
+	switch format {
+	case "csv", "tsv", "table", "markdown":
+		return writeSummaryRows(w, format, header, rows)
+	default:
+		if report.Findings == nil {
+			report.Findings = []Finding{}
+		}
+		output, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	}
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// backoffState is the --backoff-state file format. Domains that keep failing
+// are queried less and less often, so runs over large domain lists don't
+// spend their time on the same problem targets
+type backoffState struct {
+	Domains map[string]*domainBackoff `json:"domains"`
+
+	base, max time.Duration
+	mu        sync.Mutex
+}
+
+type domainBackoff struct {
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error"`
+	LastFailure time.Time `json:"last_failure"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// loadBackoff loads the --backoff-state, or returns nil when there isn't one.
+// A state already loaded, by bulk for all its searches, is used as it is
+func (o options) loadBackoff() (*backoffState, error) {
+	if o.backoff != nil {
+		return o.backoff, nil
+	}
+	if len(o.backoffFile) == 0 {
+		return nil, nil
+	}
+	return loadBackoffState(o.backoffFile, o.backoffBase, o.backoffMax)
+}
+
+func loadBackoffState(path string, base, max time.Duration) (*backoffState, error) {
+	s := &backoffState{Domains: make(map[string]*domainBackoff), base: base, max: max}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parsing backoff state %s: %s", path, err)
+	}
+	if s.Domains == nil {
+		s.Domains = make(map[string]*domainBackoff)
+	}
+	return s, nil
+}
+
+// save writes the state, only keeping the domains that are failing. Saves
+// are one at a time so an older state is never written over a newer one
+func (s *backoffState) save(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// plan returns the domains due a query at now, logging the ones still
+// backing off
+func (s *backoffState) plan(domains []string, now time.Time) (due []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var skipped []string
+	for _, d := range domains {
+		if b, ok := s.Domains[d]; ok && now.Before(b.NextAttempt) {
+			skipped = append(skipped, d)
+			continue
+		}
+		due = append(due, d)
+	}
+
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		log.Warnf("skipping %d domain(s) that keep failing, the first due again is %s", len(skipped), s.firstDue(skipped))
+		for _, d := range skipped {
+			b := s.Domains[d]
+			log.Debugf("skipping %s until %s after %d failures: %s", d, b.NextAttempt.Format(time.RFC3339), b.Failures, b.LastError)
+		}
+	}
+	return due
+}
+
+func (s *backoffState) firstDue(domains []string) string {
+	first := domains[0]
+	for _, d := range domains[1:] {
+		if s.Domains[d].NextAttempt.Before(s.Domains[first].NextAttempt) {
+			first = d
+		}
+	}
+	return fmt.Sprintf("%s at %s", first, s.Domains[first].NextAttempt.Format(time.RFC3339))
+}
+
+// record notes the outcome of querying domain. A domain that fails once is
+// tried again as normal, after that each consecutive failure doubles the
+// wait before the next attempt, starting from the base, up to the maximum
+func (s *backoffState) record(domain string, err error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		delete(s.Domains, domain)
+		return
+	}
+
+	b, ok := s.Domains[domain]
+	if !ok {
+		b = &domainBackoff{}
+		s.Domains[domain] = b
+	}
+	b.Failures++
+	b.LastError = err.Error()
+	b.LastFailure = now
+
+	if b.Failures < 2 {
+		b.NextAttempt = now
+		return
+	}
+	wait := s.base
+	for i := 2; i < b.Failures && wait < s.max; i++ {
+		wait *= 2
+	}
+	if wait > s.max {
+		wait = s.max
+	}
+	b.NextAttempt = now.Add(wait)
+}
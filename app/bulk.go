@@ -0,0 +1,363 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+)
+
+// the statuses of a domain in a bulk run
+const (
+	bulkPending   = "pending"
+	bulkOK        = "ok"
+	bulkNoResults = "no_results"
+	bulkPartial   = "partial"
+	bulkFailed    = "failed"
+)
+
+// bulkSummaryFile is the summary written to --out-dir, which is also how an
+// interrupted run is resumed
+const bulkSummaryFile = "summary.json"
+
+var bulkOpts struct {
+	input   string
+	outDir  string
+	restart bool
+}
+
+var bulkCmd = &cobra.Command{
+	Use:   "bulk",
+	Short: "Search every domain in a file, writing each one's results to its own file",
+	Long: `bulk runs a search like gcrt does for each domain in --input, one per line,
+and writes each domain's results to its own file in --out-dir in the --output
+format. The other flags given to bulk, such as --enrich or --active-only,
+apply to every domain, and --concurrency domains are searched at once.
+
+summary.json in --out-dir lists every domain with its status, the number
+of certs found and its file. It's updated as each domain finishes, and a bulk
+run into the same --out-dir picks up where an interrupted one stopped,
+searching only the domains that are missing, failed or partial. --restart
+searches every domain again`,
+	Example: `  gcrt bulk --input domains.txt --out-dir results/
+  gcrt bulk --input domains.txt --out-dir results/ -o csv --active-only --concurrency 4`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := interruptContext()
+		defer stop()
+		return runBulk(ctx, opts)
+	},
+}
+
+func init() {
+	bulkCmd.Flags().StringVar(&bulkOpts.input, "input", "", "The file of domains to search, one per line, skipping blank lines and # comments")
+	bulkCmd.Flags().StringVar(&bulkOpts.outDir, "out-dir", "", "The directory each domain's results and summary.json are written to")
+	bulkCmd.Flags().BoolVar(&bulkOpts.restart, "restart", false, "Search every domain again rather than resuming from summary.json")
+	cmd.AddCommand(bulkCmd)
+}
+
+// BulkResult is how the search for a domain of a bulk run went
+type BulkResult struct {
+	Domain     string     `json:"domain"`
+	Status     string     `json:"status"`
+	Certs      int64      `json:"certs"`
+	File       string     `json:"file,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// bulkSummary is summary.json
+type bulkSummary struct {
+	StartedAt time.Time    `json:"started_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Format    string       `json:"format"`
+	Domains   int          `json:"domains"`
+	Completed int          `json:"completed"`
+	Failed    int          `json:"failed"`
+	Certs     int64        `json:"certs"`
+	Results   []BulkResult `json:"results"`
+}
+
+// done reports whether a domain needn't be searched again when resuming
+func (r BulkResult) done(dir string) bool {
+	switch r.Status {
+	case bulkNoResults:
+		return true
+	case bulkOK:
+		_, err := os.Stat(filepath.Join(dir, r.File))
+		return err == nil
+	}
+	return false
+}
+
+// unsafeFileChars are replaced in the names of the per-domain files
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// bulkFile is the file a domain's results are written to
+func bulkFile(domain, format string) string {
+	ext := format
+	switch format {
+	case "markdown":
+		ext = "md"
+	case "table":
+		ext = "txt"
+	}
+	return unsafeFileChars.ReplaceAllString(domain, "_") + "." + ext
+}
+
+func runBulk(ctx context.Context, o options) error {
+	if len(bulkOpts.input) == 0 || len(bulkOpts.outDir) == 0 {
+		return errors.New("gcrt bulk needs --input and --out-dir")
+	}
+	if len(o.outFile) > 0 {
+		return errors.New("--out-file can't be used with gcrt bulk, each domain's results are written to --out-dir")
+	}
+	if _, ok := outputFormats[o.output]; !ok {
+		return fmt.Errorf("unknown output format %q, must be one of %s", o.output, outputFormatNames())
+	}
+
+	f, err := os.Open(bulkOpts.input)
+	if err != nil {
+		return err
+	}
+	domains, err := readDomains(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", bulkOpts.input, err)
+	}
+	if len(domains) == 0 {
+		return fmt.Errorf("no domains in %s", bulkOpts.input)
+	}
+	files := make(map[string]string)
+	for _, d := range domains {
+		name := bulkFile(d, o.output)
+		if other, ok := files[name]; ok && other != d {
+			return fmt.Errorf("%s and %s would both be written to %s", other, d, name)
+		}
+		files[name] = d
+	}
+	if err := os.MkdirAll(bulkOpts.outDir, 0755); err != nil {
+		return err
+	}
+
+	// the results of an earlier run, kept for the domains it finished
+	previous := make(map[string]BulkResult)
+	summary := bulkSummary{StartedAt: time.Now().UTC(), Format: o.output, Domains: len(domains)}
+	if !bulkOpts.restart {
+		prev, err := loadBulkSummary(filepath.Join(bulkOpts.outDir, bulkSummaryFile))
+		if err != nil {
+			return err
+		}
+		if prev != nil && prev.Format == o.output {
+			summary.StartedAt = prev.StartedAt
+			for _, r := range prev.Results {
+				if r.done(bulkOpts.outDir) {
+					previous[r.Domain] = r
+				}
+			}
+		}
+	}
+
+	c, closeClient, err := o.sharedClient()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+	o.client = c
+	// the searches share the backoff state, each saving it as it finishes
+	if o.backoff, err = o.loadBackoff(); err != nil {
+		return err
+	}
+
+	summary.Results = make([]BulkResult, len(domains))
+	var todo []int
+	for i, d := range domains {
+		if r, ok := previous[d]; ok {
+			summary.Results[i] = r
+			continue
+		}
+		summary.Results[i] = BulkResult{Domain: d, Status: bulkPending}
+		todo = append(todo, i)
+	}
+	if skipped := len(domains) - len(todo); skipped > 0 {
+		log.Infof("resuming, %d of %d domains were already searched", skipped, len(domains))
+	}
+
+	concurrency := o.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var mu sync.Mutex
+	var saveErr error
+	var wg sync.WaitGroup
+	var finished int64
+	jobs := make(chan int)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				r := bulkSearch(ctx, o, domains[i])
+				// searches cut short by the run being interrupted are
+				// searched again when it's resumed
+				if ctx.Err() != nil {
+					continue
+				}
+				n := atomic.AddInt64(&finished, 1)
+				log.Infof("%s: %s, %d certs (%d of %d)", r.Domain, r.Status, r.Certs, n, len(todo))
+
+				mu.Lock()
+				summary.Results[i] = r
+				if err := saveBulkSummary(bulkOpts.outDir, &summary); err != nil && saveErr == nil {
+					saveErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, i := range todo {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := saveBulkSummary(bulkOpts.outDir, &summary); err != nil {
+		return err
+	}
+	if saveErr != nil {
+		return saveErr
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted, %d of %d domains were searched, run again to resume", summary.Completed+summary.Failed, len(domains))
+	}
+
+	if summary.Failed == len(domains) {
+		return fmt.Errorf("every domain failed, see %s", filepath.Join(bulkOpts.outDir, bulkSummaryFile))
+	}
+	if summary.Failed > 0 {
+		return partialError{failed: int64(summary.Failed), domains: int64(len(domains))}
+	}
+	if summary.Certs == 0 {
+		return errNoResults
+	}
+	return nil
+}
+
+// bulkSearch searches a domain, writing its results to a file in --out-dir.
+// The file is written under a temporary name and only renamed into place
+// once the results are complete, so a file is never half written
+func bulkSearch(ctx context.Context, o options, domain string) BulkResult {
+	o.domains = []string{domain}
+	o.identities, o.orgs, o.stdin = nil, nil, false
+	o.outcome = &runOutcome{}
+	r := BulkResult{Domain: domain, File: bulkFile(domain, o.output)}
+
+	path := filepath.Join(bulkOpts.outDir, r.File)
+	tmp, err := ioutil.TempFile(bulkOpts.outDir, "."+r.File+".")
+	if err == nil {
+		err = runTraced(ctx, o, tmp)
+		if closeErr := tmp.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	finished := time.Now().UTC()
+	r.FinishedAt = &finished
+	r.Certs = atomic.LoadInt64(&o.outcome.found)
+
+	switch {
+	case err == nil:
+		r.Status = bulkOK
+	case err == errNoResults:
+		r.Status = bulkNoResults
+	case exitStatus(err) == exitPartial:
+		r.Status = bulkPartial
+		r.Error = err.Error()
+	default:
+		r.Status = bulkFailed
+		r.Error = err.Error()
+	}
+
+	if r.Status == bulkFailed {
+		r.File = ""
+		if tmp != nil {
+			os.Remove(tmp.Name())
+		}
+		return r
+	}
+	// temporary files are only readable by their owner
+	err = os.Chmod(tmp.Name(), 0644)
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return BulkResult{Domain: domain, Status: bulkFailed, Error: err.Error(), FinishedAt: r.FinishedAt}
+	}
+	return r
+}
+
+func loadBulkSummary(path string) (*bulkSummary, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s bulkSummary
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("reading %s, pass --restart to start over: %s", path, err)
+	}
+	return &s, nil
+}
+
+// saveBulkSummary tallies the results and replaces summary.json
+func saveBulkSummary(dir string, s *bulkSummary) error {
+	s.UpdatedAt = time.Now().UTC()
+	s.Completed, s.Failed, s.Certs = 0, 0, 0
+	for _, r := range s.Results {
+		switch r.Status {
+		case bulkOK, bulkNoResults, bulkPartial:
+			s.Completed++
+		case bulkFailed:
+			s.Failed++
+		}
+		s.Certs += r.Certs
+	}
+
+	output, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+bulkSummaryFile+".")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(output, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, bulkSummaryFile))
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+func TestBulkSharesBackoffState(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+	var domains []string
+	for i := 0; i < 8; i++ {
+		domains = append(domains, fmt.Sprintf("%%.d%d.example.com", i))
+	}
+	input := filepath.Join(dir, "domains.txt")
+	if err := ioutil.WriteFile(input, []byte(strings.Join(domains, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	saved := bulkOpts
+	t.Cleanup(func() { bulkOpts = saved })
+	bulkOpts.input, bulkOpts.outDir, bulkOpts.restart = input, filepath.Join(dir, "out"), true
+
+	o := opts
+	o.client = client.New(client.WithBaseURL(srv.URL), client.WithRetries(0))
+	o.concurrency = 4
+	o.backoffFile = filepath.Join(dir, "backoff.json")
+	if err := runBulk(context.Background(), o); err == nil {
+		t.Fatal("runBulk succeeded, want every domain failing")
+	}
+
+	state, err := loadBackoffState(o.backoffFile, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range domains {
+		if b, ok := state.Domains[d]; !ok || b.Failures != 1 {
+			t.Errorf("%s: backoff = %+v, want 1 failure recorded", d, b)
+		}
+	}
+}
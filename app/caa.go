@@ -0,0 +1,400 @@
+package app
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// the CAA statuses of a cert
+const (
+	caaAuthorized   = "authorized"
+	caaUnauthorized = "unauthorized"
+	caaUnrestricted = "unrestricted"
+	caaPredates     = "predates_caa"
+	caaUnknown      = "unknown"
+)
+
+// caaMandatory is when CAs had to start checking CAA, certs issued before
+// then aren't flagged
+var caaMandatory = time.Date(2017, 9, 8, 0, 0, 0, 0, time.UTC)
+
+// caaTypeCAA is the CAA resource record type, which dnsmessage doesn't know
+const caaTypeCAA dnsmessage.Type = 257
+
+// caaIssuers are the issuer domains CAs publish for CAA records, and what
+// their issuer names contain. --caa-ca adds to them
+var caaIssuers = map[string][]string{
+	"letsencrypt.org":   {"Let's Encrypt"},
+	"digicert.com":      {"DigiCert", "GeoTrust", "RapidSSL", "Thawte", "Symantec"},
+	"symantec.com":      {"Symantec", "DigiCert"},
+	"geotrust.com":      {"GeoTrust", "DigiCert"},
+	"sectigo.com":       {"Sectigo", "COMODO"},
+	"comodoca.com":      {"COMODO", "Sectigo"},
+	"zerossl.com":       {"ZeroSSL"},
+	"pki.goog":          {"Google Trust Services"},
+	"amazon.com":        {"Amazon"},
+	"amazontrust.com":   {"Amazon"},
+	"awstrust.com":      {"Amazon"},
+	"globalsign.com":    {"GlobalSign"},
+	"godaddy.com":       {"GoDaddy", "Starfield"},
+	"starfieldtech.com": {"Starfield"},
+	"entrust.net":       {"Entrust"},
+	"buypass.com":       {"Buypass"},
+	"ssl.com":           {"SSL.com"},
+	"identrust.com":     {"IdenTrust"},
+	"certum.pl":         {"Certum", "Asseco"},
+	"harica.gr":         {"HARICA", "Hellenic Academic"},
+	"microsoft.com":     {"Microsoft"},
+	"apple.com":         {"Apple"},
+}
+
+// caaRecords are the CAA records found at a name
+type caaRecords struct {
+	issue, issuewild       []string
+	hasIssue, hasIssuewild bool
+	// any CAA record, even one without an issue tag, ends the climb
+	found bool
+	err   string
+}
+
+// caaCheck checks the certs' issuers against CAA records
+type caaCheck struct {
+	resolver string
+	timeout  time.Duration
+	issuers  map[string][]string
+}
+
+// newCAACheck reads --caa-resolver and --caa-ca
+func (o options) newCAACheck() (*caaCheck, error) {
+	c := &caaCheck{resolver: o.caaResolver, timeout: o.probeTimeout, issuers: make(map[string][]string)}
+	if len(c.resolver) == 0 {
+		var err error
+		if c.resolver, err = systemNameserver(); err != nil {
+			return nil, fmt.Errorf("finding a DNS server for --check-caa, set --caa-resolver: %s", err)
+		}
+	} else if _, _, err := net.SplitHostPort(c.resolver); err != nil {
+		c.resolver = net.JoinHostPort(c.resolver, "53")
+	}
+
+	for domain, names := range caaIssuers {
+		c.issuers[domain] = names
+	}
+	for _, ca := range o.caaCAs {
+		parts := strings.SplitN(ca, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid --caa-ca %q, must be domain=issuer name", ca)
+		}
+		domain := strings.ToLower(parts[0])
+		c.issuers[domain] = append(c.issuers[domain], parts[1])
+	}
+	return c, nil
+}
+
+// systemNameserver is the first nameserver in /etc/resolv.conf
+func systemNameserver() (string, error) {
+	data, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", errors.New("no nameserver in /etc/resolv.conf")
+}
+
+// caaNames are the names of a cert that CAA applies to, with wildcards
+// checked at the name under them
+func caaNames(r record) []string {
+	var names []string
+	for _, n := range r.Names() {
+		if strings.ContainsAny(n, "@ ") || net.ParseIP(n) != nil {
+			continue
+		}
+		names = append(names, strings.ToLower(strings.TrimSuffix(n, ".")))
+	}
+	return names
+}
+
+// caaTree is name and every domain above it, which are searched in turn
+// for the CAA records that apply to it
+func caaTree(name string) []string {
+	name = strings.TrimPrefix(name, "*.")
+	var tree []string
+	for len(name) > 0 {
+		tree = append(tree, name)
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return tree
+}
+
+// caaStage looks up the CAA records that apply to each name on the certs
+// and flags the certs whose CA the records don't authorize. The records
+// are those published now, DNS keeps no history of them
+func (p *pipeline) caaStage(ctx context.Context, records []record) ([]record, error) {
+	var domains []string
+	for _, r := range records {
+		for _, n := range caaNames(r) {
+			domains = append(domains, caaTree(n)...)
+		}
+	}
+	results := p.lookupAll(ctx, "caa", domains, func(ctx context.Context, domain string) interface{} {
+		return p.caa.lookup(ctx, domain)
+	})
+
+	unauthorized := 0
+	for i := range records {
+		r := &records[i]
+		r.CAAStatus, r.CAAUnauthorizedNames, r.CAAError = p.caa.check(*r, results)
+		if r.CAAStatus == caaUnauthorized {
+			unauthorized++
+		}
+	}
+	if unauthorized > 0 {
+		log.Warnf("%d cert(s) were issued by a CA their names' CAA records don't authorize", unauthorized)
+	}
+	return records, nil
+}
+
+// check works out whether each name on r allows its CA to issue for it
+func (c *caaCheck) check(r record, results map[string]interface{}) (string, []string, string) {
+	names := caaNames(r)
+	if len(names) == 0 {
+		return "", nil, ""
+	}
+
+	var unauthorized, unknown, problems []string
+	restricted := false
+	for _, n := range names {
+		rs, err := relevantCAA(n, results)
+		if err != nil {
+			unknown = append(unknown, n)
+			problems = append(problems, fmt.Sprintf("%s: %s", n, err))
+			continue
+		}
+		// issuewild only applies to wildcards, which fall back to issue, and
+		// a record set without either doesn't restrict issuance
+		allowed, restricts := rs.issue, rs.hasIssue
+		if strings.HasPrefix(n, "*.") && rs.hasIssuewild {
+			allowed, restricts = rs.issuewild, true
+		}
+		if !rs.found || !restricts {
+			continue
+		}
+		restricted = true
+		switch ok, known := c.authorizes(allowed, r.IssuerName); {
+		case ok:
+		case known:
+			unauthorized = append(unauthorized, n)
+		default:
+			unknown = append(unknown, n)
+			problems = append(problems, fmt.Sprintf("%s: can't tell whether %s is one of %s, add it with --caa-ca", n, r.IssuerName, strings.Join(allowed, ", ")))
+		}
+	}
+
+	notBefore, err := r.NotBeforeTime()
+	switch {
+	case len(unauthorized) > 0 && err == nil && notBefore.Before(caaMandatory):
+		return caaPredates, unauthorized, ""
+	case len(unauthorized) > 0:
+		return caaUnauthorized, unauthorized, strings.Join(problems, "; ")
+	case len(unknown) > 0:
+		return caaUnknown, nil, strings.Join(problems, "; ")
+	case !restricted:
+		return caaUnrestricted, nil, ""
+	}
+	return caaAuthorized, nil, ""
+}
+
+// relevantCAA is the first CAA record set found going up from name
+func relevantCAA(name string, results map[string]interface{}) (caaRecords, error) {
+	for _, d := range caaTree(name) {
+		rs, _ := results[d].(caaRecords)
+		if len(rs.err) > 0 {
+			return rs, errors.New(rs.err)
+		}
+		if rs.found {
+			return rs, nil
+		}
+	}
+	return caaRecords{}, nil
+}
+
+// authorizes reports whether an issuer is one of the allowed CAA issuer
+// domains, and whether that's known: an issuer domain gcrt has no issuer
+// names for could be the cert's CA
+func (c *caaCheck) authorizes(allowed []string, issuer string) (ok, known bool) {
+	known = true
+	issuer = strings.ToLower(issuer)
+	for _, domain := range allowed {
+		names, found := c.issuers[domain]
+		if !found {
+			known = false
+			continue
+		}
+		for _, n := range names {
+			if strings.Contains(issuer, strings.ToLower(n)) {
+				return true, true
+			}
+		}
+	}
+	return false, known
+}
+
+// lookup queries the CAA records published at domain
+func (c *caaCheck) lookup(ctx context.Context, domain string) caaRecords {
+	rrs, err := c.query(ctx, domain)
+	if err != nil {
+		return caaRecords{err: err.Error()}
+	}
+	return caaRecordSet(rrs)
+}
+
+// caaRecordSet reads the issue and issuewild tags of the CAA records at a
+// name, ignoring the others
+func caaRecordSet(rrs [][]byte) caaRecords {
+	var rs caaRecords
+	for _, rr := range rrs {
+		tag, value, ok := parseCAA(rr)
+		if !ok {
+			continue
+		}
+		rs.found = true
+		switch tag {
+		case "issue":
+			rs.hasIssue = true
+			if len(value) > 0 {
+				rs.issue = append(rs.issue, value)
+			}
+		case "issuewild":
+			rs.hasIssuewild = true
+			if len(value) > 0 {
+				rs.issuewild = append(rs.issuewild, value)
+			}
+		}
+	}
+	sort.Strings(rs.issue)
+	sort.Strings(rs.issuewild)
+	return rs
+}
+
+// parseCAA reads the tag and issuer domain of a CAA record, dropping any
+// parameters after the domain
+func parseCAA(data []byte) (tag, value string, ok bool) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return "", "", false
+	}
+	tag = strings.ToLower(string(data[2 : 2+data[1]]))
+	value = string(data[2+data[1]:])
+	if i := strings.Index(value, ";"); i >= 0 {
+		value = value[:i]
+	}
+	return tag, strings.ToLower(strings.TrimSpace(value)), true
+}
+
+// query asks the resolver for the CAA records at domain, returning the
+// data of each. A domain that doesn't exist has none
+func (c *caaCheck) query(ctx context.Context, domain string) ([][]byte, error) {
+	name, err := dnsmessage.NewName(domain + ".")
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	msg, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: caaTypeCAA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	resp, err := c.exchange(ctx, "udp", msg)
+	if err == nil && resp.Truncated {
+		resp, err = c.exchange(ctx, "tcp", msg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != id {
+		return nil, errors.New("mismatched DNS response")
+	}
+
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("looking up CAA: %s", resp.RCode)
+	}
+	var rrs [][]byte
+	for _, a := range resp.Answers {
+		if u, ok := a.Body.(*dnsmessage.UnknownResource); ok && a.Header.Type == caaTypeCAA {
+			rrs = append(rrs, u.Data)
+		}
+	}
+	return rrs, nil
+}
+
+// exchange sends a DNS query over network and reads the response
+func (c *caaCheck) exchange(ctx context.Context, network string, msg []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, c.resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	buf := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		framed := make([]byte, 2+len(msg))
+		binary.BigEndian.PutUint16(framed, uint16(len(msg)))
+		copy(framed[2:], msg)
+		if _, err := conn.Write(framed); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int(binary.BigEndian.Uint16(buf))
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		if n, err = conn.Read(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf[:n]); err != nil {
+		return nil, fmt.Errorf("parsing DNS response: %s", err)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,62 @@
+package app
+
+import "testing"
+
+// caaRR is the data of a CAA record with no flags
+func caaRR(tag, value string) []byte {
+	return append([]byte{0, byte(len(tag))}, tag+value...)
+}
+
+func TestCAACheck(t *testing.T) {
+	c := &caaCheck{issuers: caaIssuers}
+	for _, tc := range []struct {
+		name    string
+		cert    string
+		records map[string][][]byte
+		want    string
+	}{
+		{"no records", "www.example.com", nil, caaUnrestricted},
+		{"issue allows", "www.example.com", map[string][][]byte{
+			"example.com": {caaRR("issue", "letsencrypt.org")},
+		}, caaAuthorized},
+		{"issue forbids", "www.example.com", map[string][][]byte{
+			"example.com": {caaRR("issue", "digicert.com")},
+		}, caaUnauthorized},
+		{"issue forbids all", "www.example.com", map[string][][]byte{
+			"example.com": {caaRR("issue", ";")},
+		}, caaUnauthorized},
+		{"issuewild only, non-wildcard", "www.example.com", map[string][][]byte{
+			"example.com": {caaRR("issuewild", "digicert.com")},
+		}, caaUnrestricted},
+		{"issuewild only, wildcard", "*.example.com", map[string][][]byte{
+			"example.com": {caaRR("issuewild", "digicert.com")},
+		}, caaUnauthorized},
+		{"issuewild overrides issue", "*.example.com", map[string][][]byte{
+			"example.com": {caaRR("issue", "digicert.com"), caaRR("issuewild", "letsencrypt.org")},
+		}, caaAuthorized},
+		{"wildcard falls back to issue", "*.example.com", map[string][][]byte{
+			"example.com": {caaRR("issue", "digicert.com")},
+		}, caaUnauthorized},
+		{"iodef only stops the climb", "www.example.com", map[string][][]byte{
+			"www.example.com": {caaRR("iodef", "mailto:security@example.com")},
+			"example.com":     {caaRR("issue", "digicert.com")},
+		}, caaUnrestricted},
+		{"unknown tag only stops the climb", "www.example.com", map[string][][]byte{
+			"www.example.com": {caaRR("contactemail", "security@example.com")},
+			"example.com":     {caaRR("issue", "digicert.com")},
+		}, caaUnrestricted},
+		{"closest record set applies", "www.example.com", map[string][][]byte{
+			"www.example.com": {caaRR("issue", "letsencrypt.org")},
+			"example.com":     {caaRR("issue", "digicert.com")},
+		}, caaAuthorized},
+	} {
+		results := make(map[string]interface{})
+		for _, d := range caaTree(tc.cert) {
+			results[d] = caaRecordSet(tc.records[d])
+		}
+		r := record{CertResponse: testCert(1, tc.cert)}
+		if got, _, problems := c.check(r, results); got != tc.want {
+			t.Errorf("%s: status = %q (%s), want %q", tc.name, got, problems, tc.want)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package app
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/certstream"
+	"github.com/jhinds/gcrt/client"
+	"github.com/spf13/cobra"
+)
+
+// certstreamSeenLimit is how many certs are remembered to skip the copies
+// added to other logs. Past it the oldest half are forgotten
+const certstreamSeenLimit = 100000
+
+// the bounds of the wait before reconnecting to CertStream
+const (
+	certstreamRetryMin = time.Second
+	certstreamRetryMax = time.Minute
+)
+
+var certstreamOpts struct {
+	url string
+}
+
+var certstreamCmd = &cobra.Command{
+	Use:   "stream",
+	Short: "Report certificates for the given domains as they're added to the CT logs",
+	Long: `stream follows the live CertStream feed of every certificate added to the
+Certificate Transparency logs and outputs the ones with a name matching the
+given domains, where % is a wildcard as it is for crt.sh, seconds after
+they're logged rather than when crt.sh has caught up. --org matches the
+subject organisation instead. Certs found this way have no crt.sh id, their
+crt_sh_link looks them up by fingerprint`,
+	Example: `  gcrt stream -d %.example.com -o ndjson
+  gcrt stream -d %.example.com --url wss://certstream.calidog.io/full-stream --enrich x509 --slack-webhook https://hooks.slack.com/...`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runCertstream(ctx, opts)
+	},
+}
+
+func init() {
+	certstreamCmd.Flags().StringVar(&certstreamOpts.url, "url", certstream.DefaultURL, "The CertStream server to follow. Its full stream, e.g. wss://certstream.calidog.io/full-stream, includes the certs themselves so enrichments don't have to download them")
+	addNotifyFlags(certstreamCmd)
+	cmd.AddCommand(certstreamCmd)
+}
+
+// streamPattern matches the names, or the subject organisation for an
+// --org, of streamed certs against a target
+type streamPattern struct {
+	target string
+	org    bool
+	re     *regexp.Regexp
+}
+
+// newStreamPattern compiles a target, treating % as a wildcard and ignoring
+// case like crt.sh does
+func newStreamPattern(target string) streamPattern {
+	q := targetQuery(client.Query{}, target)
+	parts := strings.Split(strings.ToLower(q.Domain), "%")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return streamPattern{
+		target: target,
+		org:    q.Field == "O",
+		re:     regexp.MustCompile("^" + strings.Join(parts, ".*") + "$"),
+	}
+}
+
+func (p streamPattern) matches(e certstream.Entry) bool {
+	if p.org {
+		return p.re.MatchString(strings.ToLower(e.LeafCert.Subject.O))
+	}
+	for _, n := range e.LeafCert.AllDomains {
+		if p.re.MatchString(strings.ToLower(n)) {
+			return true
+		}
+	}
+	return false
+}
+
+// certstreamer outputs the streamed certs that match its patterns
+type certstreamer struct {
+	o         options
+	q         client.Query
+	patterns  []streamPattern
+	notifiers []notifier
+	rd        *redactor
+	enrich    *pipeline
+
+	// the certs already output, as two generations so the oldest can be
+	// forgotten
+	seen, seenBefore map[string]bool
+}
+
+func runCertstream(ctx context.Context, o options) error {
+	if o.output == "xlsx" {
+		return fmt.Errorf("stream can't write xlsx output, as it appends each cert as it's found")
+	}
+	targets, err := o.targets(os.Stdin)
+	if err != nil {
+		return err
+	}
+	q, err := o.query()
+	if err != nil {
+		return err
+	}
+	ns, err := notifiers()
+	if err != nil {
+		return err
+	}
+	rd, err := o.redactor()
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	// the client is only used by enrichments that need crt.sh
+	c, closeClient, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+	p, err := o.pipeline(c)
+	if err != nil {
+		return err
+	}
+
+	cs := &certstreamer{o: o, q: q, notifiers: ns, rd: rd, enrich: p, seen: make(map[string]bool)}
+	for _, t := range targets {
+		cs.patterns = append(cs.patterns, newStreamPattern(t))
+	}
+
+	// --max-duration bounds the whole stream, for running it from cron
+	if o.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.maxDuration)
+		defer cancel()
+	}
+
+	so := certstream.Options{
+		URL:         certstreamOpts.url,
+		UserAgent:   o.userAgent,
+		DialTimeout: o.timeout,
+		TLSConfig:   tlsConfig,
+	}
+	wait := certstreamRetryMin
+	for {
+		log.Infof("following %s for %d target(s)", so.URL, len(cs.patterns))
+		received := false
+		err := certstream.Stream(ctx, so, func(e certstream.Entry) error {
+			received = true
+			return cs.handle(ctx, e)
+		})
+		if ctx.Err() != nil {
+			log.Info("shutting down")
+			return nil
+		}
+		if _, isOutput := err.(outputError); isOutput {
+			return err
+		}
+		// a connection that worked for a while starts over
+		if received {
+			wait = certstreamRetryMin
+		}
+		log.WithError(err).Errorf("CertStream connection failed, reconnecting in %s", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+		if wait *= 2; wait > certstreamRetryMax {
+			wait = certstreamRetryMax
+		}
+	}
+}
+
+// outputError is a failure to write or notify that ends the stream, rather
+// than being retried by reconnecting
+type outputError struct{ error }
+
+// handle outputs an entry if it matches one of the patterns and hasn't been
+// output before
+func (cs *certstreamer) handle(ctx context.Context, e certstream.Entry) error {
+	target := ""
+	for _, p := range cs.patterns {
+		if p.matches(e) {
+			target = p.target
+			break
+		}
+	}
+	if len(target) == 0 {
+		return nil
+	}
+
+	r := streamRecord(e)
+	if !cs.q.Matches(r.CertResponse) {
+		return nil
+	}
+	if key := cs.dedupeKey(r); len(key) > 0 {
+		if cs.seen[key] || cs.seenBefore[key] {
+			return nil
+		}
+		if len(cs.seen) >= certstreamSeenLimit/2 {
+			cs.seen, cs.seenBefore = make(map[string]bool), cs.seen
+		}
+		cs.seen[key] = true
+	}
+	if len(cs.patterns) > 1 {
+		r.SourceDomain = target
+	}
+
+	if err := cs.emit(ctx, []record{r}); err != nil {
+		return outputError{err}
+	}
+	return nil
+}
+
+// dedupeKey is streaming's take on --dedupe-key. The same cert is added to
+// several logs, and without a crt.sh id its fingerprint identifies it
+func (cs *certstreamer) dedupeKey(r record) string {
+	switch cs.q.Dedupe {
+	case client.DedupeNone:
+		return ""
+	case client.DedupeID:
+		return r.SHA1Fingerprint
+	case client.DedupeSerial:
+		return r.IssuerName + "/" + r.SerialNumber
+	}
+	return r.NameValue + r.NotBefore
+}
+
+func (cs *certstreamer) emit(ctx context.Context, records []record) error {
+	records, err := cs.o.filter(records)
+	if err != nil {
+		return err
+	}
+	if records, err = cs.enrich.run(ctx, records); err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	cs.rd.apply(records)
+
+	out, err := cs.o.openOutput(true)
+	if err != nil {
+		return err
+	}
+	if err := cs.o.write(out, records); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// a failed alert is logged rather than stopping the stream, the cert
+	// has been output
+	if err := notifyAll(ctx, cs.notifiers, records); err != nil {
+		log.WithError(err).Error("notification failed")
+	}
+	return nil
+}
+
+// streamRecord converts a CertStream entry to the crt.sh fields gcrt
+// outputs. The names are deduplicated and lowercased like crt.sh's
+func streamRecord(e certstream.Entry) record {
+	leaf := e.LeafCert
+	var names []string
+	seen := make(map[string]bool)
+	for _, n := range leaf.AllDomains {
+		if n = strings.ToLower(n); !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+
+	r := record{CertResponse: client.CertResponse{
+		IssuerName:     leaf.Issuer.String(),
+		CommonName:     strings.ToLower(leaf.Subject.CN),
+		NameValue:      strings.Join(names, "\n"),
+		EntryTimestamp: certstream.Time(e.Seen).Format(client.TimeLayout),
+		NotBefore:      certstream.Time(leaf.NotBefore).Format(client.TimeLayout),
+		NotAfter:       certstream.Time(leaf.NotAfter).Format(client.TimeLayout),
+		SerialNumber:   strings.ToLower(leaf.SerialNumber),
+	}}
+	r.Source = "certstream"
+	r.CTLog = e.Source.URL
+	r.CTLogIndex = e.CertIndex
+	r.SHA1Fingerprint = strings.ToLower(strings.Replace(leaf.Fingerprint, ":", "", -1))
+
+	switch e.UpdateType {
+	case certstream.UpdatePrecertificate:
+		r.EntryType = entryPrecertificate
+	case certstream.UpdateCertificate:
+		r.EntryType = entryCertificate
+	}
+
+	// the full stream includes the cert, which saves the enrichments that
+	// need it from downloading
+	der, err := leaf.DER()
+	if err != nil {
+		log.WithError(err).Debugf("decoding the streamed cert %s", r.SHA1Fingerprint)
+	}
+	if len(der) > 0 {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			r.cert = cert
+			if len(r.SHA1Fingerprint) == 0 {
+				sum := sha1.Sum(der)
+				r.SHA1Fingerprint = hex.EncodeToString(sum[:])
+			}
+		}
+	}
+	return r
+}
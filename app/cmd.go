@@ -1,18 +1,16 @@
 package app
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
 	"os"
-	"regexp"
-	"strconv"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/cli"
-	"github.com/hashicorp/go-retryablehttp"
+	"github.com/apex/log/handlers/discard"
 	"github.com/spf13/cobra"
 )
 
@@ -22,174 +20,200 @@ var cmd = &cobra.Command{
 	Long: `gcrt is a tool to query the Certificate Transparency Logs
 				  it does so by querying https://crt.sh
 				  Complete documentation is available at https://github.com/jhinds/gcrt`,
-	Run: func(cmd *cobra.Command, args []string) {
-		GetCerts()
-	},
-}
-
-// Execute runs the application
-func Execute() {
-	log.SetHandler(cli.New(os.Stderr))
-	if err := cmd.Execute(); err != nil {
-		log.Fatal(err.Error())
-	}
-}
-
-const gcrtURL = "https://crt.sh"
-
-var (
-	domain  string
-	between string
-	days    int
-	count   bool
-)
-
-func init() {
-	cmd.PersistentFlags().StringVar(&between, "between", "", "The dates to run the query for in the format start-date:end-date.  The dates should have the format YYYY-MM-DD")
-	cmd.PersistentFlags().BoolVarP(&count, "count", "c", false, "Don't return the results just the count")
-	cmd.PersistentFlags().IntVar(&days, "days", -1, "How many days back to query")
-	cmd.PersistentFlags().StringVarP(&domain, "domain", "d", "", "Domain to find certificates for. % is a wildcard")
-	cmd.MarkPersistentFlagRequired("domain")
-}
-
-// GetCerts will query the Certificate logs and return the result
-func GetCerts() {
-	cleanDomain := strings.Replace(domain, "%", "%25", -1)
-	url := fmt.Sprintf("%s/?q=%s&output=json", gcrtURL, cleanDomain)
-	client := retryablehttp.NewClient()
-	client.HTTPClient = &http.Client{
-		Timeout: time.Second * 30,
-	}
-	client.Logger = nil
-	resp, err := client.Get(url)
-	if err != nil {
-		log.WithError(err).Fatal("Error Getting Response")
-	}
-	defer resp.Body.Close()
-	dec := json.NewDecoder(resp.Body)
-
-	certs := make([]CertResponse, 0)
-
-	// The crt.sh API is a little funky... It returns multiple
-	// JSON objects with no delimiter, so you just have to keep
-	// attempting a decode until you hit EOF
-	for {
-		var c []CertResponse
-
-		decodeErr := dec.Decode(&c)
-		if decodeErr != nil {
-			break
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return err
 		}
-
-		certs = append(certs, c...)
-	}
-
-	// remove duplicate certs since crt.sh returns both the leaf certificate and precertificate
-	certs = removeDuplicateCerts(certs)
-
-	// outputCerts will hold remaining certs after date filtering (if requested)
-	var outputCerts []CertResponse
-
-	if len(between) > 0 { // filter by date range
-		bDates := reSubMatchMap(`(?P<startdate>\d{4}-\d{2}-\d{2}):(?P<enddate>\d{4}-\d{2}-\d{2})`, between)
-
-		var startDate, endDate time.Time
-
-		if d, ok := bDates["startdate"]; ok {
-			startDate, err = time.Parse("2006-01-02", d)
-			if err != nil {
-				log.WithError(err).Fatal("Error parsing start date")
-			}
-		} else {
-			log.Fatal("start date not provided in valid format")
+		switch {
+		case opts.quiet:
+			log.SetHandler(discard.Default)
+		case opts.verbosity > 0:
+			log.SetLevel(log.DebugLevel)
 		}
-		if d, ok := bDates["enddate"]; ok {
-			endDate, err = time.Parse("2006-01-02", d)
-			if err != nil {
-				log.WithError(err).Fatal("Error parsing end date")
-			}
-			endDate = endDate.Add(23*time.Hour + 59*time.Minute + 59*time.Second)
-		} else {
-			log.Fatal("end date not provided in valid format")
+		if opts.showProgress && !opts.quiet {
+			opts.progress = newProgressBar(os.Stderr)
+			log.SetHandler(opts.progress.handler(cli.New(os.Stderr)))
 		}
 
-		for _, c := range certs {
-			certDate, certParseErr := time.Parse("2006-01-02T15:04:05", c.NotBefore)
-
-			if certParseErr != nil {
-				log.WithError(certParseErr).Errorf("error parsing date in cert %d", c.ID)
-				continue
-			}
-
-			if certDate.After(startDate) && certDate.Before(endDate) {
-				outputCerts = append(outputCerts, c)
+		// --output wins over the format implied by --out-file
+		if len(opts.outFile) > 0 && !cmd.Flags().Changed("output") {
+			switch format := formatForFile(opts.outFile); {
+			case format == formatSQLite:
+				// only a search and gcrt export add results to a database
+				if cmd.HasParent() && cmd.Name() != "export" {
+					return fmt.Errorf("gcrt %s can't write --out-file %s, only gcrt and gcrt export add results to a SQLite database", cmd.Name(), opts.outFile)
+				}
+				opts.sqlite = opts.outFile
+			case len(format) > 0:
+				opts.output = format
 			}
 		}
-	} else if days > 0 { // filter certs by days ago threshold
-		now := time.Now()
-		thresholdDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days)
-		for _, c := range certs {
-			certDate, certParseErr := time.Parse("2006-01-02T15:04:05", c.NotBefore)
-			if certParseErr != nil {
-				log.WithError(certParseErr).Errorf("error parsing date in cert %d", c.ID)
-				continue
-			}
-
-			// set the certficate not before date to midnight in local timezone
-			certDate = time.Date(certDate.Year(), certDate.Month(), certDate.Day(), 0, 0, 0, 0, now.Location())
-			if thresholdDate == certDate || certDate.After(thresholdDate) {
-				outputCerts = append(outputCerts, c)
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prov, err := opts.startProvenance(cmd)
+		if err != nil {
+			return err
+		}
+		out, err := opts.openOutput(false)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		runErr := runTraced(ctx, opts, out)
+		if err := out.Close(); err != nil && runErr == nil {
+			runErr = err
+		}
+		if prov != nil {
+			if err := prov.finish(runErr); err != nil {
+				return err
 			}
 		}
-	} else {
-		outputCerts = certs
-	}
-
-	if count {
-		fmt.Printf("Number of certs found: %d\n", len(outputCerts))
-		return
-	}
-	if len(outputCerts) > 1 {
-		output, _ := json.MarshalIndent(&outputCerts, "", "    ")
-		fmt.Println(string(output))
-	}
+		return runErr
+	},
 }
 
-type enrichedCertResponse CertResponse
-
-// MarshalJSON adds in a link to the crt.sh page for each cert
-func (c CertResponse) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		CertShLink string `json:"crt_sh_link"`
-		enrichedCertResponse
-	}{
-		CertShLink:           `https://crt.sh/?id=` + strconv.Itoa(c.ID),
-		enrichedCertResponse: enrichedCertResponse(c),
-	})
+// interruptContext is cancelled by the first SIGINT or SIGTERM, so a run
+// stopped part way still writes out what it had found. Signals after that
+// stop gcrt as usual
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			log.Warn("interrupted, writing out the results found so far, interrupt again to quit")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
 }
 
-func removeDuplicateCerts(certs []CertResponse) []CertResponse {
-	m := make(map[string]struct{})
-	dedupedCerts := make([]CertResponse, 0)
-
-	for _, c := range certs {
-		// keep the first cert which is the leaf certificate
-		if _, ok := m[c.NameValue+c.NotBefore]; !ok {
-			m[c.NameValue+c.NotBefore] = struct{}{}
-			dedupedCerts = append(dedupedCerts, c)
+// Execute runs the application
+func Execute() {
+	log.SetHandler(cli.New(os.Stderr))
+	err := cmd.Execute()
+	opts.progress.finish()
+	if err != nil {
+		// finding nothing is told by the exit status alone
+		if err != errNoResults {
+			log.Error(err.Error())
 		}
+		os.Exit(exitStatus(err))
 	}
-	return dedupedCerts
 }
 
-func reSubMatchMap(regEx, text string) (groupMatchMap map[string]string) {
-	compRegEx := regexp.MustCompile(regEx)
-	match := compRegEx.FindStringSubmatch(text)
-	groupMatchMap = make(map[string]string)
-	for i, name := range compRegEx.SubexpNames() {
-		if i > 0 && i <= len(match) {
-			groupMatchMap[name] = match[i]
-		}
-	}
-	return
+func init() {
+	cmd.PersistentFlags().StringVar(&opts.between, "between", "", "Only return certs whose not_before is in this range, start:end, with dates as YYYY-MM-DD (whole days, UTC) or RFC 3339. Either end may be left out, e.g. 2024-01-01: for since")
+	cmd.PersistentFlags().StringVar(&opts.notAfterBetween, "not-after-between", "", "Only return certs whose not_after is in this range, in the format of --between")
+	cmd.PersistentFlags().BoolVarP(&opts.count, "count", "c", false, "Don't return the results just the count")
+	cmd.PersistentFlags().BoolVar(&opts.strict, "strict", false, "Fail rather than output partial results when a crt.sh response is cut off, and don't retry searches crt.sh times out on with fewer results")
+	cmd.PersistentFlags().BoolVarP(&opts.quiet, "quiet", "q", false, "Don't log anything, not even errors, or draw --progress, leaving only the results on stdout and the exit status")
+	cmd.PersistentFlags().CountVarP(&opts.verbosity, "verbose", "v", "Log more: -v adds debug messages such as how long each search and enrichment took, -vv every request made as well")
+	cmd.PersistentFlags().BoolVar(&opts.showProgress, "progress", false, "Draw the progress of the searches on stderr: the domains searched and how much of each response has been read")
+	cmd.PersistentFlags().StringVarP(&opts.output, "output", "o", "json", "Output format: "+outputFormatNames())
+	cmd.PersistentFlags().StringSliceVar(&opts.fields, "fields", nil, "The fields to output, in order, e.g. id,common_name,not_after")
+	cmd.PersistentFlags().StringVar(&opts.template, "template", "", "Output each cert as a line of this Go template instead of --output, e.g. '{{.CommonName}},{{.NotAfter}}'")
+	cmd.PersistentFlags().StringVar(&opts.sortField, "sort", "", "Order the certs by not_before, not_after, entry_timestamp, issuer_name, common_name or id, oldest or lowest first, instead of the order crt.sh returns them in")
+	cmd.PersistentFlags().BoolVar(&opts.reverse, "reverse", false, "Reverse the order of the certs, newest or highest first with --sort")
+	cmd.PersistentFlags().BoolVar(&opts.envelope, "envelope", false, "Wrap JSON output in an object carrying the schema version, see gcrt schema")
+	cmd.PersistentFlags().StringSliceVar(&opts.redact, "redact", nil, "Replace these fields with REDACTED in the output and notifications, e.g. serial_number,sha256_fingerprint")
+	cmd.PersistentFlags().StringArrayVar(&opts.redactPatterns, "redact-pattern", nil, "Replace text matching this regex with REDACTED in every field of the output and notifications (may be repeated)")
+	cmd.PersistentFlags().StringVar(&opts.permutationsFile, "permutations-file", "", "Query every domain in a dnstwist (CSV, JSON or list) or urlcrazy (CSV) permutation file, grouping the results by permutation")
+	cmd.PersistentFlags().IntVar(&opts.batchSize, "batch-size", 25, "How many permutations to query before pausing for --batch-delay")
+	cmd.PersistentFlags().DurationVar(&opts.batchDelay, "batch-delay", 5*time.Second, "How long to pause between batches of permutations")
+	cmd.PersistentFlags().StringVar(&opts.outFile, "out-file", "", "Write results to this file instead of stdout")
+	cmd.PersistentFlags().BoolVar(&opts.manifest, "manifest", false, "Write <out-file>.manifest.json recording the SHA-256 of --out-file and how the run was made, see gcrt verify")
+	cmd.PersistentFlags().StringVar(&opts.signKey, "sign-key", "", "Sign the --manifest with this Ed25519 private key from gcrt keygen, writing <out-file>.manifest.json.sig")
+	cmd.PersistentFlags().StringVar(&opts.rotateSize, "rotate-max-size", "", "Append to --out-file, rotating it before it grows past this size, e.g. 100MB")
+	cmd.PersistentFlags().StringVar(&opts.rotateAge, "rotate-max-age", "", "Append to --out-file, deleting rotated files older than this, e.g. 30d")
+	cmd.PersistentFlags().IntVar(&opts.rotateKeep, "rotate-keep", 0, "Append to --out-file, keeping at most this many rotated files")
+	cmd.PersistentFlags().BoolVar(&opts.rotateCompress, "rotate-compress", false, "Append to --out-file, gzipping rotated files")
+	cmd.PersistentFlags().IntVar(&opts.days, "days", -1, "How many days back to query")
+	cmd.PersistentFlags().StringSliceVarP(&opts.domains, "domain", "d", nil, "Domain to find certificates for. % is a wildcard.  May be repeated or comma separated, - reads domains from stdin")
+	cmd.PersistentFlags().BoolVar(&opts.stdin, "stdin", false, "Read domains to query from stdin, one per line")
+	cmd.PersistentFlags().StringArrayVar(&opts.orgs, "org", nil, "Find certificates issued to this organisation name, whatever domains they're for.  % is a wildcard (may be repeated)")
+	cmd.PersistentFlags().StringArrayVar(&opts.identities, "identity", nil, "Find certificates with this identity, matched by crt.sh against every name, email and organisation in a cert (may be repeated)")
+	cmd.PersistentFlags().BoolVar(&opts.autoExpand, "auto-expand", false, "Search what was likely meant for domains that probably miss results, e.g. %.example.com as well as example.com")
+	cmd.PersistentFlags().IntVar(&opts.concurrency, "concurrency", 4, "How many requests to make to crt.sh at once")
+	cmd.PersistentFlags().StringSliceVar(&opts.sources, "source", []string{"crtsh"}, "Where to search: crtsh, censys, facebook or ctlog (may be repeated, the results are merged keeping the first source's copy of each cert)")
+	cmd.PersistentFlags().StringSliceVar(&opts.ctLogs, "ct-log", nil, "The URL of a CT log for --source ctlog to read, e.g. https://ct.googleapis.com/logs/us1/argon2025h2 (may be repeated)")
+	cmd.PersistentFlags().Int64Var(&opts.ctLogEntries, "ct-log-entries", 10000, "How many of the latest entries of each --ct-log to read")
+	cmd.PersistentFlags().BoolVar(&opts.shard, "shard", false, "Split queries starting with % into one query per first character of the name, searched --concurrency at a time, for domains with too many certs for a single query")
+	cmd.PersistentFlags().IntVar(&opts.sample, "sample", 0, "Return a sample of this many certs per domain, the newest, the oldest and a random mix, for a quick look at large result sets")
+	cmd.PersistentFlags().IntVar(&opts.sampleScan, "sample-scan", 10000, "Stop reading the results of a --sample query after this many certs, 0 to read them all")
+	cmd.PersistentFlags().StringVar(&opts.dedupeKey, "dedupe-key", "name-date", "How the precertificate and leaf entries crt.sh returns for a cert are recognised so only the first is kept: name-date, which can merge distinct certs with the same names and dates, serial or id")
+	cmd.PersistentFlags().BoolVar(&opts.noDedupe, "no-dedupe", false, "Keep every entry crt.sh returns, even repeated ones")
+	cmd.PersistentFlags().BoolVar(&opts.includePrecerts, "include-precerts", false, "Keep precertificates as well as their leaf certificates, see --enrich precerts to tell them apart")
+	cmd.PersistentFlags().BoolVar(&opts.precertsOnly, "precerts-only", false, "Only return precertificates, downloading each cert to check")
+	cmd.PersistentFlags().BoolVar(&opts.excludeExpired, "exclude-expired", false, "Have crt.sh leave out expired certs, which can shrink the response a great deal on busy domains")
+	cmd.PersistentFlags().BoolVar(&opts.serverDedupe, "server-dedupe", false, "Have crt.sh drop the precertificates of leaf certs before answering, rather than gcrt after")
+	cmd.PersistentFlags().StringArrayVar(&opts.crtshParams, "crtsh-param", nil, "Send this key=value parameter with crt.sh searches, e.g. match=LIKE (may be repeated)")
+	cmd.PersistentFlags().StringVar(&opts.backoffFile, "backoff-state", "", "File recording the domains that keep failing, so later runs query them less often")
+	cmd.PersistentFlags().DurationVar(&opts.backoffBase, "backoff-base", time.Hour, "How long a domain is skipped after failing twice in a row, doubling with each further failure")
+	cmd.PersistentFlags().DurationVar(&opts.backoffMax, "backoff-max", 7*24*time.Hour, "The longest a failing domain is skipped for")
+	cmd.PersistentFlags().BoolVar(&opts.aggregate, "aggregate", false, "Return one record per name with the first and last time it was seen instead of the certificates")
+	cmd.PersistentFlags().BoolVar(&opts.namesOnly, "names-only", false, "Print the distinct hostnames found, one per line, instead of the certificates")
+	cmd.PersistentFlags().BoolVar(&opts.wildcardsOnly, "wildcards-only", false, "Only return certs with a wildcard name, e.g. *.example.com")
+	cmd.PersistentFlags().BoolVar(&opts.wildcardSummary, "wildcard-summary", false, "List each wildcard name found with the certs for it, who issued them and when they expire, instead of the certificates")
+	cmd.PersistentFlags().BoolVar(&opts.keepWildcards, "keep-wildcards", false, "Keep the *. prefix of wildcard names with --names-only")
+	cmd.PersistentFlags().StringSliceVar(&opts.registrable, "registrable", nil, "Only return certs with a name under one of these registrable domains (eTLD+1)")
+	cmd.PersistentFlags().BoolVar(&opts.groupRegistrable, "group-by-registrable", false, "Group the hostnames found by registrable domain (eTLD+1) instead of returning the certificates")
+	cmd.PersistentFlags().StringArrayVar(&opts.issuers, "issuer", nil, "Only return certs whose issuer name contains this, or matches it written as /regex/ (may be repeated)")
+	cmd.PersistentFlags().StringArrayVar(&opts.matchNames, "match", nil, "Only return certs with a name matching this regular expression, e.g. 'vpn|gateway', or glob, e.g. 'glob:*.corp.example.com' (may be repeated)")
+	cmd.PersistentFlags().StringArrayVar(&opts.excludeNames, "exclude", nil, "Drop certs whose names all match this regular expression or glob, e.g. 'glob:*.dev.example.com' (may be repeated)")
+	cmd.PersistentFlags().StringArrayVar(&opts.excludeIssuers, "exclude-issuer", nil, "Drop certs whose issuer name contains this, or matches it written as /regex/ (may be repeated)")
+	cmd.PersistentFlags().IntSliceVar(&opts.issuerCAIDs, "issuer-ca-id", nil, "Only return certs issued by the CA with this crt.sh id (may be repeated)")
+	cmd.PersistentFlags().BoolVar(&opts.expired, "expired", false, "Only return certs that have expired")
+	cmd.PersistentFlags().BoolVar(&opts.activeOnly, "active-only", false, "Only return certs that are currently valid")
+	cmd.PersistentFlags().StringVar(&opts.expiringWithin, "expiring-within", "", "Only return currently valid certs that expire within this long, e.g. 30d")
+	cmd.PersistentFlags().BoolVar(&opts.resolve, "resolve", false, "Resolve the A, AAAA and CNAME records of every name on the certs")
+	cmd.PersistentFlags().BoolVar(&opts.onlyLive, "only-live", false, "Only return certs, or names with --names-only, that resolve (implies --resolve)")
+	cmd.PersistentFlags().IntVar(&opts.resolveConcurrency, "resolve-concurrency", 32, "How many names to resolve at once")
+	cmd.PersistentFlags().BoolVar(&opts.liveness, "liveness", false, "Resolve and probe every name found and output whether it's live, parked, dead or serving a stale cert")
+	cmd.PersistentFlags().BoolVar(&opts.stalenessReport, "staleness-report", false, "Like --liveness, but summarise the statuses and only list the names that look abandoned")
+	cmd.PersistentFlags().DurationVar(&opts.probeTimeout, "probe-timeout", 5*time.Second, "How long to wait when resolving or probing a name, for --resolve and --liveness")
+	cmd.PersistentFlags().IntVar(&opts.probeConcurrency, "probe-concurrency", 16, "How many names to resolve and probe at once")
+	cmd.PersistentFlags().StringVar(&opts.diffAgainst, "diff-against", "", "Output the certificates and names added or removed since this earlier run's output, instead of the results")
+	cmd.PersistentFlags().StringSliceVar(&opts.merge, "merge", nil, "JSON or ndjson output of a previous run to merge into the results (may be repeated)")
+	cmd.PersistentFlags().BoolVar(&opts.classify, "classify", false, "Download each cert and add its extended key usage, type and validation level")
+	cmd.PersistentFlags().StringSliceVar(&opts.ekus, "eku", nil, "Only return certs with one of these extended key usages (serverAuth, clientAuth, codeSigning, emailProtection, timeStamping, OCSPSigning, any)")
+	cmd.PersistentFlags().StringSliceVar(&opts.types, "type", nil, "Only return certs of one of these types (server, client, code-signing, email, timestamping, ocsp-signing)")
+	cmd.PersistentFlags().StringSliceVar(&opts.levels, "validation", nil, "Only return certs with one of these validation levels (dv, ov, iv, ev)")
+	cmd.PersistentFlags().StringSliceVar(&opts.enrich, "enrich", nil, "Enrichments to run on the certs, which run in pipeline order however they're given: "+stageNames())
+	cmd.PersistentFlags().StringToIntVar(&opts.enrichConcurrency, "enrich-concurrency", nil, "How many lookups an enrichment makes at once, e.g. whois=2,probe=32")
+	cmd.PersistentFlags().DurationVar(&opts.enrichCacheTTL, "enrich-cache-ttl", time.Hour, "How long gcrt watch reuses the result of an enrichment lookup, such as a name's DNS records, between polls")
+	cmd.PersistentFlags().StringVar(&opts.whoisServer, "whois-server", "", "Ask this whois server about every domain, rather than the server IANA refers each TLD to")
+	cmd.PersistentFlags().StringVar(&opts.geoipURL, "geoip-url", "https://ipinfo.io/{ip}/json", "Service used by the geoip enrichment, answering like ipinfo.io, with {ip} replaced by the address")
+	cmd.PersistentFlags().BoolVar(&opts.fetchIssuers, "fetch-issuers", false, "Download the CA certificates above each cert and report which root stores it chains to")
+	cmd.PersistentFlags().BoolVar(&opts.logEntries, "log-entries", false, "Read which CT logs each cert is in, and the crt.sh ids of its issuer's certs, from crt.sh")
+	cmd.PersistentFlags().BoolVar(&opts.checkRevocation, "check-revocation", false, "Ask each cert's OCSP responder, or failing that its CRL, whether it has been revoked")
+	cmd.PersistentFlags().DurationVar(&opts.crlTimeout, "crl-timeout", 2*time.Minute, "How long --check-revocation waits for each CRL, which can be tens of MB")
+	cmd.PersistentFlags().BoolVar(&opts.checkCAA, "check-caa", false, "Look up the CAA records of each cert's names and flag certs issued by a CA they don't authorize")
+	cmd.PersistentFlags().StringVar(&opts.caaResolver, "caa-resolver", "", "The DNS server --check-caa asks, as host or host:port (default the first nameserver in /etc/resolv.conf)")
+	cmd.PersistentFlags().StringArrayVar(&opts.caaCAs, "caa-ca", nil, "A CAA issuer domain and a name its certs' issuer names contain, as domain=name, for CAs gcrt doesn't know (may be repeated)")
+	cmd.PersistentFlags().StringArrayVar(&opts.rootStoreFiles, "root-store", nil, "A root program's bundle to check --fetch-issuers chains against, as name=roots.pem (may be repeated, default the system roots)")
+	cmd.PersistentFlags().StringVar(&opts.downloadDir, "download-certs", "", "Download each cert and save it as <id>.pem in this directory")
+	cmd.PersistentFlags().BoolVar(&opts.lint, "lint", false, "Download each cert and report standards-compliance problems found in it")
+	cmd.PersistentFlags().StringVar(&opts.rules, "rules", "", "JSON file of severity rules used to score each cert (implies --score)")
+	cmd.PersistentFlags().BoolVar(&opts.score, "score", false, "Assign a severity to each cert using the built-in rules, or those given by --rules")
+	cmd.PersistentFlags().StringVar(&opts.minSev, "min-severity", "", "Only return certs scored at or above this severity (info, low, medium, high, critical)")
+	cmd.PersistentFlags().DurationVar(&opts.maxDuration, "max-duration", 0, "Stop querying crt.sh after this long and output the partial results, exiting with an error")
+	cmd.PersistentFlags().DurationVar(&opts.timeout, "timeout", 30*time.Second, "How long to wait for each request to crt.sh")
+	cmd.PersistentFlags().IntVar(&opts.retries, "retries", 4, "How many times to retry a failed request to crt.sh")
+	cmd.PersistentFlags().DurationVar(&opts.retryWaitMax, "retry-wait-max", 30*time.Second, "The longest to wait between retries, which back off exponentially from 1s")
+	cmd.PersistentFlags().Float64Var(&opts.rateLimit, "rate-limit", 0, "The most requests to make to crt.sh a second across the whole run, retries included, e.g. 0.5 for one every 2s. 0 for no limit")
+	cmd.PersistentFlags().IntVar(&opts.retryBudget, "retry-budget", -1, "The most retries to make across the whole run, -1 for no limit")
+	cmd.PersistentFlags().StringVar(&opts.proxy, "proxy", "", "Connect to crt.sh through this http, https or socks5 proxy URL (default $HTTPS_PROXY)")
+	cmd.PersistentFlags().BoolVar(&opts.insecure, "insecure", false, "Don't check crt.sh's TLS certificate")
+	cmd.PersistentFlags().StringVar(&opts.caCert, "cacert", "", "Also trust the CA certificates in this PEM file when connecting to crt.sh, e.g. for an intercepting proxy")
+	cmd.PersistentFlags().StringVar(&opts.userAgent, "user-agent", "gcrt", "The User-Agent sent to crt.sh")
+	cmd.PersistentFlags().StringVar(&opts.otelURL, "otel-endpoint", "", "Export OpenTelemetry traces to this OTLP/HTTP collector, e.g. http://localhost:4318")
+	cmd.PersistentFlags().StringVar(&opts.trace, "trace", "", "Write a sanitized transcript of every upstream request and response to this file")
 }
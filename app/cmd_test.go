@@ -0,0 +1,43 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteOutFileOnlyForSearches(t *testing.T) {
+	saved := opts
+	t.Cleanup(func() { opts = saved })
+	db := filepath.Join(t.TempDir(), "results.db")
+	for _, sub := range []string{"watch", "serve", "bulk", "expiry", "summary", "timeline"} {
+		opts = saved
+		cmd.SetArgs([]string{sub, "-d", "%.example.com", "--out-file", db})
+		err := cmd.Execute()
+		if err == nil || !strings.Contains(err.Error(), "only gcrt and gcrt export") {
+			t.Errorf("gcrt %s --out-file %s = %v, want it rejected", sub, filepath.Base(db), err)
+		}
+	}
+	cmd.SetArgs(nil)
+	if _, err := os.Stat(db); !os.IsNotExist(err) {
+		t.Errorf("%s was written, want it left alone", db)
+	}
+}
+
+func TestSQLiteOutFileNotOpened(t *testing.T) {
+	o := opts
+	o.outFile = filepath.Join(t.TempDir(), "results.db")
+	o.sqlite = o.outFile
+	out, err := o.openOutput(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte("[]\n"))
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(o.outFile); !os.IsNotExist(err) {
+		t.Errorf("the output was written to %s, want it left to the export", o.outFile)
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the environment variables that set flags, e.g.
+// GCRT_OUTPUT for --output
+const envPrefix = "GCRT_"
+
+// configFile is the --config file, or empty for the default
+var configFile string
+
+func init() {
+	cmd.PersistentFlags().StringVar(&configFile, "config", "", "YAML file of flag defaults (default $XDG_CONFIG_HOME/gcrt/config.yaml, or $GCRT_CONFIG)")
+}
+
+// defaultConfigFile is where the config is read from when --config and
+// GCRT_CONFIG aren't set
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gcrt", "config.yaml")
+}
+
+// loadConfig reads the config file into settings by flag name. Settings
+// under a key named after a command, like watch:, only apply to that
+// command and override the top level ones
+func loadConfig(c *cobra.Command) (map[string]interface{}, error) {
+	path, explicit := configFile, true
+	if len(path) == 0 {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if len(path) == 0 {
+		path, explicit = defaultConfigFile(), false
+	}
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %s", err)
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %s", path, err)
+	}
+
+	// settings for flags of other commands are ignored, anything else is a
+	// mistake
+	commands := make(map[string]bool)
+	flags := make(map[string]bool)
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		c.Flags().VisitAll(func(f *pflag.Flag) { flags[f.Name] = true })
+		c.PersistentFlags().VisitAll(func(f *pflag.Flag) { flags[f.Name] = true })
+		for _, sub := range c.Commands() {
+			commands[sub.Name()] = true
+			walk(sub)
+		}
+	}
+	walk(c.Root())
+
+	settings := make(map[string]interface{})
+	for key, value := range config {
+		switch {
+		case c.Flags().Lookup(key) != nil:
+			settings[key] = value
+		case !flags[key] && !commands[key]:
+			return nil, fmt.Errorf("in config %s: %q isn't a gcrt flag or command", path, key)
+		}
+	}
+
+	// the sections of the commands the one being run is under, then its own,
+	// so the most specific setting wins
+	var chain []*cobra.Command
+	for p := c; p != nil && p != c.Root(); p = p.Parent() {
+		chain = append([]*cobra.Command{p}, chain...)
+	}
+	for _, p := range chain {
+		section, ok := config[p.Name()].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range section {
+			switch {
+			case c.Flags().Lookup(key) != nil:
+				settings[key] = value
+			case !flags[key]:
+				return nil, fmt.Errorf("in config %s: %q under %s isn't a gcrt flag", path, key, p.Name())
+			}
+		}
+	}
+	return settings, nil
+}
+
+// applyConfig sets every flag not given on the command line from its
+// GCRT_ environment variable, or failing that the config file. Flags set
+// this way still count as unset, so they act as defaults
+func applyConfig(c *cobra.Command) error {
+	settings, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == "config" || f.Name == "help" {
+			return
+		}
+		env := envPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		if v, ok := os.LookupEnv(env); ok {
+			if err := f.Value.Set(v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", env, err))
+			}
+			return
+		}
+		if v, ok := settings[f.Name]; ok {
+			if err := setFlag(f, v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s in config: %s", f.Name, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("invalid settings: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// setFlag sets a flag from a config value: lists set each item in turn and
+// maps are written as key=value pairs
+func setFlag(f *pflag.Flag, v interface{}) error {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if err := f.Value.Set(fmt.Sprint(item)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for k, item := range v {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, item))
+		}
+		sort.Strings(pairs)
+		return f.Value.Set(strings.Join(pairs, ","))
+	case nil:
+		return nil
+	default:
+		return f.Value.Set(fmt.Sprint(v))
+	}
+}
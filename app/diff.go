@@ -0,0 +1,193 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// resultsDiff is what changed between two sets of results
+type resultsDiff struct {
+	AddedCertificates   []record `json:"added_certificates"`
+	RemovedCertificates []record `json:"removed_certificates"`
+	AddedNames          []string `json:"added_names"`
+	RemovedNames        []string `json:"removed_names"`
+}
+
+// diffRecords compares the certs, by crt.sh id or for other sources their
+// serial number, and the hostnames of two sets of results
+func diffRecords(old, new []record, keepWildcards bool) resultsDiff {
+	d := resultsDiff{
+		AddedCertificates:   []record{},
+		RemovedCertificates: []record{},
+		AddedNames:          []string{},
+		RemovedNames:        []string{},
+	}
+
+	oldKeys := make(map[string]bool, len(old))
+	for _, r := range old {
+		oldKeys[certKey(r.CertResponse)] = true
+	}
+	newKeys := make(map[string]bool, len(new))
+	for _, r := range new {
+		key := certKey(r.CertResponse)
+		newKeys[key] = true
+		if !oldKeys[key] {
+			d.AddedCertificates = append(d.AddedCertificates, r)
+			oldKeys[key] = true
+		}
+	}
+	for _, r := range old {
+		key := certKey(r.CertResponse)
+		if !newKeys[key] {
+			d.RemovedCertificates = append(d.RemovedCertificates, r)
+			newKeys[key] = true
+		}
+	}
+
+	oldNames := make(map[string]bool)
+	for _, n := range hostnames(old, keepWildcards) {
+		oldNames[n] = true
+	}
+	newNames := make(map[string]bool)
+	for _, n := range hostnames(new, keepWildcards) {
+		newNames[n] = true
+		if !oldNames[n] {
+			d.AddedNames = append(d.AddedNames, n)
+		}
+	}
+	for n := range oldNames {
+		if !newNames[n] {
+			d.RemovedNames = append(d.RemovedNames, n)
+		}
+	}
+	sort.Strings(d.AddedNames)
+	sort.Strings(d.RemovedNames)
+	return d
+}
+
+// diffColumns are the columns of the tabular and ndjson diff formats
+var diffColumns = []string{"change", "type", "id", "name", "not_before", "not_after", "issuer_name"}
+
+// rows flattens the diff into a row per change: the certs then the names
+func (d resultsDiff) rows() [][]string {
+	var rows [][]string
+	certs := func(change string, records []record) {
+		for _, r := range records {
+			rows = append(rows, []string{change, "certificate", strconv.Itoa(r.ID), strings.Join(r.Names(), " "), r.NotBefore, r.NotAfter, r.IssuerName})
+		}
+	}
+	names := func(change string, names []string) {
+		for _, n := range names {
+			rows = append(rows, []string{change, "name", "", n, "", "", ""})
+		}
+	}
+	certs("added", d.AddedCertificates)
+	certs("removed", d.RemovedCertificates)
+	names("added", d.AddedNames)
+	names("removed", d.RemovedNames)
+	return rows
+}
+
+// writeDiff outputs the diff in the --output format: an object of the
+// changes as JSON, a line per change as ndjson, or a row per change
+func (o options) writeDiff(w io.Writer, d resultsDiff) error {
+	if o.count {
+		fmt.Fprintf(w, "Certificates added: %d, removed: %d\nNames added: %d, removed: %d\n",
+			len(d.AddedCertificates), len(d.RemovedCertificates), len(d.AddedNames), len(d.RemovedNames))
+		return nil
+	}
+
+	switch o.output {
+	case "json":
+		output, err := json.MarshalIndent(d, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, row := range d.rows() {
+			if err := enc.Encode(queryRow{diffColumns, diffValues(row)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv", "tsv":
+		return writeRows(w, delimiter(o.output), diffColumns, d.rows())
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(diffColumns, "\t")))
+		for _, row := range d.rows() {
+			for i := range row {
+				row[i] = truncate(row[i], tableCellWidth)
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("diffs can't be written as %s, use json, ndjson, csv, tsv or table", o.output)
+}
+
+// diffValues are a row's values for JSON, with the id as a number and
+// missing values as null
+func diffValues(row []string) []interface{} {
+	values := make([]interface{}, len(row))
+	for i, v := range row {
+		switch {
+		case len(v) == 0:
+		case diffColumns[i] == "id":
+			values[i], _ = strconv.Atoi(v)
+		default:
+			values[i] = v
+		}
+	}
+	return values
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old.json> <new.json>",
+	Short: "Report the certificates and names added or removed between two runs",
+	Long: `diff compares the output of two gcrt runs, reporting the certificates, by
+crt.sh id, and the hostnames that are only in one of them. --diff-against
+compares a search with an earlier run's output instead`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var sides [2][]record
+		for i, f := range args {
+			certs, err := loadCerts(f)
+			if err != nil {
+				return fmt.Errorf("loading results from %s: %s", f, err)
+			}
+			sides[i] = newRecords(certs)
+		}
+		rd, err := opts.redactor()
+		if err != nil {
+			return err
+		}
+
+		d := diffRecords(sides[0], sides[1], opts.keepWildcards)
+		rd.applyDiff(&d)
+
+		out, err := opts.openOutput(false)
+		if err != nil {
+			return err
+		}
+		if err := opts.writeDiff(out, d); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	},
+}
+
+func init() {
+	cmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,196 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+	"github.com/jhinds/gcrt/tracing"
+)
+
+// targets returns the domains to query. A domain of - or --stdin reads one
+// domain per line from stdin, skipping blank lines and # comments
+func (o options) targets(stdin io.Reader) ([]string, error) {
+	var domains []string
+	readStdin := o.stdin
+
+	for _, d := range o.domains {
+		d = strings.TrimSpace(d)
+		switch {
+		case d == "-":
+			readStdin = true
+		case len(d) > 0:
+			domains = append(domains, d)
+		}
+	}
+
+	if readStdin {
+		fromStdin, err := readDomains(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading domains from stdin: %s", err)
+		}
+		domains = append(domains, fromStdin...)
+	}
+
+	targets := o.identityTargets()
+	if len(domains) == 0 && len(targets) == 0 {
+		return nil, errors.New(`required flag(s) "domain" not set, or search with --org or --identity`)
+	}
+	return append(o.disambiguate(domains), targets...), nil
+}
+
+func readDomains(r io.Reader) ([]string, error) {
+	var domains []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	return domains, scanner.Err()
+}
+
+// searchAll runs q for every domain with at most --concurrency queries in
+// flight, sharding them with --shard or sampling them with --sample.
+// Results are merged in the order the domains were given, keeping the
+// first copy of a cert found by more than one query, and with annotate
+// each record notes the domain that found it. Domains that fail are logged
+// and skipped unless every one of them fails
+func searchAll(ctx context.Context, c *client.Client, q client.Query, domains []string, o options, annotate bool) ([]record, error) {
+	concurrency := o.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if o.backoff != nil {
+		if domains = o.backoff.plan(domains, time.Now().UTC()); len(domains) == 0 {
+			return nil, nil
+		}
+	}
+	// the domains searched at once share --concurrency between their
+	// shards, so there are never more than it in flight
+	shardConcurrency := concurrency
+	if o.shard {
+		inFlight := len(domains)
+		if inFlight > concurrency {
+			inFlight = concurrency
+		}
+		shardConcurrency = concurrency / inFlight
+	}
+
+	results := make([][]client.CertResponse, len(domains))
+	errs := make([]error, len(domains))
+	o.metrics.queued(len(domains))
+	o.progress.expect(len(domains))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, d := range domains {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, d string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			dq := targetQuery(q, d)
+
+			sctx, span := tracing.StartKind(ctx, "crtsh.search", tracing.KindClient)
+			span.SetAttribute("gcrt.domain", d)
+			started := time.Now()
+			switch {
+			case o.sample > 0:
+				results[i], errs[i] = searchSample(sctx, c, dq, o)
+			case o.shard:
+				results[i], errs[i] = c.SearchSharded(sctx, dq, shardConcurrency, shardProgress(d))
+			case o.source != nil:
+				results[i], errs[i] = o.source.Search(sctx, dq)
+			default:
+				results[i], errs[i] = c.Search(sctx, dq)
+			}
+			span.SetError(errs[i])
+			span.SetAttribute("gcrt.results", len(results[i]))
+			span.End()
+			o.metrics.queued(-1)
+			o.metrics.searched(d, len(results[i]), errs[i])
+			o.progress.searched(dq.Domain)
+			log.Debugf("searched %s in %s, found %d certs", d, time.Since(started).Round(time.Millisecond), len(results[i]))
+
+			// failures caused by the run stopping aren't the domain's fault
+			if o.backoff != nil && ctx.Err() == nil {
+				o.backoff.record(d, errs[i], time.Now().UTC())
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	var records []record
+	seen := make(map[string]struct{})
+	failed := 0
+
+	for i, d := range domains {
+		// what was read of a response that was cut off is kept, unless
+		// --strict
+		var partial *client.PartialError
+		if errors.As(errs[i], &partial) {
+			if o.strict {
+				return nil, fmt.Errorf("querying %s: %s", d, errs[i])
+			}
+			log.WithError(errs[i]).Warnf("only part of the results for %s could be read", d)
+			o.outcome.cutOff()
+			errs[i] = nil
+		}
+		o.outcome.searched(errs[i])
+		if errs[i] != nil && ctx.Err() != nil {
+			log.Warnf("the search of %s was stopped", d)
+			failed++
+			continue
+		}
+		if errs[i] != nil {
+			log.WithError(errs[i]).Errorf("error querying %s", d)
+			failed++
+			continue
+		}
+		for _, cert := range results[i] {
+			// certs found by more than one domain are only output once, unless
+			// every entry is wanted
+			key := certKey(cert)
+			if _, ok := seen[key]; ok && q.Dedupe != client.DedupeNone {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			r := record{CertResponse: cert}
+			r.SHA256Fingerprint = cert.SHA256
+			if annotate {
+				r.SourceDomain = d
+			}
+			records = append(records, r)
+		}
+	}
+
+	if failed == len(domains) {
+		return nil, fmt.Errorf("error getting response: %w", errs[0])
+	}
+	return records, nil
+}
+
+// shardProgress logs the progress of a sharded search of domain each time
+// another tenth of its shards complete
+func shardProgress(domain string) client.Progress {
+	reported := 0
+	return func(done, total int) {
+		if tenths := done * 10 / total; tenths > reported {
+			reported = tenths
+			log.Infof("%s: %d of %d shards searched", domain, done, total)
+		}
+	}
+}
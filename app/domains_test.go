@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+func TestShardedSearchConcurrency(t *testing.T) {
+	var inFlight, most int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inFlight, 1)
+		defer atomic.AddInt64(&inFlight, -1)
+		for {
+			m := atomic.LoadInt64(&most)
+			if n <= m || atomic.CompareAndSwapInt64(&most, m, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]client.CertResponse{})
+	}))
+	t.Cleanup(srv.Close)
+
+	o := opts
+	o.client = client.New(client.WithBaseURL(srv.URL), client.WithRetries(0))
+	o.domains = []string{"%.a.example.com", "%.b.example.com", "%.c.example.com", "%.d.example.com"}
+	o.concurrency, o.shard = 4, true
+	if err := run(context.Background(), o, ioutil.Discard); exitStatus(err) != exitNoResults {
+		t.Fatalf("run = %v, want no results", err)
+	}
+	if most > int64(o.concurrency) {
+		t.Errorf("%d requests were in flight at once, want at most --concurrency %d", most, o.concurrency)
+	}
+}
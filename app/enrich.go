@@ -0,0 +1,365 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+	"github.com/jhinds/gcrt/tracing"
+)
+
+// stage is one step of the enrichment pipeline, adding to or filtering the
+// records it's given
+type stage struct {
+	name string
+	// the stages whose results this one uses, which are run before it
+	needs []string
+	run   func(p *pipeline, ctx context.Context, records []record) ([]record, error)
+}
+
+// stages are every enrichment, in the order they run. Stages that only
+// filter on what earlier stages add, like score's --min-severity, come
+// after them, precerts comes first as --precerts-only filters out most
+// records, and download comes last so only the certs that are output are
+// saved
+var stages = []stage{
+	{name: "precerts", run: (*pipeline).precertsStage},
+	{name: "resolve", run: (*pipeline).resolveStage},
+	{name: "classify", run: (*pipeline).classifyStage},
+	{name: "x509", run: (*pipeline).x509Stage},
+	{name: "lint", run: (*pipeline).lintStage},
+	{name: "issuers", run: (*pipeline).issuersStage},
+	{name: "logs", run: (*pipeline).logsStage},
+	{name: "revocation", run: (*pipeline).revocationStage},
+	{name: "caa", run: (*pipeline).caaStage},
+	{name: "probe", run: (*pipeline).probeStage},
+	{name: "whois", run: (*pipeline).whoisStage},
+	{name: "geoip", needs: []string{"resolve"}, run: (*pipeline).geoipStage},
+	{name: "score", run: (*pipeline).scoreStage},
+	{name: "download", run: (*pipeline).downloadStage},
+}
+
+func stageNames() string {
+	names := make([]string, len(stages))
+	for i, s := range stages {
+		names[i] = s.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// pipeline runs the enrichment stages selected for a run. Its cache lives as
+// long as it does, so a watch reuses lookups between polls
+type pipeline struct {
+	o      options
+	c      *client.Client
+	stages []stage
+	cache  *enrichCache
+
+	caa       *caaCheck
+	rules     *RuleSet
+	threshold int
+	roots     []rootStore
+}
+
+// pipeline builds the enrichment pipeline from --enrich and the flags that
+// imply a stage
+func (o options) pipeline(c *client.Client) (*pipeline, error) {
+	selected := make(map[string]bool)
+	for _, name := range o.enrich {
+		selected[strings.TrimSpace(name)] = true
+	}
+	if o.precertsOnly {
+		selected["precerts"] = true
+	}
+	if o.resolve || o.onlyLive {
+		selected["resolve"] = true
+	}
+	if o.classify || len(o.ekus) > 0 || len(o.types) > 0 || len(o.levels) > 0 {
+		selected["classify"] = true
+	}
+	if o.lint {
+		selected["lint"] = true
+	}
+	if o.fetchIssuers {
+		selected["issuers"] = true
+	}
+	if o.logEntries {
+		selected["logs"] = true
+	}
+	if o.checkRevocation {
+		selected["revocation"] = true
+	}
+	if o.checkCAA {
+		selected["caa"] = true
+	}
+	if o.score || len(o.rules) > 0 || len(o.minSev) > 0 {
+		selected["score"] = true
+	}
+	if len(o.downloadDir) > 0 {
+		selected["download"] = true
+	}
+
+	byName := make(map[string]stage, len(stages))
+	for _, s := range stages {
+		byName[s.name] = s
+	}
+	for name := range selected {
+		s, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown enrichment %q, must be one of %s", name, stageNames())
+		}
+		for _, n := range s.needs {
+			selected[n] = true
+		}
+	}
+	for name, n := range o.enrichConcurrency {
+		if _, ok := byName[name]; !ok {
+			return nil, fmt.Errorf("unknown enrichment %q in --enrich-concurrency, must be one of %s", name, stageNames())
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("--enrich-concurrency %s must be at least 1", name)
+		}
+	}
+
+	if selected["download"] && len(o.downloadDir) == 0 {
+		return nil, fmt.Errorf("the download enrichment needs --download-certs to say where to save the certs")
+	}
+	if selected["geoip"] && !strings.Contains(o.geoipURL, "{ip}") {
+		return nil, fmt.Errorf("--geoip-url %s must contain {ip}", o.geoipURL)
+	}
+
+	p := &pipeline{o: o, c: c, cache: newEnrichCache(o.enrichCacheTTL)}
+	for _, s := range stages {
+		if selected[s.name] {
+			p.stages = append(p.stages, s)
+		}
+	}
+
+	if selected["issuers"] {
+		var err error
+		if p.roots, err = o.rootStores(); err != nil {
+			return nil, err
+		}
+	}
+
+	if selected["caa"] {
+		var err error
+		if p.caa, err = o.newCAACheck(); err != nil {
+			return nil, err
+		}
+	}
+
+	if selected["score"] {
+		rs := defaultRules
+		if len(o.rules) > 0 {
+			var err error
+			if rs, err = loadRules(o.rules); err != nil {
+				return nil, fmt.Errorf("loading severity rules: %s", err)
+			}
+		}
+		if err := rs.compile(); err != nil {
+			return nil, fmt.Errorf("in severity rules: %s", err)
+		}
+		p.rules = &rs
+
+		if len(o.minSev) > 0 {
+			threshold, err := parseSeverity(o.minSev)
+			if err != nil {
+				return nil, fmt.Errorf("parsing --min-severity: %s", err)
+			}
+			p.threshold = threshold.Score()
+		}
+	}
+	return p, nil
+}
+
+// run passes the records through each stage in turn
+func (p *pipeline) run(ctx context.Context, records []record) ([]record, error) {
+	for _, s := range p.stages {
+		sctx, span := tracing.Start(ctx, "enrich."+s.name)
+		span.SetAttribute("gcrt.records", len(records))
+
+		started, before := time.Now(), len(records)
+		enriched, err := s.run(p, sctx, records)
+		span.SetError(err)
+		span.End()
+		if err != nil && ctx.Err() != nil {
+			// a run stopped part way keeps what the earlier stages did
+			log.Warnf("enrichment %s was stopped, its results are left out", s.name)
+			return records, nil
+		}
+		log.Debugf("enrichment %s took %s, %d of %d records kept", s.name, time.Since(started).Round(time.Millisecond), len(enriched), before)
+		if err != nil {
+			return nil, err
+		}
+		records = enriched
+	}
+	return records, nil
+}
+
+// has reports whether the stage is part of the pipeline
+func (p *pipeline) has(name string) bool {
+	for _, s := range p.stages {
+		if s.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// concurrency is how many lookups the stage makes at once: its
+// --enrich-concurrency, or the default for that kind of lookup
+func (p *pipeline) concurrency(stage string) int {
+	if n, ok := p.o.enrichConcurrency[stage]; ok {
+		return n
+	}
+	switch stage {
+	case "resolve":
+		return p.o.resolveConcurrency
+	case "probe":
+		return p.o.probeConcurrency
+	case "whois":
+		return 4
+	case "geoip":
+		return 8
+	}
+	return p.o.concurrency
+}
+
+// lookupAll calls lookup for every distinct key, the stage's concurrency at
+// a time, returning the results by key. Results cached by an earlier call are
+// reused rather than looked up again
+func (p *pipeline) lookupAll(ctx context.Context, stage string, keys []string, lookup func(ctx context.Context, key string) interface{}) map[string]interface{} {
+	results := make(map[string]interface{})
+	var todo []string
+	for _, k := range keys {
+		if _, ok := results[k]; ok {
+			continue
+		}
+		if v, ok := p.cache.get(stage, k); ok {
+			results[k] = v
+			continue
+		}
+		results[k] = nil
+		todo = append(todo, k)
+	}
+
+	concurrency := p.concurrency(stage)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				v := lookup(ctx, k)
+				// lookups cut short by the run stopping aren't kept
+				if ctx.Err() == nil {
+					p.cache.put(stage, k, v)
+				}
+				mu.Lock()
+				results[k] = v
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, k := range todo {
+		jobs <- k
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// enrichCache holds the results of lookups by stage and key, for up to ttl
+type enrichCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value interface{}
+	at    time.Time
+}
+
+func newEnrichCache(ttl time.Duration) *enrichCache {
+	return &enrichCache{ttl: ttl, entries: make(map[string]map[string]cacheEntry)}
+}
+
+func (c *enrichCache) get(stage, key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[stage][key]
+	if !ok || time.Since(e.at) > c.ttl {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *enrichCache) put(stage, key string, value interface{}) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[stage] == nil {
+		c.entries[stage] = make(map[string]cacheEntry)
+	}
+	c.entries[stage][key] = cacheEntry{value: value, at: time.Now()}
+}
+
+func (p *pipeline) resolveStage(ctx context.Context, records []record) ([]record, error) {
+	return p.resolveRecords(ctx, records), nil
+}
+
+func (p *pipeline) classifyStage(ctx context.Context, records []record) ([]record, error) {
+	downloadCerts(ctx, p.c, records, p.concurrency("classify"))
+	classifyCerts(records)
+	if len(p.o.ekus) > 0 || len(p.o.types) > 0 || len(p.o.levels) > 0 {
+		records = filterByUsage(records, p.o.ekus, p.o.types, p.o.levels)
+	}
+	return records, nil
+}
+
+func (p *pipeline) x509Stage(ctx context.Context, records []record) ([]record, error) {
+	downloadCerts(ctx, p.c, records, p.concurrency("x509"))
+	describeCerts(records)
+	return records, nil
+}
+
+func (p *pipeline) lintStage(ctx context.Context, records []record) ([]record, error) {
+	downloadCerts(ctx, p.c, records, p.concurrency("lint"))
+	lintCerts(records)
+	return records, nil
+}
+
+func (p *pipeline) scoreStage(ctx context.Context, records []record) ([]record, error) {
+	p.rules.score(records)
+	if len(p.o.minSev) == 0 {
+		return records, nil
+	}
+	scored := records[:0]
+	for _, r := range records {
+		if r.SeverityScore != nil && *r.SeverityScore >= p.threshold {
+			scored = append(scored, r)
+		}
+	}
+	return scored, nil
+}
+
+func (p *pipeline) downloadStage(ctx context.Context, records []record) ([]record, error) {
+	downloadCerts(ctx, p.c, records, p.concurrency("download"))
+	if err := writePEMFiles(p.o.downloadDir, records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// the exit statuses of a search, see the output contract in the README
+const (
+	exitResults    = 0
+	exitNoResults  = 1
+	exitQueryError = 2
+	exitPartial    = 3
+	// exitExpiring is the status of gcrt expiry when a hostname is within
+	// --fail-within of expiring
+	exitExpiring = 4
+)
+
+// exitCoder is an error that exits gcrt with a status of its own, rather
+// than exitQueryError
+type exitCoder interface {
+	exitCode() int
+}
+
+// exitStatus is the status gcrt exits with after err
+func exitStatus(err error) int {
+	if err == nil {
+		return exitResults
+	}
+	var e exitCoder
+	if errors.As(err, &e) {
+		return e.exitCode()
+	}
+	return exitQueryError
+}
+
+// errNoResults is returned by a search that worked but found nothing, once
+// its empty output has been written. It isn't logged
+var errNoResults error = noResultsError{}
+
+type noResultsError struct{}
+
+func (noResultsError) Error() string { return "no certificates found" }
+func (noResultsError) exitCode() int { return exitNoResults }
+
+// partialError is returned once the results are written when some of the
+// domains searched failed, or some responses were cut off, and were logged
+type partialError struct {
+	failed, domains, cutOff int64
+}
+
+func (e partialError) Error() string {
+	var problems []string
+	if e.failed > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d domains failed", e.failed, e.domains))
+	}
+	if e.cutOff > 0 {
+		problems = append(problems, fmt.Sprintf("%d response(s) were cut off", e.cutOff))
+	}
+	return strings.Join(problems, " and ") + ", the results are partial"
+}
+
+func (partialError) exitCode() int { return exitPartial }
+
+func (maxDurationError) exitCode() int { return exitPartial }
+
+// errInterrupted is returned when a run is stopped by SIGINT or SIGTERM,
+// after its partial results have been output
+var errInterrupted error = interruptedError{}
+
+type interruptedError struct{}
+
+func (interruptedError) Error() string { return "interrupted, the results are partial" }
+func (interruptedError) exitCode() int { return exitPartial }
+
+func (expiryFailure) exitCode() int { return exitExpiring }
+
+// runOutcome tallies a run's searches and what they found, for its exit
+// status. A nil runOutcome tallies nothing
+type runOutcome struct {
+	domains, failed, truncated, found int64
+}
+
+// searched counts a domain's search
+func (r *runOutcome) searched(err error) {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.domains, 1)
+	if err != nil {
+		atomic.AddInt64(&r.failed, 1)
+	}
+}
+
+// cutOff counts a response that was only partly read
+func (r *runOutcome) cutOff() {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.truncated, 1)
+}
+
+// add counts certs that were output
+func (r *runOutcome) add(n int) {
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&r.found, int64(n))
+}
+
+// err is what the run returns once its output is written: a partialError
+// if any domain failed or was cut off, or errNoResults if nothing was found
+func (r *runOutcome) err() error {
+	if r == nil {
+		return nil
+	}
+	failed, cutOff := atomic.LoadInt64(&r.failed), atomic.LoadInt64(&r.truncated)
+	if failed > 0 || cutOff > 0 {
+		return partialError{failed: failed, domains: atomic.LoadInt64(&r.domains), cutOff: cutOff}
+	}
+	if atomic.LoadInt64(&r.found) == 0 {
+		return errNoResults
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitResults},
+		{errNoResults, exitNoResults},
+		{errors.New("boom"), exitQueryError},
+		{partialError{failed: 1, domains: 2}, exitPartial},
+		{maxDurationError{}, exitPartial},
+		{errInterrupted, exitPartial},
+		{expiryFailure{names: []string{"www.example.com"}, within: "7d"}, exitExpiring},
+		{fmt.Errorf("wrapped: %w", errNoResults), exitNoResults},
+	}
+	seen := make(map[int]error)
+	for _, tt := range tests {
+		if got := exitStatus(tt.err); got != tt.want {
+			t.Errorf("exitStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+	// every outcome a script might check for has its own status
+	for _, err := range []error{nil, errNoResults, errors.New("boom"), errInterrupted, expiryFailure{}} {
+		s := exitStatus(err)
+		if prev, ok := seen[s]; ok {
+			t.Errorf("%v and %v both exit with status %d", prev, err, s)
+		}
+		seen[s] = err
+	}
+}
@@ -0,0 +1,224 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// the expiry statuses of a hostname
+const (
+	expiryOK       = "ok"
+	expiryExpiring = "expiring"
+)
+
+var expiryOpts struct {
+	warnWithin string
+	failWithin string
+}
+
+var expiryCmd = &cobra.Command{
+	Use:   "expiry",
+	Short: "Report when the currently valid certificates of each hostname expire",
+	Long: `expiry runs a search like gcrt does and groups the currently valid
+certificates found by hostname. Each hostname is reported with the cert that
+expires last, as that's when it stops being covered, and the days left until
+then. Hostnames with less than --warn-within left are flagged as expiring,
+and with --fail-within gcrt exits with status 4 when any hostname has less
+than that left, so it can be run from cron as an expiry monitor`,
+	Example: `  gcrt expiry -d example.com -o table
+  gcrt expiry -d %.example.com --warn-within 30d --fail-within 7d`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a, err := newExpiryAudit(expiryOpts.warnWithin, expiryOpts.failWithin, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		o := opts
+		o.expiry = a
+
+		out, err := o.openOutput(false)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		runErr := runTraced(ctx, o, out)
+		if err := out.Close(); err != nil && runErr == nil {
+			runErr = err
+		}
+		return runErr
+	},
+}
+
+func init() {
+	expiryCmd.Flags().StringVar(&expiryOpts.warnWithin, "warn-within", "30d", "Flag hostnames whose certs all expire within this time, e.g. 30d or 2w")
+	expiryCmd.Flags().StringVar(&expiryOpts.failWithin, "fail-within", "", "Exit with status 4 if any hostname's certs all expire within this time, e.g. 7d")
+	cmd.AddCommand(expiryCmd)
+}
+
+// expiryAudit is the configuration of gcrt expiry
+type expiryAudit struct {
+	now        time.Time
+	warnWithin time.Duration
+	failWithin time.Duration
+}
+
+func newExpiryAudit(warnWithin, failWithin string, now time.Time) (*expiryAudit, error) {
+	a := &expiryAudit{now: now}
+	var err error
+	if a.warnWithin, err = parseDuration(warnWithin); err != nil || a.warnWithin < 0 {
+		return nil, fmt.Errorf("invalid --warn-within %q, e.g. 30d or 2w", warnWithin)
+	}
+	if len(failWithin) > 0 {
+		if a.failWithin, err = parseDuration(failWithin); err != nil || a.failWithin <= 0 {
+			return nil, fmt.Errorf("invalid --fail-within %q, e.g. 7d or 2w", failWithin)
+		}
+	}
+	return a, nil
+}
+
+// HostExpiry is when the last of a hostname's currently valid certs expires
+type HostExpiry struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	NotAfter   string `json:"not_after"`
+	DaysLeft   int    `json:"days_left"`
+	ID         int    `json:"id"`
+	CrtShLink  string `json:"crt_sh_link"`
+	IssuerName string `json:"issuer_name"`
+	CertCount  int    `json:"cert_count"`
+
+	notAfter time.Time
+}
+
+// expiryReport is the gcrt expiry output
+type expiryReport struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	WarnWithin  string       `json:"warn_within"`
+	Hostnames   int          `json:"hostnames"`
+	Expiring    int          `json:"expiring"`
+	Hosts       []HostExpiry `json:"hosts"`
+}
+
+// hosts groups the currently valid certs by hostname, soonest to expire
+// first. Wildcard names are kept as they are, a *. cert doesn't cover the
+// domain itself
+func (a *expiryAudit) hosts(records []record) []HostExpiry {
+	active := validityFilter{activeOnly: true}.apply(records, a.now)
+	byName := make(map[string]*HostExpiry)
+	for _, r := range active {
+		notAfter, _ := r.NotAfterTime()
+		for _, n := range r.Names() {
+			if strings.ContainsAny(n, "@ ") {
+				continue
+			}
+			h, ok := byName[n]
+			if !ok {
+				h = &HostExpiry{Name: n}
+				byName[n] = h
+			}
+			h.CertCount++
+			if notAfter.After(h.notAfter) {
+				h.notAfter = notAfter
+				h.NotAfter = r.NotAfter
+				h.ID = r.ID
+				h.CrtShLink = r.Link()
+				h.IssuerName = r.IssuerName
+			}
+		}
+	}
+
+	hosts := make([]HostExpiry, 0, len(byName))
+	for _, h := range byName {
+		h.DaysLeft = int(h.notAfter.Sub(a.now) / (24 * time.Hour))
+		h.Status = expiryOK
+		if h.notAfter.Before(a.now.Add(a.warnWithin)) {
+			h.Status = expiryExpiring
+		}
+		hosts = append(hosts, *h)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if !hosts[i].notAfter.Equal(hosts[j].notAfter) {
+			return hosts[i].notAfter.Before(hosts[j].notAfter)
+		}
+		return hosts[i].Name < hosts[j].Name
+	})
+	return hosts
+}
+
+// expiryFailure is returned once the report is written when a hostname is
+// within --fail-within of expiring
+type expiryFailure struct {
+	names  []string
+	within string
+}
+
+func (e expiryFailure) Error() string {
+	return fmt.Sprintf("%d hostname(s) expire within %s: %s", len(e.names), e.within, strings.Join(e.names, ", "))
+}
+
+// writeExpiry writes the expiry report and then fails if any hostname is
+// within --fail-within of expiring
+func (o options) writeExpiry(w io.Writer, records []record) error {
+	a := o.expiry
+	hosts := a.hosts(records)
+	report := expiryReport{
+		GeneratedAt: a.now,
+		WarnWithin:  expiryOpts.warnWithin,
+		Hostnames:   len(hosts),
+		Hosts:       hosts,
+	}
+	for _, h := range hosts {
+		if h.Status == expiryExpiring {
+			report.Expiring++
+		}
+	}
+
+	if o.count {
+		fmt.Fprintf(w, "Number of expiring names found: %d\n", report.Expiring)
+	} else if err := writeExpiryReport(w, o.output, report); err != nil {
+		return err
+	}
+
+	if a.failWithin > 0 {
+		var failing []string
+		for _, h := range hosts {
+			if h.notAfter.Before(a.now.Add(a.failWithin)) {
+				failing = append(failing, h.Name)
+			}
+		}
+		if len(failing) > 0 {
+			return expiryFailure{names: failing, within: expiryOpts.failWithin}
+		}
+	}
+	return nil
+}
+
+// writeExpiryReport writes the report as JSON, one row per hostname for the
+// tabular formats
+func writeExpiryReport(w io.Writer, format string, report expiryReport) error {
+	header := []string{"name", "status", "not_after", "days_left", "id", "issuer_name", "cert_count"}
+	rows := make([][]string, len(report.Hosts))
+	for i, h := range report.Hosts {
+		rows[i] = []string{h.Name, h.Status, h.NotAfter, strconv.Itoa(h.DaysLeft), strconv.Itoa(h.ID), h.IssuerName, strconv.Itoa(h.CertCount)}
+	}
+
+	switch format {
+	case "csv", "tsv", "table", "markdown":
+		return writeSummaryRows(w, format, header, rows)
+	default:
+		output, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	}
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/jhinds/gcrt/client"
+)
+
+func init() {
+	// the tests check what's output, not what's logged
+	log.SetHandler(discard.Default)
+}
+
+// fakeCrtsh serves certs for every search, as crt.sh's JSON output does
+func fakeCrtsh(t *testing.T, certs []client.CertResponse) (*client.Client, *httptest.Server) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(certs)
+	}))
+	t.Cleanup(srv.Close)
+	return client.New(client.WithBaseURL(srv.URL), client.WithRetries(0)), srv
+}
+
+// fakeCrtshPage serves body as every response, like crt.sh's error pages
+func fakeCrtshPage(t *testing.T, contentType, body string) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return client.New(client.WithBaseURL(srv.URL), client.WithRetries(0))
+}
+
+// testCert is a crt.sh result for name
+func testCert(id int, name string) client.CertResponse {
+	return client.CertResponse{
+		ID:             id,
+		IssuerName:     "C=US, O=Let's Encrypt, CN=R3",
+		CommonName:     name,
+		NameValue:      name,
+		EntryTimestamp: "2024-01-01T00:00:00",
+		NotBefore:      "2024-01-01T00:00:00",
+		NotAfter:       "2099-01-01T00:00:00",
+		SerialNumber:   "01",
+	}
+}
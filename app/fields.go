@@ -0,0 +1,181 @@
+package app
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// certColumns are the crt.sh fields of a record, in output order
+var certColumns = []string{
+	"crt_sh_link", "issuer_ca_id", "issuer_name", "common_name", "name_value", "id",
+	"entry_timestamp", "not_before", "not_after", "serial_number",
+}
+
+// extraColumns are the scalar enrichment fields, output by the tabular
+// formats when any record has them
+var extraColumns = []string{
+	"source_domain", "entry_type", "validation_level", "key_algorithm", "key_size", "signature_algorithm",
+	"sha256_fingerprint", "addresses", "trust", "issuer_error", "log_entries_error",
+	"revocation_status", "revoked_at", "revocation_reason", "revocation_source", "revocation_error",
+	"caa_status", "caa_error", "severity", "severity_score", "pem_file",
+	"source", "ct_log", "ct_log_index", "sha1_fingerprint",
+}
+
+// listFields are the list fields of a record, which --fields can select.
+// The tabular formats separate their values with spaces
+var listFields = []string{"sans", "ext_key_usage", "cert_types", "trusted_by", "untrusted_by", "log_operators", "issuer_cert_ids", "caa_unauthorized_names", "findings"}
+
+// jsonOnlyFields are the nested fields of a record, which only the JSON
+// formats can output
+var jsonOnlyFields = map[string]bool{"dns": true, "lint": true, "http": true, "whois": true, "geoip": true, "issuer_chain": true, "log_entries": true}
+
+// knownFields are the fields fieldValue knows how to look up
+var knownFields = map[string]bool{"validity_days": true, "public_key": true, "feed_title": true}
+
+func init() {
+	for _, f := range append(append(certColumns, extraColumns...), listFields...) {
+		knownFields[f] = true
+	}
+}
+
+// knownFieldNames lists the fields --fields can select
+func knownFieldNames() string {
+	names := make([]string, 0, len(knownFields)+len(jsonOnlyFields))
+	for f := range knownFields {
+		names = append(names, f)
+	}
+	for f := range jsonOnlyFields {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// fieldValue returns the named field of a cert as a string
+func fieldValue(c record, field string) (string, bool) {
+	switch field {
+	case "crt_sh_link":
+		return c.Link(), true
+	case "issuer_ca_id":
+		return strconv.FormatInt(c.IssuerCAID, 10), true
+	case "issuer_name":
+		return c.IssuerName, true
+	case "common_name":
+		return c.CommonName, true
+	case "name_value":
+		return c.NameValue, true
+	case "id":
+		return strconv.Itoa(c.ID), true
+	case "entry_timestamp":
+		return c.EntryTimestamp, true
+	case "not_before":
+		return c.NotBefore, true
+	case "not_after":
+		return c.NotAfter, true
+	case "serial_number":
+		return c.SerialNumber, true
+	case "source_domain":
+		return c.SourceDomain, true
+	case "validation_level":
+		return c.ValidationLevel, true
+	case "key_algorithm":
+		return c.KeyAlgorithm, true
+	case "key_size":
+		if c.KeySize == 0 {
+			return "", true
+		}
+		return strconv.Itoa(c.KeySize), true
+	case "public_key":
+		if c.KeySize == 0 {
+			return "", true
+		}
+		return c.KeyAlgorithm + " " + strconv.Itoa(c.KeySize), true
+	case "signature_algorithm":
+		return c.SignatureAlgorithm, true
+	case "sha256_fingerprint":
+		return c.SHA256Fingerprint, true
+	case "addresses":
+		return strings.Join(recordAddresses(c), " "), true
+	case "severity":
+		return c.Severity, true
+	case "severity_score":
+		if c.SeverityScore == nil {
+			return "", true
+		}
+		return strconv.Itoa(*c.SeverityScore), true
+	case "pem_file":
+		return c.PEMFile, true
+	case "entry_type":
+		return c.EntryType, true
+	case "feed_title":
+		return c.FeedTitle, true
+	case "source":
+		return c.Source, true
+	case "ct_log":
+		return c.CTLog, true
+	case "ct_log_index":
+		if c.CTLogIndex == 0 && len(c.CTLog) == 0 {
+			return "", true
+		}
+		return strconv.FormatInt(c.CTLogIndex, 10), true
+	case "sha1_fingerprint":
+		return c.SHA1Fingerprint, true
+	case "sans":
+		return strings.Join(c.SANs, " "), true
+	case "ext_key_usage":
+		return strings.Join(c.ExtKeyUsage, " "), true
+	case "cert_types":
+		return strings.Join(c.CertTypes, " "), true
+	case "trust":
+		return c.Trust, true
+	case "issuer_error":
+		return c.IssuerError, true
+	case "log_entries_error":
+		return c.LogEntriesError, true
+	case "log_operators":
+		return strings.Join(c.LogOperators, " "), true
+	case "issuer_cert_ids":
+		ids := make([]string, len(c.IssuerCertIDs))
+		for i, id := range c.IssuerCertIDs {
+			ids[i] = strconv.Itoa(id)
+		}
+		return strings.Join(ids, " "), true
+	case "revocation_status":
+		return c.RevocationStatus, true
+	case "revoked_at":
+		return c.RevokedAt, true
+	case "revocation_reason":
+		return c.RevocationReason, true
+	case "revocation_source":
+		return c.RevocationSource, true
+	case "revocation_error":
+		return c.RevocationError, true
+	case "caa_status":
+		return c.CAAStatus, true
+	case "caa_error":
+		return c.CAAError, true
+	case "caa_unauthorized_names":
+		return strings.Join(c.CAAUnauthorizedNames, " "), true
+	case "trusted_by":
+		return strings.Join(c.TrustedBy, " "), true
+	case "untrusted_by":
+		return strings.Join(c.UntrustedBy, " "), true
+	case "findings":
+		return strings.Join(c.Findings, " "), true
+	case "validity_days":
+		if c.ValidityDays > 0 {
+			return strconv.Itoa(c.ValidityDays), true
+		}
+		notBefore, err := c.NotBeforeTime()
+		if err != nil {
+			return "", false
+		}
+		notAfter, err := c.NotAfterTime()
+		if err != nil {
+			return "", false
+		}
+		return strconv.Itoa(int(notAfter.Sub(notBefore).Hours() / 24)), true
+	}
+	return "", false
+}
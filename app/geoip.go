@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// GeoIPResult is where an address a name on a cert resolved to is located,
+// and who operates it
+type GeoIPResult struct {
+	IP      string `json:"ip"`
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+	Org     string `json:"org,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// geoipStage locates every address the records' names resolved to, each
+// distinct address once
+func (p *pipeline) geoipStage(ctx context.Context, records []record) ([]record, error) {
+	hc := &http.Client{Timeout: p.o.probeTimeout}
+
+	var addrs []string
+	for _, r := range records {
+		addrs = append(addrs, recordAddresses(r)...)
+	}
+	results := p.lookupAll(ctx, "geoip", addrs, func(ctx context.Context, ip string) interface{} {
+		res, err := geoip(ctx, hc, p.o.geoipURL, ip)
+		if err != nil {
+			res.Error = err.Error()
+		}
+		return res
+	})
+
+	for i := range records {
+		r := &records[i]
+		r.GeoIP = nil
+		for _, a := range recordAddresses(*r) {
+			r.GeoIP = append(r.GeoIP, results[a].(GeoIPResult))
+		}
+	}
+	return records, nil
+}
+
+// geoip looks up ip with a service answering in the format of ipinfo.io
+func geoip(ctx context.Context, hc *http.Client, template, ip string) (GeoIPResult, error) {
+	res := GeoIPResult{IP: ip}
+
+	req, err := http.NewRequest(http.MethodGet, strings.Replace(template, "{ip}", url.PathEscape(ip), -1), nil)
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "gcrt")
+
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&res); err != nil {
+		return res, fmt.Errorf("parsing response: %s", err)
+	}
+	res.IP = ip
+	return res, nil
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// hint is a suggestion for a domain that probably won't find what was meant.
+// expand is what --auto-expand searches instead, nil when the domain is left
+// as it is
+type hint struct {
+	message string
+	expand  []string
+}
+
+// hintFor checks a domain against the common ways a crt.sh search misses
+// results
+func hintFor(d string) *hint {
+	switch {
+	case strings.HasPrefix(d, orgPrefix):
+		return nil
+	case strings.Contains(d, "://"):
+		u, err := url.Parse(d)
+		if err != nil || len(u.Hostname()) == 0 {
+			return &hint{message: "looks like a URL, crt.sh only matches the host name"}
+		}
+		host := u.Hostname()
+		expand := []string{host}
+		if h := hintFor(host); h != nil && h.expand != nil {
+			expand = h.expand
+		}
+		return &hint{message: "looks like a URL, crt.sh only matches host names like " + host, expand: expand}
+	case strings.HasPrefix(d, "*."):
+		wildcard := "%" + d[1:]
+		return &hint{
+			message: "only matches certs with that literal wildcard name, use " + wildcard + " to find the names under it",
+			expand:  []string{wildcard},
+		}
+	case strings.ContainsAny(d, " \t") || !strings.Contains(d, "."):
+		return &hint{message: "doesn't look like a domain name, crt.sh matches it against cert identities so an organisation name won't find its certs, search for the organisation's domains instead"}
+	case !strings.Contains(d, "%") && registrableDomain(d) == strings.ToLower(d):
+		return &hint{
+			message: "only matches certs for " + d + " itself, use %." + d + " to include its subdomains",
+			expand:  []string{d, "%." + d},
+		}
+	}
+	return nil
+}
+
+// disambiguate warns about the domains that likely miss results, and with
+// --auto-expand replaces them with the searches that were probably meant
+func (o options) disambiguate(domains []string) []string {
+	var expanded []string
+	seen := make(map[string]bool)
+	add := func(d string) {
+		if !seen[d] {
+			seen[d] = true
+			expanded = append(expanded, d)
+		}
+	}
+
+	for _, d := range domains {
+		h := hintFor(d)
+		switch {
+		case h == nil:
+			add(d)
+		case o.autoExpand && h.expand != nil:
+			log.Infof("%s %s, searching %s", d, h.message, strings.Join(h.expand, " and "))
+			for _, e := range h.expand {
+				add(e)
+			}
+		case h.expand != nil:
+			log.Warnf("%s %s (or pass --auto-expand)", d, h.message)
+			add(d)
+		default:
+			log.Warnf("%s %s", d, h.message)
+			add(d)
+		}
+	}
+	return expanded
+}
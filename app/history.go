@@ -0,0 +1,188 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// the kinds of history event
+const (
+	eventCert   = "cert"
+	eventStatus = "status"
+)
+
+// the DNS statuses of a name recorded in the history
+const (
+	dnsResolves = "resolves"
+	dnsNotFound = "not-found"
+)
+
+// historyEvent is a line of the history file: a cert watch found, or a
+// change in whether one of their names resolves
+type historyEvent struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+
+	// for certs
+	Domain string  `json:"domain,omitempty"`
+	Cert   *record `json:"cert,omitempty"`
+
+	// for statuses
+	Name      string   `json:"name,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// history is the record watch --history keeps of what it's found, which is
+// only ever appended to
+type history struct {
+	path   string
+	events []historyEvent
+
+	// the latest status of each name
+	status map[string]string
+}
+
+// loadHistory reads the history file, which doesn't have to exist yet. A
+// last line cut short by a crash is skipped
+func loadHistory(path string) (*history, error) {
+	h := &history{path: path, status: make(map[string]string)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	line := 0
+	var bad error
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if bad != nil {
+			return nil, bad
+		}
+		var e historyEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			bad = fmt.Errorf("parsing history file %s line %d: %s", path, line, err)
+			continue
+		}
+		h.add(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history file %s: %s", path, err)
+	}
+	if bad != nil {
+		log.Warnf("ignoring the incomplete last line of history file %s", path)
+	}
+	return h, nil
+}
+
+func (h *history) add(e historyEvent) {
+	h.events = append(h.events, e)
+	if e.Kind == eventStatus {
+		h.status[e.Name] = e.Status
+	}
+}
+
+// append writes events to the end of the history file
+func (h *history) append(events []historyEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+		h.add(e)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// addCerts records the certs found by a poll
+func (h *history) addCerts(records []record) error {
+	now := time.Now().UTC()
+	events := make([]historyEvent, len(records))
+	for i := range records {
+		r := records[i]
+		events[i] = historyEvent{Time: now, Kind: eventCert, Domain: r.SourceDomain, Cert: &r}
+	}
+	return h.append(events)
+}
+
+// names are the resolvable names of every cert in the history
+func (h *history) names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, e := range h.events {
+		if e.Kind != eventCert || e.Cert == nil {
+			continue
+		}
+		for _, n := range resolvableNames(*e.Cert) {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updateStatuses resolves every name in the history and records the ones
+// whose status has changed. Lookups that fail for reasons other than the
+// name not existing, like timeouts, say nothing about the name so aren't
+// recorded
+func (h *history) updateStatuses(ctx context.Context, p *pipeline) error {
+	names := h.names()
+	results := p.lookupAll(ctx, "resolve", names, func(ctx context.Context, name string) interface{} {
+		return resolve(ctx, name, p.o.probeTimeout)
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	var events []historyEvent
+	for _, n := range names {
+		res := results[n].(DNSResult)
+		status := dnsResolves
+		switch {
+		case res.live():
+		case res.Error == "not found":
+			status = dnsNotFound
+		default:
+			continue
+		}
+		if h.status[n] == status {
+			continue
+		}
+		events = append(events, historyEvent{Time: now, Kind: eventStatus, Name: n, Status: status, Addresses: append(append([]string(nil), res.A...), res.AAAA...)})
+	}
+	return h.append(events)
+}
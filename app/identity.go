@@ -0,0 +1,47 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+// orgPrefix marks a target searched by organisation name rather than
+// identity, written the way crt.sh takes it e.g. O=Acme Corp
+const orgPrefix = "O="
+
+// targetQuery is q for a target: a domain or identity, an --org, or a
+// serial or fingerprint from gcrt lookup
+func targetQuery(q client.Query, target string) client.Query {
+	if strings.HasPrefix(target, orgPrefix) {
+		q.Field = "O"
+		q.Domain = strings.TrimPrefix(target, orgPrefix)
+		return q
+	}
+	for _, prefix := range []string{serialPrefix, sha256Prefix} {
+		if strings.HasPrefix(target, prefix) {
+			q.Field = strings.TrimSuffix(prefix, "=")
+			q.Domain = strings.TrimPrefix(target, prefix)
+			return q
+		}
+	}
+	q.Domain = target
+	return q
+}
+
+// identityTargets are the --identity and --org searches and the lookups,
+// which aren't checked for being domains
+func (o options) identityTargets() []string {
+	targets := append([]string(nil), o.lookups...)
+	for _, id := range o.identities {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			targets = append(targets, id)
+		}
+	}
+	for _, org := range o.orgs {
+		if org = strings.TrimSpace(org); len(org) > 0 {
+			targets = append(targets, orgPrefix+org)
+		}
+	}
+	return targets
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// issuerMatcher matches an issuer name against one --issuer or
+// --exclude-issuer value. Values written as /regex/ are regular
+// expressions, anything else is a case-insensitive substring
+type issuerMatcher func(issuer string) bool
+
+func newIssuerMatchers(patterns []string) ([]issuerMatcher, error) {
+	matchers := make([]issuerMatcher, len(patterns))
+	for i, p := range patterns {
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid issuer pattern %s: %s", p, err)
+			}
+			matchers[i] = re.MatchString
+			continue
+		}
+
+		sub := strings.ToLower(p)
+		matchers[i] = func(issuer string) bool {
+			return strings.Contains(strings.ToLower(issuer), sub)
+		}
+	}
+	return matchers, nil
+}
+
+func matchesAnyIssuer(issuer string, matchers []issuerMatcher) bool {
+	for _, m := range matchers {
+		if m(issuer) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIssuers keeps records issued by a CA matching one of include (when
+// given) and none of exclude, and whose CA id is one of caIDs (when given)
+func filterIssuers(records []record, include, exclude []issuerMatcher, caIDs []int) []record {
+	ids := make(map[int64]bool, len(caIDs))
+	for _, id := range caIDs {
+		ids[int64(id)] = true
+	}
+
+	var kept []record
+	for _, r := range records {
+		if len(include) > 0 && !matchesAnyIssuer(r.IssuerName, include) {
+			continue
+		}
+		if matchesAnyIssuer(r.IssuerName, exclude) {
+			continue
+		}
+		if len(ids) > 0 && !ids[r.IssuerCAID] {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// maxIssuerDepth is how many issuers are followed up from a cert
+const maxIssuerDepth = 4
+
+// the trust of a cert across the root stores
+const (
+	trustAll     = "trusted"
+	trustPartial = "partial"
+	trustNone    = "untrusted"
+)
+
+// IssuerCert is a CA certificate in the chain above a cert, downloaded from
+// the URL in its issuer's Authority Information Access
+type IssuerCert struct {
+	Subject           string `json:"subject"`
+	SHA256Fingerprint string `json:"sha256_fingerprint"`
+	NotAfter          string `json:"not_after"`
+	URL               string `json:"url"`
+}
+
+// rootStore is a set of trusted roots, such as a root program's bundle
+type rootStore struct {
+	name string
+	pool *x509.CertPool
+}
+
+// rootStores loads the --root-store bundles, or the system roots when there
+// are none
+func (o options) rootStores() ([]rootStore, error) {
+	if len(o.rootStoreFiles) == 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("loading the system roots, pass --root-store instead: %s", err)
+		}
+		return []rootStore{{name: "system", pool: pool}}, nil
+	}
+
+	var stores []rootStore
+	for _, s := range o.rootStoreFiles {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid --root-store %q, must be name=file.pem", s)
+		}
+		data, err := ioutil.ReadFile(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("loading root store %s: %s", parts[0], err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("loading root store %s: no PEM certificates in %s", parts[0], parts[1])
+		}
+		stores = append(stores, rootStore{name: parts[0], pool: pool})
+	}
+	return stores, nil
+}
+
+// issuerFetch is the result of downloading an issuer
+type issuerFetch struct {
+	cert *x509.Certificate
+	err  string
+}
+
+// issuersStage downloads the chain above each cert and checks which root
+// stores it chains to. Each issuer URL is only downloaded once
+func (p *pipeline) issuersStage(ctx context.Context, records []record) ([]record, error) {
+	downloadCerts(ctx, p.c, records, p.concurrency("issuers"))
+	hc := &http.Client{Timeout: p.o.probeTimeout}
+
+	// fetch a level of the chains at a time, so issuers shared by many certs
+	// are only downloaded once
+	fetched := make(map[string]issuerFetch)
+	var urls []string
+	for _, r := range records {
+		if r.cert != nil {
+			urls = append(urls, r.cert.IssuingCertificateURL...)
+		}
+	}
+	for depth := 0; depth < maxIssuerDepth && len(urls) > 0; depth++ {
+		results := p.lookupAll(ctx, "issuers", urls, func(ctx context.Context, url string) interface{} {
+			cert, err := fetchIssuer(ctx, hc, url)
+			if err != nil {
+				return issuerFetch{err: err.Error()}
+			}
+			return issuerFetch{cert: cert}
+		})
+
+		urls = nil
+		for url, v := range results {
+			f := v.(issuerFetch)
+			fetched[url] = f
+			if f.cert == nil || selfSigned(f.cert) {
+				continue
+			}
+			for _, next := range f.cert.IssuingCertificateURL {
+				if _, ok := fetched[next]; !ok {
+					urls = append(urls, next)
+				}
+			}
+		}
+	}
+
+	partial := 0
+	for i := range records {
+		r := &records[i]
+		if r.cert == nil {
+			continue
+		}
+		var chain []*x509.Certificate
+		r.IssuerChain, chain, r.IssuerError = buildChain(r.cert, fetched)
+		r.TrustedBy, r.UntrustedBy = p.checkTrust(r.cert, chain)
+
+		switch {
+		case len(r.UntrustedBy) == 0:
+			r.Trust = trustAll
+		case len(r.TrustedBy) == 0:
+			r.Trust = trustNone
+		default:
+			r.Trust = trustPartial
+			partial++
+		}
+	}
+	if partial > 0 {
+		log.Warnf("%d cert(s) chain to roots only trusted by some of the root stores", partial)
+	}
+	return records, nil
+}
+
+// buildChain follows the issuers up from cert through the fetched certs
+func buildChain(cert *x509.Certificate, fetched map[string]issuerFetch) ([]IssuerCert, []*x509.Certificate, string) {
+	var issuers []IssuerCert
+	var chain []*x509.Certificate
+
+	cur := cert
+	for depth := 0; depth < maxIssuerDepth && len(cur.IssuingCertificateURL) > 0 && !selfSigned(cur); depth++ {
+		url := cur.IssuingCertificateURL[0]
+		f, ok := fetched[url]
+		if !ok {
+			break
+		}
+		if f.cert == nil {
+			return issuers, chain, fmt.Sprintf("downloading %s: %s", url, f.err)
+		}
+		sum := sha256.Sum256(f.cert.Raw)
+		issuers = append(issuers, IssuerCert{
+			Subject:           f.cert.Subject.String(),
+			SHA256Fingerprint: hex.EncodeToString(sum[:]),
+			NotAfter:          f.cert.NotAfter.UTC().Format("2006-01-02T15:04:05"),
+			URL:               url,
+		})
+		chain = append(chain, f.cert)
+		cur = f.cert
+	}
+	return issuers, chain, ""
+}
+
+// checkTrust returns the root stores that cert chains to and those it
+// doesn't. Certs are checked as of now, or when they were issued if they've
+// since expired
+func (p *pipeline) checkTrust(cert *x509.Certificate, chain []*x509.Certificate) (trusted, untrusted []string) {
+	leaf := *cert
+	leaf.UnhandledCriticalExtensions = nil
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if !oid.Equal(poisonOID) {
+			leaf.UnhandledCriticalExtensions = append(leaf.UnhandledCriticalExtensions, oid)
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	at := time.Now()
+	if at.After(leaf.NotAfter) {
+		at = leaf.NotBefore.Add(time.Second)
+	}
+
+	for _, s := range p.roots {
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         s.pool,
+			Intermediates: intermediates,
+			CurrentTime:   at,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err == nil {
+			trusted = append(trusted, s.name)
+		} else {
+			untrusted = append(untrusted, s.name)
+		}
+	}
+	sort.Strings(trusted)
+	sort.Strings(untrusted)
+	return trusted, untrusted
+}
+
+func selfSigned(c *x509.Certificate) bool {
+	return c.CheckSignatureFrom(c) == nil
+}
+
+// fetchIssuer downloads an issuer certificate, which CAs publish as DER or
+// PEM
+func fetchIssuer(ctx context.Context, hc *http.Client, url string) (*x509.Certificate, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "gcrt")
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing issuer: %s", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// LintFinding is a single standards-compliance problem found in a cert
+type LintFinding struct {
+	Lint     string `json:"lint"`
+	Severity string `json:"severity"`
+	Details  string `json:"details"`
+}
+
+// Linter checks certificates for one kind of problem, returning nil when
+// the cert passes
+type Linter interface {
+	Name() string
+	Lint(cert *x509.Certificate) *LintFinding
+}
+
+// lintFunc adapts a function to the Linter interface
+type lintFunc struct {
+	name string
+	fn   func(cert *x509.Certificate) *LintFinding
+}
+
+func (l lintFunc) Name() string { return l.name }
+
+func (l lintFunc) Lint(cert *x509.Certificate) *LintFinding {
+	f := l.fn(cert)
+	if f != nil {
+		f.Lint = l.name
+	}
+	return f
+}
+
+var linters []Linter
+
+// registerLinter adds a linter to the set run by --lint
+func registerLinter(l Linter) {
+	linters = append(linters, l)
+}
+
+func lintError(format string, args ...interface{}) *LintFinding {
+	return &LintFinding{Severity: "error", Details: fmt.Sprintf(format, args...)}
+}
+
+func lintWarning(format string, args ...interface{}) *LintFinding {
+	return &LintFinding{Severity: "warn", Details: fmt.Sprintf(format, args...)}
+}
+
+// lintCert runs every registered linter over a cert
+func lintCert(cert *x509.Certificate) []LintFinding {
+	findings := make([]LintFinding, 0)
+	for _, l := range linters {
+		if f := l.Lint(cert); f != nil {
+			findings = append(findings, *f)
+		}
+	}
+	return findings
+}
+
+func lintCerts(certs []record) {
+	for i := range certs {
+		if certs[i].cert != nil {
+			certs[i].Lint = lintCert(certs[i].cert)
+		}
+	}
+}
+
+var (
+	// CA/Browser Forum Baseline Requirements effective dates
+	brMaxValidity825 = time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	brMaxValidity398 = time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	brNoUnderscores  = time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// internalTLDs are reserved or commonly used suffixes that can't be issued
+// publicly trusted certificates
+var internalTLDs = []string{"local", "localhost", "internal", "lan", "corp", "home", "intranet", "test", "invalid", "example"}
+
+func isServerCert(cert *x509.Certificate) bool {
+	if len(cert.ExtKeyUsage) == 0 {
+		return !cert.IsCA
+	}
+	for _, u := range cert.ExtKeyUsage {
+		if u == x509.ExtKeyUsageServerAuth || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	registerLinter(lintFunc{"rsa_key_too_small", func(cert *x509.Certificate) *LintFinding {
+		if k, ok := cert.PublicKey.(*rsa.PublicKey); ok && k.N.BitLen() < 2048 {
+			return lintError("RSA key is %d bits, at least 2048 are required", k.N.BitLen())
+		}
+		return nil
+	}})
+
+	registerLinter(lintFunc{"ecdsa_weak_curve", func(cert *x509.Certificate) *LintFinding {
+		if k, ok := cert.PublicKey.(*ecdsa.PublicKey); ok && k.Curve == elliptic.P224() {
+			return lintError("ECDSA key uses P-224, P-256 or stronger is required")
+		}
+		return nil
+	}})
+
+	registerLinter(lintFunc{"weak_signature_algorithm", func(cert *x509.Certificate) *LintFinding {
+		switch cert.SignatureAlgorithm {
+		case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+			return lintError("signed with %s", cert.SignatureAlgorithm)
+		}
+		return nil
+	}})
+
+	registerLinter(lintFunc{"validity_too_long", func(cert *x509.Certificate) *LintFinding {
+		if !isServerCert(cert) {
+			return nil
+		}
+		days := int(cert.NotAfter.Sub(cert.NotBefore).Hours() / 24)
+		switch {
+		case !cert.NotBefore.Before(brMaxValidity398) && days > 398:
+			return lintError("valid for %d days, the maximum is 398", days)
+		case !cert.NotBefore.Before(brMaxValidity825) && days > 825:
+			return lintError("valid for %d days, the maximum is 825", days)
+		}
+		return nil
+	}})
+
+	registerLinter(lintFunc{"missing_san", func(cert *x509.Certificate) *LintFinding {
+		if isServerCert(cert) && len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 {
+			return lintError("no subject alternative names")
+		}
+		return nil
+	}})
+
+	registerLinter(lintFunc{"cn_not_in_san", func(cert *x509.Certificate) *LintFinding {
+		cn := cert.Subject.CommonName
+		if len(cn) == 0 || !isServerCert(cert) {
+			return nil
+		}
+		for _, n := range cert.DNSNames {
+			if strings.EqualFold(n, cn) {
+				return nil
+			}
+		}
+		for _, ip := range cert.IPAddresses {
+			if ip.Equal(net.ParseIP(cn)) {
+				return nil
+			}
+		}
+		return lintError("common name %q is not one of the subject alternative names", cn)
+	}})
+
+	registerLinter(lintFunc{"dns_name_underscore", func(cert *x509.Certificate) *LintFinding {
+		if cert.NotBefore.Before(brNoUnderscores) {
+			return nil
+		}
+		for _, n := range cert.DNSNames {
+			if strings.Contains(n, "_") {
+				return lintError("DNS name %q contains an underscore", n)
+			}
+		}
+		return nil
+	}})
+
+	registerLinter(lintFunc{"internal_name", func(cert *x509.Certificate) *LintFinding {
+		for _, n := range cert.DNSNames {
+			labels := strings.Split(strings.TrimSuffix(strings.ToLower(n), "."), ".")
+			tld := labels[len(labels)-1]
+			if len(labels) == 1 {
+				return lintError("DNS name %q is not fully qualified", n)
+			}
+			for _, internal := range internalTLDs {
+				if tld == internal {
+					return lintError("DNS name %q uses the reserved or internal suffix .%s", n, tld)
+				}
+			}
+		}
+		return nil
+	}})
+
+	registerLinter(lintFunc{"serial_number", func(cert *x509.Certificate) *LintFinding {
+		if cert.SerialNumber.Sign() <= 0 {
+			return lintError("serial number is not positive")
+		}
+		if len(cert.SerialNumber.Bytes()) > 20 {
+			return lintError("serial number is %d octets, the maximum is 20", len(cert.SerialNumber.Bytes()))
+		}
+		if len(cert.SerialNumber.Bytes()) < 8 {
+			return lintWarning("serial number has fewer than 64 bits of entropy")
+		}
+		return nil
+	}})
+}
@@ -0,0 +1,303 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jhinds/gcrt/tracing"
+)
+
+// the liveness statuses of a name
+const (
+	statusLive      = "live"
+	statusParked    = "parked"
+	statusDead      = "dead"
+	statusStaleCert = "stale-cert"
+	statusUnknown   = "unknown"
+)
+
+// how much of a page is read when checking whether it's parked
+const probeBodyLimit = 64 << 10
+
+// parkedPattern matches the text of common domain parking and for sale pages
+var parkedPattern = regexp.MustCompile(`(?i)(domain (name )?(is|may be) for sale|buy this domain|this domain is parked|parked (free|domain)|sedoparking|parkingcrew|bodis\.com|dan\.com|afternic|hugedomains)`)
+
+// NameStatus is what gcrt found when checking whether a name is still in use
+type NameStatus struct {
+	Name              string   `json:"name"`
+	RegistrableDomain string   `json:"registrable_domain"`
+	Status            string   `json:"status"`
+	Reason            string   `json:"reason,omitempty"`
+	CNAME             string   `json:"cname,omitempty"`
+	Addresses         []string `json:"addresses,omitempty"`
+	HTTPStatus        int      `json:"http_status,omitempty"`
+	LatestNotAfter    string   `json:"latest_not_after,omitempty"`
+	CertCount         int      `json:"cert_count"`
+
+	latestNotAfter time.Time
+}
+
+// stalenessReport is the --staleness-report output
+type stalenessReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Names       int            `json:"names"`
+	Statuses    map[string]int `json:"statuses"`
+	Candidates  []NameStatus   `json:"candidates"`
+}
+
+// prober resolves and requests names to work out whether they're in use
+type prober struct {
+	resolver *net.Resolver
+	http     *http.Client
+	timeout  time.Duration
+	now      time.Time
+}
+
+func newProber(timeout time.Duration) *prober {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// the point is to see whether anything answers, including hosts
+	// serving a stale or mismatched certificate
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	return &prober{
+		resolver: net.DefaultResolver,
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return http.ErrUseLastResponse
+				}
+				return nil
+			},
+		},
+		timeout: timeout,
+		now:     time.Now().UTC(),
+	}
+}
+
+// nameStatuses collects the names covered by records along with the latest
+// expiry of their certs
+func nameStatuses(records []record) []NameStatus {
+	byName := make(map[string]*NameStatus)
+	for _, r := range records {
+		notAfter, err := r.NotAfterTime()
+		if err != nil {
+			continue
+		}
+		for _, n := range hostnames([]record{r}, false) {
+			s, ok := byName[n]
+			if !ok {
+				s = &NameStatus{Name: n, RegistrableDomain: registrableDomain(n)}
+				byName[n] = s
+			}
+			s.CertCount++
+			if notAfter.After(s.latestNotAfter) {
+				s.latestNotAfter = notAfter
+				s.LatestNotAfter = r.NotAfter
+			}
+		}
+	}
+
+	statuses := make([]NameStatus, 0, len(byName))
+	for _, s := range byName {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// checkAll checks every name, concurrency at a time
+func (p *prober) checkAll(ctx context.Context, statuses []NameStatus, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	jobs := make(chan *NameStatus)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				p.check(ctx, s)
+			}
+		}()
+	}
+
+	for i := range statuses {
+		jobs <- &statuses[i]
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// check resolves the name and requests it over HTTPS, then HTTP, to decide
+// its status:
+//
+//	dead        the name doesn't resolve, or its CNAME points at a name
+//	            that doesn't, or nothing answers on it
+//	parked      it serves a domain parking or for sale page
+//	stale-cert  it answers, but every cert logged for it has expired
+//	live        it answers with a current cert logged
+func (p *prober) check(ctx context.Context, s *NameStatus) {
+	ctx, span := tracing.Start(ctx, "liveness.check")
+	span.SetAttribute("gcrt.name", s.Name)
+	defer func() {
+		span.SetAttribute("gcrt.status", s.Status)
+		span.End()
+	}()
+
+	dctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	if cname, err := p.resolver.LookupCNAME(dctx, s.Name); err == nil && !strings.EqualFold(strings.TrimSuffix(cname, "."), s.Name) {
+		s.CNAME = strings.TrimSuffix(cname, ".")
+	}
+	addrs, err := p.resolver.LookupHost(dctx, s.Name)
+	if err != nil {
+		s.Status, s.Reason = p.failure(ctx, statusDead, "doesn't resolve")
+		if len(s.CNAME) > 0 {
+			s.Reason = "CNAME to " + s.CNAME + " doesn't resolve"
+		}
+		return
+	}
+	s.Addresses = addrs
+
+	code, body, err := p.probe(ctx, s.Name)
+	if err != nil {
+		s.Status, s.Reason = p.failure(ctx, statusDead, "resolves but doesn't answer HTTP or HTTPS")
+		return
+	}
+	s.HTTPStatus = code
+
+	switch {
+	case parkedPattern.Match(body):
+		s.Status, s.Reason = statusParked, "serves a parking or for sale page"
+	case !s.latestNotAfter.After(p.now):
+		s.Status, s.Reason = statusStaleCert, "answers but the newest cert logged expired "+s.LatestNotAfter
+	default:
+		s.Status = statusLive
+	}
+}
+
+// failure returns status unless the failure was down to the run being
+// cancelled, in which case nothing is known about the name
+func (p *prober) failure(ctx context.Context, status, reason string) (string, string) {
+	if ctx.Err() != nil {
+		return statusUnknown, "not checked before the run stopped"
+	}
+	return status, reason
+}
+
+// probe requests the name over HTTPS, falling back to HTTP, returning the
+// status code and the start of the body
+func (p *prober) probe(ctx context.Context, name string) (int, []byte, error) {
+	var lastErr error
+	for _, scheme := range []string{"https", "http"} {
+		req, err := http.NewRequest(http.MethodGet, scheme+"://"+name+"/", nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header.Set("User-Agent", "gcrt")
+
+		resp, err := p.http.Do(req.WithContext(ctx))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, probeBodyLimit))
+		resp.Body.Close()
+		return resp.StatusCode, body, nil
+	}
+	return 0, nil, lastErr
+}
+
+// staleness summarises the statuses, keeping the names that look abandoned
+func staleness(statuses []NameStatus) stalenessReport {
+	report := stalenessReport{
+		GeneratedAt: time.Now().UTC(),
+		Names:       len(statuses),
+		Statuses:    make(map[string]int),
+		Candidates:  []NameStatus{},
+	}
+	for _, s := range statuses {
+		report.Statuses[s.Status]++
+		if s.Status != statusLive && s.Status != statusUnknown {
+			report.Candidates = append(report.Candidates, s)
+		}
+	}
+	return report
+}
+
+// writeLiveness checks the names of records and writes their statuses, or
+// the staleness report
+func (o options) writeLiveness(ctx context.Context, w io.Writer, records []record, rd *redactor) error {
+	statuses := nameStatuses(records)
+	newProber(o.probeTimeout).checkAll(ctx, statuses, o.probeConcurrency)
+	rd.applyNames(statuses)
+
+	if o.stalenessReport {
+		report := staleness(statuses)
+		if o.count {
+			fmt.Fprintf(w, "Number of stale names found: %d\n", len(report.Candidates))
+			return nil
+		}
+		if o.output != "csv" && o.output != "tsv" {
+			output, err := json.MarshalIndent(report, "", "    ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, string(output))
+			return nil
+		}
+		statuses = report.Candidates
+	}
+
+	if o.count {
+		fmt.Fprintf(w, "Number of names found: %d\n", len(statuses))
+		return nil
+	}
+	return writeNameStatuses(w, o.output, statuses)
+}
+
+// writeNameStatuses writes name statuses as JSON, or CSV separated by comma
+func writeNameStatuses(w io.Writer, format string, statuses []NameStatus) error {
+	switch format {
+	case "csv", "tsv":
+		rows := make([][]string, len(statuses))
+		for i, s := range statuses {
+			code := ""
+			if s.HTTPStatus > 0 {
+				code = strconv.Itoa(s.HTTPStatus)
+			}
+			rows[i] = []string{
+				s.Name, s.RegistrableDomain, s.Status, s.Reason, s.CNAME,
+				strings.Join(s.Addresses, " "), code, s.LatestNotAfter, strconv.Itoa(s.CertCount),
+			}
+		}
+		header := []string{"name", "registrable_domain", "status", "reason", "cname", "addresses", "http_status", "latest_not_after", "cert_count"}
+		return writeRows(w, delimiter(format), header, rows)
+	default:
+		output, err := json.MarshalIndent(&statuses, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+// certPage is the result of reading a cert's crt.sh page
+type certPage struct {
+	detail client.CertDetail
+	err    string
+}
+
+// caPage is the result of reading a CA's crt.sh page
+type caPage struct {
+	ids []int
+	err string
+}
+
+// logsStage reads which CT logs each cert is in from its crt.sh page, and
+// the crt.sh ids of its issuer's certs from the issuer's page. Each issuer's
+// page is only read once
+func (p *pipeline) logsStage(ctx context.Context, records []record) ([]record, error) {
+	var ids []string
+	for _, r := range records {
+		// certs without a crt.sh id, from feeds and streams, have no page
+		if r.ID != 0 {
+			ids = append(ids, strconv.Itoa(r.ID))
+		}
+	}
+	pages := p.lookupAll(ctx, "logs", ids, func(ctx context.Context, key string) interface{} {
+		id, _ := strconv.Atoi(key)
+		d, err := p.c.CertDetail(ctx, id)
+		if err != nil {
+			return certPage{err: err.Error()}
+		}
+		return certPage{detail: d}
+	})
+
+	// the search gives the issuer of most certs, the page the rest
+	issuerOf := func(r record) int64 {
+		if r.IssuerCAID != 0 {
+			return r.IssuerCAID
+		}
+		page, _ := pages[strconv.Itoa(r.ID)].(certPage)
+		return page.detail.IssuerCAID
+	}
+	var caKeys []string
+	for _, r := range records {
+		if caID := issuerOf(r); r.ID != 0 && caID != 0 {
+			caKeys = append(caKeys, "ca:"+strconv.FormatInt(caID, 10))
+		}
+	}
+	cas := p.lookupAll(ctx, "logs", caKeys, func(ctx context.Context, key string) interface{} {
+		caID, _ := strconv.ParseInt(key[len("ca:"):], 10, 64)
+		certIDs, err := p.c.CACertificates(ctx, caID)
+		if err != nil {
+			return caPage{err: err.Error()}
+		}
+		return caPage{ids: certIDs}
+	})
+
+	for i := range records {
+		r := &records[i]
+		page, ok := pages[strconv.Itoa(r.ID)].(certPage)
+		if r.ID == 0 || !ok {
+			continue
+		}
+		if len(page.err) > 0 {
+			r.LogEntriesError = page.err
+			continue
+		}
+		r.LogEntries = page.detail.LogEntries
+		r.LogOperators = logOperators(page.detail.LogEntries)
+		r.IssuerCAID = issuerOf(*r)
+		if ca, ok := cas["ca:"+strconv.FormatInt(r.IssuerCAID, 10)].(caPage); ok {
+			r.IssuerCertIDs = ca.ids
+			r.LogEntriesError = ca.err
+		}
+	}
+	return records, nil
+}
+
+// logOperators are the distinct operators of the logs a cert is in, as CT
+// policies require a cert to be in logs run by more than one
+func logOperators(entries []client.LogEntry) []string {
+	seen := make(map[string]bool)
+	var operators []string
+	for _, e := range entries {
+		if len(e.Operator) > 0 && !seen[e.Operator] {
+			seen[e.Operator] = true
+			operators = append(operators, e.Operator)
+		}
+	}
+	sort.Strings(operators)
+	return operators
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// the ways a domain can look like another
+const (
+	// lookalikeHomograph is an internationalised domain whose letters look
+	// like the other's, such as a Cyrillic а for an a
+	lookalikeHomograph = "homograph"
+	// lookalikeConfusable swaps in ASCII characters that look alike, such
+	// as 1 for l or rn for m
+	lookalikeConfusable = "confusable"
+	// lookalikeTypo is a character or two away from the other, the typos
+	// and transpositions of typosquats
+	lookalikeTypo = "typo"
+	// lookalikeCombo adds to the other's name, such as example-login.com,
+	// or puts it in front of another domain, as in example.com.evil.net
+	lookalikeCombo = "combo"
+	// lookalikeTLD is the same name under another public suffix
+	lookalikeTLD = "tld"
+)
+
+// confusables are the ASCII spellings that pass for others at a glance
+var confusables = strings.NewReplacer(
+	"rn", "m", "vv", "w", "cl", "d",
+	"0", "o", "1", "l", "i", "l", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b",
+	"-", "",
+)
+
+// homoglyphs are the letters of other scripts commonly passed off as Latin
+// ones in internationalised domains
+var homoglyphs = map[rune]rune{
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j',
+	'ԁ': 'd', 'ѕ': 's', 'һ': 'h', 'ӏ': 'l', 'ԛ': 'q', 'ԝ': 'w', 'ո': 'n', 'ս': 'u',
+	'α': 'a', 'ο': 'o', 'ν': 'v', 'ρ': 'p', 'τ': 't', 'κ': 'k', 'ι': 'i',
+	'ɡ': 'g', 'ı': 'i', 'ḿ': 'm',
+}
+
+// skeleton is a label with its confusable characters replaced, so labels
+// that look alike have the same skeleton
+func skeleton(label string) string {
+	return confusables.Replace(strings.ToLower(label))
+}
+
+// splitSuffix splits a registrable domain into its name and public suffix,
+// e.g. example and co.uk
+func splitSuffix(domain string) (string, string) {
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return strings.TrimSuffix(strings.TrimSuffix(domain, suffix), "."), suffix
+}
+
+// lookalike reports how name, a hostname, looks like the registrable domain
+// base, or an empty string when it doesn't or is under base itself. The
+// distance is how many edits the names are apart for typos
+func lookalike(name, base string) (kind string, distance int) {
+	name = strings.TrimPrefix(strings.ToLower(name), "*.")
+	reg := registrableDomain(name)
+	if reg == base || len(reg) == 0 {
+		return "", 0
+	}
+	n, nSuffix := splitSuffix(reg)
+	b, bSuffix := splitSuffix(base)
+	if len(n) == 0 || len(b) == 0 {
+		return "", 0
+	}
+
+	if unicode, err := idna.ToUnicode(n); err == nil && unicode != n {
+		folded := []rune(unicode)
+		for i, r := range folded {
+			if l, ok := homoglyphs[r]; ok {
+				folded[i] = l
+			}
+		}
+		if f := string(folded); f == b || skeleton(f) == skeleton(b) {
+			return lookalikeHomograph, 0
+		}
+	}
+	if n == b {
+		if nSuffix != bSuffix {
+			return lookalikeTLD, 0
+		}
+		return "", 0
+	}
+	if skeleton(n) == skeleton(b) {
+		return lookalikeConfusable, 0
+	}
+	if d := editDistance(n, b); d == 1 || (d == 2 && len(b) >= 8) {
+		return lookalikeTypo, d
+	}
+	// short names turn up inside too many others to be worth reporting
+	if len(b) >= 4 {
+		if strings.Contains(n, b) {
+			return lookalikeCombo, 0
+		}
+		for _, label := range strings.Split(strings.TrimSuffix(name, reg), ".") {
+			if label == b {
+				return lookalikeCombo, 0
+			}
+		}
+	}
+	return "", 0
+}
+
+// editDistance is the number of insertions, deletions, substitutions and
+// transpositions of neighbouring characters that turn a into b
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// rows of the distances between the prefixes of s and t, the current
+	// row and the two before it
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
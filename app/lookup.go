@@ -0,0 +1,103 @@
+package app
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// the prefixes of lookup targets, written the way crt.sh takes them e.g.
+// serial=04ab...
+const (
+	serialPrefix = "serial="
+	sha256Prefix = "sha256="
+)
+
+var lookupOpts struct {
+	serials []string
+	sha256s []string
+}
+
+var lookupCmd = &cobra.Command{
+	Use:   "lookup",
+	Short: "Find certificates by serial number or SHA-256 fingerprint",
+	Long: `lookup finds the certificates with a serial number or SHA-256 fingerprint,
+such as one found in a log, and outputs them like gcrt does. Both are hex and
+may be written with colons or spaces between the bytes`,
+	Example: `  gcrt lookup --serial 04:ab:12:cd
+  gcrt lookup --sha256 3f8b...e1 -o table`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		o := opts
+		if !o.onlyCrtsh() {
+			return errors.New("gcrt lookup only searches crt.sh, the other sources can't search by serial or fingerprint")
+		}
+		// only the lookups are searched, not any domains from the config file
+		o.domains, o.identities, o.orgs, o.stdin = nil, nil, nil, false
+		var err error
+		if o.lookups, err = lookupTargets(lookupOpts.serials, lookupOpts.sha256s); err != nil {
+			return err
+		}
+
+		out, err := o.openOutput(false)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		runErr := runTraced(ctx, o, out)
+		if err := out.Close(); err != nil && runErr == nil {
+			runErr = err
+		}
+		return runErr
+	},
+}
+
+func init() {
+	lookupCmd.Flags().StringArrayVar(&lookupOpts.serials, "serial", nil, "Find the certificates with this serial number, in hex (may be repeated)")
+	lookupCmd.Flags().StringArrayVar(&lookupOpts.sha256s, "sha256", nil, "Find the certificate with this SHA-256 fingerprint, in hex (may be repeated)")
+	cmd.AddCommand(lookupCmd)
+}
+
+// lookupTargets are the searches for the --serial and --sha256 values
+func lookupTargets(serials, sha256s []string) ([]string, error) {
+	var targets []string
+	for _, s := range serials {
+		serial, err := normaliseHex(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --serial %q, must be hex", s)
+		}
+		// crt.sh doesn't match serials with a leading zero byte
+		for strings.HasPrefix(serial, "00") && len(serial) > 2 {
+			serial = serial[2:]
+		}
+		targets = append(targets, serialPrefix+serial)
+	}
+	for _, s := range sha256s {
+		fingerprint, err := normaliseHex(s)
+		if err != nil || len(fingerprint) != 64 {
+			return nil, fmt.Errorf("invalid --sha256 %q, must be 64 hex digits", s)
+		}
+		targets = append(targets, sha256Prefix+fingerprint)
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("give a --serial or --sha256 to look up")
+	}
+	return targets, nil
+}
+
+// normaliseHex lowercases a hex string and drops any colons and spaces
+// between its bytes
+func normaliseHex(s string) (string, error) {
+	s = strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(s)))
+	if len(s) == 0 || len(s)%2 != 0 {
+		return "", errors.New("not hex")
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
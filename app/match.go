@@ -0,0 +1,90 @@
+package app
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// globPrefix marks a --match or --exclude written as a glob rather than a
+// regular expression, as .* and ? mean different things to each
+const globPrefix = "glob:"
+
+// nameMatcher reports whether a lowercased name matches a pattern
+type nameMatcher func(name string) bool
+
+// newNameMatchers compiles the --match or --exclude patterns. A glob such as
+// glob:*.dev.example.com has to match the whole name, while a regular
+// expression such as vpn|gateway matches anywhere in it, ignoring case
+func newNameMatchers(flag string, patterns []string) ([]nameMatcher, error) {
+	matchers := make([]nameMatcher, len(patterns))
+	for i, p := range patterns {
+		if strings.HasPrefix(p, globPrefix) {
+			glob := strings.ToLower(strings.TrimPrefix(p, globPrefix))
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid --%s glob %s: %s", flag, p, err)
+			}
+			matchers[i] = func(name string) bool {
+				ok, _ := path.Match(glob, name)
+				return ok
+			}
+			continue
+		}
+
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s pattern %s: %s", flag, p, err)
+		}
+		matchers[i] = re.MatchString
+	}
+	return matchers, nil
+}
+
+func matchesAnyName(name string, matchers []nameMatcher) bool {
+	for _, m := range matchers {
+		if m(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameMatchers compiles --match and --exclude
+func (o options) nameMatchers() (include, exclude []nameMatcher, err error) {
+	if include, err = newNameMatchers("match", o.matchNames); err != nil {
+		return nil, nil, err
+	}
+	if exclude, err = newNameMatchers("exclude", o.excludeNames); err != nil {
+		return nil, nil, err
+	}
+	return include, exclude, nil
+}
+
+// matchingNames are the names that match one of include, when given, and
+// none of exclude
+func matchingNames(names []string, include, exclude []nameMatcher) []string {
+	var kept []string
+	for _, n := range names {
+		if len(include) > 0 && !matchesAnyName(n, include) {
+			continue
+		}
+		if !matchesAnyName(n, exclude) {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// filterNames keeps the records with at least one name that matches one of
+// include, when given, and none of exclude. A cert whose names are all
+// excluded is dropped, one that also covers a name that isn't is kept
+func filterNames(records []record, include, exclude []nameMatcher) []record {
+	var kept []record
+	for _, r := range records {
+		if len(matchingNames(r.Names(), include, exclude)) > 0 {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,40 @@
+package app
+
+import "testing"
+
+func TestNameMatchers(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{".*admin.*", "admin.example.com", true},
+		{".*admin.*", "www.example.com", false},
+		{"api.*", "api.example.com", true},
+		{"dev?", "de.example.com", true},
+		{"vpn|gateway", "gateway.example.com", true},
+		{"VPN", "vpn.example.com", true},
+		{"glob:*.dev.example.com", "a.dev.example.com", true},
+		{"glob:*.dev.example.com", "dev.example.com.evil.com", false},
+		{"glob:*.DEV.example.com", "a.dev.example.com", true},
+		{"glob:api?.example.com", "api1.example.com", true},
+		// a glob has to match the whole name, a regular expression needn't
+		{"glob:api", "api.example.com", false},
+		{"*.example.com", "www.example.com", false},
+	} {
+		matchers, err := newNameMatchers("match", []string{tc.pattern})
+		if err != nil {
+			if tc.want {
+				t.Errorf("%s: %s", tc.pattern, err)
+			}
+			continue
+		}
+		if got := matchesAnyName(tc.name, matchers); got != tc.want {
+			t.Errorf("%s matching %s = %t, want %t", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := newNameMatchers("match", []string{"glob:[a-"}); err == nil {
+		t.Error("a malformed glob was accepted")
+	}
+}
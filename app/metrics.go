@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+	"github.com/jhinds/gcrt/metrics"
+)
+
+// gcrtMetrics are what watch and serve report on /metrics. A nil
+// gcrtMetrics reports nothing
+type gcrtMetrics struct {
+	registry *metrics.Registry
+
+	found       *metrics.Counter
+	discovered  *metrics.Counter
+	failures    *metrics.Counter
+	runs        *metrics.Counter
+	lastSuccess *metrics.Gauge
+	queueDepth  *metrics.Gauge
+	requests    *metrics.Counter
+	retries     *metrics.Counter
+	errors      *metrics.Counter
+}
+
+func newMetrics() *gcrtMetrics {
+	r := metrics.NewRegistry()
+	return &gcrtMetrics{
+		registry:    r,
+		found:       r.Counter("gcrt_certs_found_total", "Certificates returned by searches of the domain", "domain"),
+		discovered:  r.Counter("gcrt_certs_discovered_total", "Certificates watch hadn't seen before", "domain"),
+		failures:    r.Counter("gcrt_search_failures_total", "Searches of the domain that failed", "domain"),
+		runs:        r.Counter("gcrt_runs_total", "Watch polls and serve searches, by whether they succeeded", "result"),
+		lastSuccess: r.Gauge("gcrt_last_success_timestamp_seconds", "When the last successful watch poll or serve search finished, as a Unix time"),
+		queueDepth:  r.Gauge("gcrt_queue_depth", "Domains waiting to be searched or being searched"),
+		requests:    r.Counter("gcrt_crtsh_requests_total", "Requests made to crt.sh and the other sources, including retries"),
+		retries:     r.Counter("gcrt_crtsh_retries_total", "Requests to crt.sh and the other sources that were retries"),
+		errors:      r.Counter("gcrt_crtsh_errors_total", "Requests to crt.sh and the other sources that failed after any retries"),
+	}
+}
+
+// clientMetrics counts the client's requests
+func (m *gcrtMetrics) clientMetrics() client.Metrics {
+	return client.Metrics{
+		Attempt: func(retry bool) {
+			m.requests.Inc()
+			if retry {
+				m.retries.Inc()
+			}
+		},
+		Failed: func() { m.errors.Inc() },
+	}
+}
+
+// searched records the result of searching a domain
+func (m *gcrtMetrics) searched(domain string, found int, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.failures.Inc(domain)
+		return
+	}
+	m.found.Add(float64(found), domain)
+}
+
+// finished records the result of a watch poll or serve search
+func (m *gcrtMetrics) finished(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.runs.Inc("failure")
+		return
+	}
+	m.runs.Inc("success")
+	m.lastSuccess.Set(float64(time.Now().Unix()))
+}
+
+// queued changes the number of domains waiting to be searched by n
+func (m *gcrtMetrics) queued(n int) {
+	if m == nil {
+		return
+	}
+	m.queueDepth.Add(float64(n))
+}
+
+// discoveredCerts counts the new certs watch found for each domain
+func (m *gcrtMetrics) discoveredCerts(records []record) {
+	if m == nil {
+		return
+	}
+	for _, r := range records {
+		m.discovered.Inc(r.SourceDomain)
+	}
+}
+
+// serveMetrics serves /metrics on addr until ctx is done
+func (m *gcrtMetrics) serveMetrics(ctx context.Context, addr string) error {
+	// listening first fails straight away if addr can't be used
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.registry)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			log.WithError(err).Error("serving metrics failed")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Infof("serving metrics on %s/metrics", addr)
+	return nil
+}
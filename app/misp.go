@@ -0,0 +1,146 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mispEvent is a MISP event in the JSON form MISP imports
+type mispEvent struct {
+	UUID          string          `json:"uuid"`
+	Info          string          `json:"info"`
+	Date          string          `json:"date"`
+	Timestamp     string          `json:"timestamp"`
+	ThreatLevelID string          `json:"threat_level_id"`
+	Analysis      string          `json:"analysis"`
+	Distribution  string          `json:"distribution"`
+	Attributes    []mispAttribute `json:"Attribute"`
+	Objects       []mispObject    `json:"Object"`
+}
+
+type mispAttribute struct {
+	UUID           string `json:"uuid"`
+	ObjectRelation string `json:"object_relation,omitempty"`
+	Type           string `json:"type"`
+	Category       string `json:"category"`
+	Value          string `json:"value"`
+	ToIDS          bool   `json:"to_ids"`
+	Comment        string `json:"comment,omitempty"`
+}
+
+type mispObject struct {
+	UUID         string          `json:"uuid"`
+	Name         string          `json:"name"`
+	MetaCategory string          `json:"meta-category"`
+	Comment      string          `json:"comment,omitempty"`
+	Attributes   []mispAttribute `json:"Attribute"`
+}
+
+// mispEventInfo describes the event by the domains searched, or the
+// registrable domains of the names found for other searches
+func mispEventInfo(records []record) string {
+	seen := make(map[string]bool)
+	var domains []string
+	for _, r := range records {
+		d := r.SourceDomain
+		if len(d) == 0 {
+			d = registrableDomain(r.CommonName)
+		}
+		if len(d) > 0 && !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+	sort.Strings(domains)
+	if len(domains) > 5 {
+		domains = append(domains[:5], fmt.Sprintf("and %d more", len(domains)-5))
+	}
+	if len(domains) == 0 {
+		return "Certificate transparency search"
+	}
+	return "Certificate transparency search for " + strings.Join(domains, ", ")
+}
+
+// writeMISP writes records as a MISP event, with an x509 object for each
+// cert and a domain attribute for each name on them, for threat intel
+// platforms to import. The event is left at the lowest distribution, for
+// the importing organisation only
+func writeMISP(w io.Writer, records []record, fields []string) error {
+	if len(fields) > 0 {
+		return errors.New("--fields can't be used with the misp output format")
+	}
+	// every attribute and object needs its own uuid
+	var uuidErr error
+	newUUID := func() string {
+		id, err := uuid4()
+		if err != nil && uuidErr == nil {
+			uuidErr = err
+		}
+		return id
+	}
+	attribute := func(relation, kind, category, value string) mispAttribute {
+		return mispAttribute{UUID: newUUID(), ObjectRelation: relation, Type: kind, Category: category, Value: value}
+	}
+
+	now := time.Now().UTC()
+	event := mispEvent{
+		UUID:          newUUID(),
+		Info:          mispEventInfo(records),
+		Date:          now.Format("2006-01-02"),
+		Timestamp:     strconv.FormatInt(now.Unix(), 10),
+		ThreatLevelID: "4",
+		Analysis:      "0",
+		Distribution:  "0",
+		Attributes:    make([]mispAttribute, 0),
+		Objects:       make([]mispObject, 0, len(records)),
+	}
+
+	for _, r := range records {
+		obj := mispObject{UUID: newUUID(), Name: "x509", MetaCategory: "network", Comment: r.Link()}
+		if len(r.SerialNumber) > 0 {
+			obj.Attributes = append(obj.Attributes, attribute("serial-number", "text", "Other", r.SerialNumber))
+		}
+		obj.Attributes = append(obj.Attributes, attribute("issuer", "text", "Other", r.IssuerName))
+		subject := "CN=" + r.CommonName
+		if r.cert != nil {
+			subject = r.cert.Subject.String()
+		}
+		obj.Attributes = append(obj.Attributes, attribute("subject", "text", "Other", subject))
+		if t := stixTime(r.NotBefore); len(t) > 0 {
+			obj.Attributes = append(obj.Attributes, attribute("validity-not-before", "datetime", "Other", t))
+		}
+		if t := stixTime(r.NotAfter); len(t) > 0 {
+			obj.Attributes = append(obj.Attributes, attribute("validity-not-after", "datetime", "Other", t))
+		}
+		if fp := sha256Of(r); len(fp) > 0 {
+			obj.Attributes = append(obj.Attributes, attribute("x509-fingerprint-sha256", "x509-fingerprint-sha256", "Network activity", fp))
+		}
+		for _, n := range r.Names() {
+			if !strings.ContainsAny(n, "@ *") {
+				obj.Attributes = append(obj.Attributes, attribute("dns_names", "hostname", "Network activity", n))
+			}
+		}
+		event.Objects = append(event.Objects, obj)
+	}
+	for _, n := range domainNames(records) {
+		event.Attributes = append(event.Attributes, attribute("", "domain", "Network activity", n))
+	}
+	if uuidErr != nil {
+		return uuidErr
+	}
+
+	output, err := json.MarshalIndent(struct {
+		Event mispEvent `json:"Event"`
+	}{event}, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(output))
+	return nil
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"sort"
+	"strings"
+)
+
+// hostnames returns the sorted, distinct hostnames covered by records.
+// Leading wildcard labels are stripped unless keepWildcards is set, and
+// names that aren't hostnames, such as email addresses, are skipped
+func hostnames(records []record, keepWildcards bool) []string {
+	seen := make(map[string]struct{})
+	var names []string
+
+	for _, r := range records {
+		for _, n := range r.Names() {
+			if strings.ContainsAny(n, "@ ") {
+				continue
+			}
+			if !keepWildcards {
+				n = strings.TrimPrefix(n, "*.")
+			}
+			if _, ok := seen[n]; !ok {
+				seen[n] = struct{}{}
+				names = append(names, n)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
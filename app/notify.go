@@ -0,0 +1,236 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jhinds/gcrt/tracing"
+	"github.com/spf13/cobra"
+)
+
+// notifyLineLimit caps how many certs are listed in a chat or email alert
+const notifyLineLimit = 20
+
+// notifier delivers an alert about newly discovered certificates
+type notifier interface {
+	Name() string
+	Notify(ctx context.Context, records []record) error
+}
+
+var notifyOpts struct {
+	webhooks []string
+	slack    []string
+	smtpAddr string
+	smtpFrom string
+	smtpTo   []string
+	smtpUser string
+}
+
+// addNotifyFlags adds the alerting flags to the commands that report new
+// certificates
+func addNotifyFlags(c *cobra.Command) {
+	c.Flags().StringSliceVar(&notifyOpts.webhooks, "webhook-url", nil, "POST new certificates as JSON to this URL (may be repeated)")
+	c.Flags().StringSliceVar(&notifyOpts.slack, "slack-webhook", nil, "Post new certificates to this Slack incoming webhook URL (may be repeated)")
+	c.Flags().StringVar(&notifyOpts.smtpAddr, "smtp-server", "", "Email new certificates through this SMTP server, as host:port")
+	c.Flags().StringVar(&notifyOpts.smtpFrom, "smtp-from", "", "Sender address for email alerts")
+	c.Flags().StringSliceVar(&notifyOpts.smtpTo, "smtp-to", nil, "Recipient addresses for email alerts")
+	c.Flags().StringVar(&notifyOpts.smtpUser, "smtp-user", "", "SMTP username, the password is read from GCRT_SMTP_PASSWORD")
+}
+
+// notifiers builds the notifiers configured by the flags. The SMTP password
+// is read from GCRT_SMTP_PASSWORD so it doesn't show up in process listings
+func notifiers() ([]notifier, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	var ns []notifier
+
+	for _, u := range notifyOpts.webhooks {
+		ns = append(ns, webhookNotifier{url: u, http: httpClient})
+	}
+	for _, u := range notifyOpts.slack {
+		ns = append(ns, slackNotifier{url: u, http: httpClient})
+	}
+
+	if len(notifyOpts.smtpAddr) > 0 {
+		if len(notifyOpts.smtpFrom) == 0 || len(notifyOpts.smtpTo) == 0 {
+			return nil, fmt.Errorf("--smtp-server requires --smtp-from and --smtp-to")
+		}
+		ns = append(ns, smtpNotifier{
+			addr:     notifyOpts.smtpAddr,
+			from:     notifyOpts.smtpFrom,
+			to:       notifyOpts.smtpTo,
+			username: notifyOpts.smtpUser,
+			password: os.Getenv("GCRT_SMTP_PASSWORD"),
+		})
+	}
+	return ns, nil
+}
+
+// notifyAll sends records to every notifier, returning the first error
+// after trying them all
+func notifyAll(ctx context.Context, ns []notifier, records []record) error {
+	var firstErr error
+	for _, n := range ns {
+		nctx, span := tracing.Start(ctx, "notify."+n.Name())
+		err := n.Notify(nctx, records)
+		span.SetError(err)
+		span.End()
+
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s notification failed: %s", n.Name(), err)
+		}
+	}
+	return firstErr
+}
+
+// alertSubject is the one line summary of an alert
+func alertSubject(records []record) string {
+	domains := make(map[string]struct{})
+	for _, r := range records {
+		if len(r.SourceDomain) > 0 {
+			domains[r.SourceDomain] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(domains))
+	for d := range domains {
+		names = append(names, d)
+	}
+	sort.Strings(names)
+
+	subject := fmt.Sprintf("%d new certificate(s) found", len(records))
+	if len(names) > 0 {
+		subject += " for " + strings.Join(names, ", ")
+	}
+	return subject
+}
+
+// alertLines describes each cert on one line, using link to format the
+// crt.sh link
+func alertLines(records []record, link func(url, text string) string) []string {
+	var lines []string
+	for i, r := range records {
+		if i == notifyLineLimit {
+			lines = append(lines, fmt.Sprintf("... and %d more", len(records)-notifyLineLimit))
+			break
+		}
+
+		var line string
+		if names := r.Names(); len(names) > 0 {
+			line = fmt.Sprintf("%s %s issued by %s, valid %s to %s",
+				link(r.Link(), fmt.Sprintf("#%d", r.ID)), strings.Join(names, ", "), r.IssuerName, r.NotBefore, r.NotAfter)
+		} else {
+			// feed entries only have a title
+			line = link(r.Link(), fmt.Sprintf("#%d", r.ID)) + " " + r.FeedTitle
+		}
+		if len(r.Severity) > 0 {
+			line += fmt.Sprintf(" [%s]", r.Severity)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func postJSON(ctx context.Context, c *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// webhookNotifier POSTs the certs as JSON
+type webhookNotifier struct {
+	url  string
+	http *http.Client
+}
+
+func (webhookNotifier) Name() string { return "webhook" }
+
+func (n webhookNotifier) Notify(ctx context.Context, records []record) error {
+	return postJSON(ctx, n.http, n.url, struct {
+		Event        string   `json:"event"`
+		Summary      string   `json:"summary"`
+		Count        int      `json:"count"`
+		Certificates []record `json:"certificates"`
+	}{
+		Event:        "new_certificates",
+		Summary:      alertSubject(records),
+		Count:        len(records),
+		Certificates: records,
+	})
+}
+
+// slackNotifier posts a message to a Slack incoming webhook
+type slackNotifier struct {
+	url  string
+	http *http.Client
+}
+
+func (slackNotifier) Name() string { return "slack" }
+
+func (n slackNotifier) Notify(ctx context.Context, records []record) error {
+	lines := alertLines(records, func(url, text string) string {
+		return "<" + url + "|" + text + ">"
+	})
+	text := "*" + alertSubject(records) + "*\n• " + strings.Join(lines, "\n• ")
+	return postJSON(ctx, n.http, n.url, map[string]string{"text": text})
+}
+
+// smtpNotifier emails a plain text alert
+type smtpNotifier struct {
+	addr     string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func (smtpNotifier) Name() string { return "smtp" }
+
+func (n smtpNotifier) Notify(ctx context.Context, records []record) error {
+	lines := alertLines(records, func(url, text string) string {
+		return text + " (" + url + ")"
+	})
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: gcrt: %s\r\n", alertSubject(records))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, l := range lines {
+		msg.WriteString(l + "\r\n")
+	}
+
+	var auth smtp.Auth
+	if len(n.username) > 0 {
+		host, _, err := net.SplitHostPort(n.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.username, n.password, host)
+	}
+	return smtp.SendMail(n.addr, auth, n.from, n.to, msg.Bytes())
+}
@@ -0,0 +1,574 @@
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+	"github.com/jhinds/gcrt/tracing"
+)
+
+// options controls a single run of gcrt. The root command's flags fill in
+// opts
+type options struct {
+	domains     []string
+	stdin       bool
+	autoExpand  bool
+	orgs        []string
+	identities  []string
+	concurrency int
+	shard       bool
+	sample      int
+	sampleScan  int
+
+	sources      []string
+	ctLogs       []string
+	ctLogEntries int64
+	// built from sources for the run when they're more than crt.sh
+	source client.Source
+
+	dedupeKey       string
+	noDedupe        bool
+	includePrecerts bool
+	precertsOnly    bool
+
+	excludeExpired bool
+	serverDedupe   bool
+	crtshParams    []string
+
+	backoffFile string
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	// loaded from backoffFile for the run, or shared by the runs of bulk
+	backoff *backoffState
+
+	permutationsFile string
+	batchSize        int
+	batchDelay       time.Duration
+
+	between         string
+	notAfterBetween string
+	days            int
+	count           bool
+	quiet           bool
+	strict          bool
+	output          string
+	fields          []string
+
+	// template is --template, and tmpl it parsed
+	template string
+	tmpl     *template.Template
+
+	verbosity    int
+	showProgress bool
+
+	sortField string
+	reverse   bool
+
+	envelope bool
+
+	redact         []string
+	redactPatterns []string
+
+	maxDuration  time.Duration
+	retryBudget  int
+	timeout      time.Duration
+	retries      int
+	retryWaitMax time.Duration
+	rateLimit    float64
+
+	proxy     string
+	insecure  bool
+	caCert    string
+	userAgent string
+	// set once a run has gone past maxDuration, or been interrupted, for
+	// the output status
+	timedOut    bool
+	interrupted bool
+
+	downloadDir string
+
+	// set by gcrt export to write the records to a database instead
+	sqlite string
+
+	enrich            []string
+	enrichConcurrency map[string]int
+	enrichCacheTTL    time.Duration
+	whoisServer       string
+	geoipURL          string
+
+	fetchIssuers    bool
+	logEntries      bool
+	checkRevocation bool
+	crlTimeout      time.Duration
+	checkCAA        bool
+	caaResolver     string
+	caaCAs          []string
+	rootStoreFiles  []string
+
+	outFile        string
+	manifest       bool
+	signKey        string
+	rotateSize     string
+	rotateAge      string
+	rotateKeep     int
+	rotateCompress bool
+	trace          string
+	otelURL        string
+
+	aggregate     bool
+	namesOnly     bool
+	keepWildcards bool
+
+	wildcardsOnly   bool
+	wildcardSummary bool
+
+	registrable      []string
+	groupRegistrable bool
+	merge            []string
+	diffAgainst      string
+
+	issuers        []string
+	excludeIssuers []string
+	issuerCAIDs    []int
+
+	matchNames   []string
+	excludeNames []string
+
+	resolve            bool
+	onlyLive           bool
+	resolveConcurrency int
+
+	liveness         bool
+	stalenessReport  bool
+	probeTimeout     time.Duration
+	probeConcurrency int
+
+	expired        bool
+	activeOnly     bool
+	expiringWithin string
+
+	// client is set by gcrt serve, so every search it runs shares one
+	client *client.Client
+	// metrics is set by watch and serve when they report on /metrics
+	metrics *gcrtMetrics
+	// outcome tallies the searches of a run for its exit status
+	outcome *runOutcome
+	// progress is drawn on stderr with --progress
+	progress *progressBar
+
+	// expiry is set by gcrt expiry, which reports on the results instead
+	// of outputting them
+	expiry *expiryAudit
+	// summary is set by gcrt summary, which outputs a line per hostname
+	// instead of the certs
+	summary bool
+	// lookups are set by gcrt lookup, the serials and fingerprints searched
+	// for instead of domains
+	lookups []string
+	// analysis is set by gcrt analyze, which outputs its findings about the
+	// certs instead of them
+	analysis *analysis
+	// squat is set by gcrt squat, which searches the permutations of the
+	// domains instead and reports the ones with certs
+	squat *squatSweep
+	// timeline is set by gcrt timeline, which counts the certs issued in
+	// each period instead of outputting them
+	timeline *timeline
+
+	classify bool
+	ekus     []string
+	types    []string
+	levels   []string
+	lint     bool
+
+	rules  string
+	score  bool
+	minSev string
+}
+
+var opts options
+
+// query builds the crt.sh query described by the options, for every domain
+func (o options) query() (client.Query, error) {
+	var q client.Query
+
+	if len(o.between) > 0 { // filter by date range
+		var err error
+		if q.Since, q.Until, err = parseDateRange("--between", o.between); err != nil {
+			return q, err
+		}
+	} else if o.days > 0 { // filter certs by days ago threshold
+		// compare against midnight UTC, days ago, as crt.sh's timestamps are
+		// UTC whatever the local time zone
+		now := time.Now().UTC()
+		q.Since = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -o.days)
+	}
+	if len(o.notAfterBetween) > 0 {
+		var err error
+		if q.NotAfterSince, q.NotAfterUntil, err = parseDateRange("--not-after-between", o.notAfterBetween); err != nil {
+			return q, err
+		}
+	}
+
+	dedupe, err := o.dedupe()
+	if err != nil {
+		return q, err
+	}
+	q.Dedupe = dedupe
+
+	if o.serverDedupe && (dedupe == client.DedupeID || dedupe == client.DedupeNone) {
+		return q, fmt.Errorf("--server-dedupe drops precertificates, so it can't be used with --include-precerts, --precerts-only or --no-dedupe")
+	}
+	q.ExcludeExpired = o.excludeExpired
+	q.ServerDedupe = o.serverDedupe
+	if q.Params, err = crtshParams(o.crtshParams); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+// reservedParams are the crt.sh parameters gcrt sets itself, which
+// --crtsh-param can't change
+var reservedParams = map[string]bool{"output": true, "q": true}
+
+// crtshParams parses the key=value --crtsh-param values
+func crtshParams(params []string) (url.Values, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	v := url.Values{}
+	for _, p := range params {
+		i := strings.Index(p, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid --crtsh-param %q, must be key=value", p)
+		}
+		key := p[:i]
+		if reservedParams[key] {
+			return nil, fmt.Errorf("--crtsh-param can't set %s, gcrt sets it itself", key)
+		}
+		v.Add(key, p[i+1:])
+	}
+	return v, nil
+}
+
+// dedupeKeys are the --dedupe-key values
+var dedupeKeys = map[string]client.DedupeKey{
+	"name-date": client.DedupeNameDate,
+	"serial":    client.DedupeSerial,
+	"id":        client.DedupeID,
+}
+
+// dedupe is how the entries crt.sh returns for the same cert are merged.
+// Keeping precertificates means only merging entries with the same id
+func (o options) dedupe() (client.DedupeKey, error) {
+	key, ok := dedupeKeys[o.dedupeKey]
+	if !ok {
+		return key, fmt.Errorf("invalid --dedupe-key %q, must be name-date, serial or id", o.dedupeKey)
+	}
+	precerts := o.includePrecerts || o.precertsOnly
+	switch {
+	case o.noDedupe && o.dedupeKey != "name-date":
+		return key, fmt.Errorf("--no-dedupe and --dedupe-key can't be used together")
+	case o.noDedupe:
+		return client.DedupeNone, nil
+	case precerts && key != client.DedupeNameDate && key != client.DedupeID:
+		return key, fmt.Errorf("--dedupe-key %s would merge precertificates with their leaf certificates, use id with --include-precerts and --precerts-only", o.dedupeKey)
+	case precerts:
+		return client.DedupeID, nil
+	}
+	return key, nil
+}
+
+// permutations reads --permutations-file
+func (o options) permutations() ([]permutation, error) {
+	f, err := os.Open(o.permutationsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	perms, err := parsePermutations(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(perms) == 0 {
+		return nil, fmt.Errorf("no permutations found in %s", o.permutationsFile)
+	}
+	return perms, nil
+}
+
+// newClient creates the crt.sh client for a run. The returned function
+// releases anything the client holds open
+func (o options) newClient() (*client.Client, func(), error) {
+	var clientOpts []client.Option
+	closer := func() {}
+
+	if len(o.trace) > 0 {
+		f, err := os.Create(o.trace)
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating trace file: %s", err)
+		}
+		closer = func() { f.Close() }
+		clientOpts = append(clientOpts, client.WithTrace(f))
+	}
+
+	if o.timeout <= 0 || o.retries < 0 || o.retryWaitMax <= 0 || o.rateLimit < 0 {
+		closer()
+		return nil, nil, fmt.Errorf("--timeout and --retry-wait-max must be positive, and --retries and --rate-limit can't be negative")
+	}
+	clientOpts = append(clientOpts,
+		client.WithTimeout(o.timeout),
+		client.WithRetries(o.retries),
+		client.WithRetryWaitMax(o.retryWaitMax),
+	)
+	if o.rateLimit > 0 {
+		clientOpts = append(clientOpts, client.WithRateLimit(o.rateLimit))
+	}
+
+	if o.retryBudget >= 0 {
+		clientOpts = append(clientOpts, client.WithRetryBudget(o.retryBudget))
+	}
+
+	transportOpts, err := o.transport()
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
+	clientOpts = append(clientOpts, transportOpts...)
+
+	if len(o.otelURL) > 0 {
+		clientOpts = append(clientOpts, client.WithTransport(tracing.Transport))
+	}
+	if o.metrics != nil {
+		clientOpts = append(clientOpts, client.WithMetrics(o.metrics.clientMetrics()))
+	}
+	if o.progress != nil {
+		clientOpts = append(clientOpts, client.WithReadProgress(func(q client.Query, entries, pages int) {
+			o.progress.reading(q.Domain, entries, pages)
+		}))
+	}
+	if o.verbosity > 1 {
+		clientOpts = append(clientOpts, client.WithRequestLog(func(method, u string, attempt int) {
+			if attempt > 0 {
+				log.Debugf("%s %s, retry %d", method, u, attempt)
+				return
+			}
+			log.Debugf("%s %s", method, u)
+		}))
+	}
+	if !o.strict {
+		clientOpts = append(clientOpts, client.WithFallback(func(q client.Query, params string) {
+			if strings.Contains(params, "exclude=expired") {
+				log.Warnf("crt.sh returned an error page for %s, retrying without expired certs", q.Domain)
+				return
+			}
+			log.Warnf("crt.sh returned an error page for %s, retrying with its deduplication", q.Domain)
+		}))
+	}
+
+	return client.New(clientOpts...), closer, nil
+}
+
+// sharedClient is the client run searches with, the server's when gcrt
+// serve gives every search the same one
+func (o options) sharedClient() (*client.Client, func(), error) {
+	if o.client != nil {
+		return o.client, func() {}, nil
+	}
+	return o.newClient()
+}
+
+// transport is how the client connects to crt.sh: --proxy, --insecure,
+// --cacert and --user-agent
+func (o options) transport() ([]client.Option, error) {
+	opts := []client.Option{client.WithUserAgent(o.userAgent)}
+
+	if len(o.proxy) > 0 {
+		u, err := url.Parse(o.proxy)
+		if err != nil || len(u.Host) == 0 {
+			return nil, fmt.Errorf("invalid --proxy %q, e.g. http://proxy:3128 or socks5://127.0.0.1:1080", o.proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid --proxy %q, must be an http, https or socks5 URL", o.proxy)
+		}
+		opts = append(opts, client.WithProxy(u))
+	}
+
+	config, err := o.tlsConfig()
+	if err != nil || config == nil {
+		return opts, err
+	}
+	return append(opts, client.WithTLSConfig(config)), nil
+}
+
+// tlsConfig is the TLS configuration given by --insecure and --cacert, or
+// nil for the defaults
+func (o options) tlsConfig() (*tls.Config, error) {
+	if !o.insecure && len(o.caCert) == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if o.insecure {
+		log.Warn("--insecure is set, crt.sh's certificate isn't being checked")
+		config.InsecureSkipVerify = true
+	}
+	if len(o.caCert) > 0 {
+		data, err := ioutil.ReadFile(o.caCert)
+		if err != nil {
+			return nil, fmt.Errorf("reading --cacert: %s", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates in --cacert %s", o.caCert)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// openOutput opens where results are written: stdout, or --out-file. The
+// file is replaced unless appending is requested or a rotation option is
+// given, in which case it's appended to and rotated. Output to a file is
+// written in one piece when it's closed so that a single run's results
+// never span a rotation
+func (o options) openOutput(appending bool) (io.WriteCloser, error) {
+	if len(o.outFile) == 0 {
+		return nopCloser{os.Stdout}, nil
+	}
+	// a database --out-file is written by exportSQLite
+	if len(o.sqlite) > 0 && o.sqlite == o.outFile {
+		return nopCloser{ioutil.Discard}, nil
+	}
+
+	if !appending && len(o.rotateSize) == 0 && len(o.rotateAge) == 0 && o.rotateKeep == 0 && !o.rotateCompress {
+		return os.Create(o.outFile)
+	}
+
+	if o.output == "xlsx" {
+		return nil, fmt.Errorf("xlsx output can't be appended to, so can't be used with watch or the rotation options")
+	}
+
+	var maxSize int64
+	var maxAge time.Duration
+	var err error
+	if len(o.rotateSize) > 0 {
+		if maxSize, err = parseSize(o.rotateSize); err != nil {
+			return nil, fmt.Errorf("parsing --rotate-max-size: %s", err)
+		}
+	}
+	if len(o.rotateAge) > 0 {
+		if maxAge, err = parseDuration(o.rotateAge); err != nil {
+			return nil, fmt.Errorf("parsing --rotate-max-age: %s", err)
+		}
+	}
+
+	f, err := openRotatingFile(o.outFile, maxSize, maxAge, o.rotateKeep, o.rotateCompress)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedOutput{w: f}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// bufferedOutput collects everything written to it and passes it on in a
+// single write when closed
+type bufferedOutput struct {
+	bytes.Buffer
+	w io.WriteCloser
+}
+
+func (b *bufferedOutput) Close() error {
+	if b.Len() > 0 {
+		if _, err := b.w.Write(b.Bytes()); err != nil {
+			b.w.Close()
+			return err
+		}
+	}
+	return b.w.Close()
+}
+
+// parseDuration is time.ParseDuration that also accepts whole days and
+// weeks, e.g. 30d or 2w
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// parseDateRange parses a start:end range of dates, either of which may be
+// left out for an open-ended range. Dates are YYYY-MM-DD, which cover the
+// whole day in UTC, or RFC 3339 timestamps
+func parseDateRange(flag, s string) (since, until time.Time, err error) {
+	// RFC 3339 timestamps have colons of their own, so the range is split
+	// at the colon that leaves a valid date on either side
+	for i := 0; i < len(s); i++ {
+		if s[i] != ':' {
+			continue
+		}
+		start, end := s[:i], s[i+1:]
+		if len(start) == 0 && len(end) == 0 {
+			break
+		}
+		var startErr, endErr error
+		if len(start) > 0 {
+			since, startErr = parseDate(start, false)
+		}
+		if len(end) > 0 {
+			until, endErr = parseDate(end, true)
+		}
+		if startErr != nil || endErr != nil {
+			continue
+		}
+		if !until.IsZero() && until.Before(since) {
+			return since, until, fmt.Errorf("invalid %s %q, it ends before it starts", flag, s)
+		}
+		return since, until, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid %s %q, must be start:end with YYYY-MM-DD or RFC 3339 dates, either of which may be left out", flag, s)
+}
+
+// parseDate parses a YYYY-MM-DD date as the start of the day in UTC, or
+// the end of it when it ends a range, or an RFC 3339 timestamp
+func parseDate(s string, end bool) (time.Time, error) {
+	if d, err := time.Parse("2006-01-02", s); err == nil {
+		if end {
+			d = d.Add(24*time.Hour - time.Nanosecond)
+		}
+		return d, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// outputFormats are the values accepted by --output. Formats that support
+// --fields only write those columns when they're given
+var outputFormats = map[string]func(w io.Writer, records []record, fields []string) error{
+	"json":     writeJSON,
+	"ndjson":   writeNDJSON,
+	"csv":      writeDelimited(','),
+	"tsv":      writeDelimited('\t'),
+	"xlsx":     writeXLSX,
+	"table":    writeTable,
+	"markdown": writeMarkdown,
+	"stix":     writeSTIX,
+	"misp":     writeMISP,
+}
+
+// formatSQLite is what formatForFile infers for a SQLite database, which
+// is written by exportSQLite rather than as an output format
+const formatSQLite = "sqlite"
+
+// formatForFile infers the output format from a file's extension, returning
+// an empty string for extensions that don't name a format
+func formatForFile(path string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch ext {
+	case "jsonl":
+		return "ndjson"
+	case "md":
+		return "markdown"
+	case "db", "sqlite", "sqlite3":
+		return formatSQLite
+	}
+	if _, ok := outputFormats[ext]; ok {
+		return ext
+	}
+	return ""
+}
+
+func outputFormatNames() string {
+	names := make([]string, 0, len(outputFormats))
+	for n := range outputFormats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// writeJSON writes records as a JSON array, which is empty rather than
+// missing when there are none
+func writeJSON(w io.Writer, records []record, fields []string) error {
+	output, err := json.MarshalIndent(jsonRecords(records, fields), "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(output))
+	return nil
+}
+
+// writeNDJSON writes one JSON record per line, which can be appended to
+func writeNDJSON(w io.Writer, records []record, fields []string) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		var v interface{} = r
+		if len(fields) > 0 {
+			v = projection{r, fields}
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonRecords is what the JSON formats marshal for records: the records
+// themselves, or only their --fields
+func jsonRecords(records []record, fields []string) interface{} {
+	if len(fields) == 0 {
+		if records == nil {
+			return []record{}
+		}
+		return records
+	}
+	projected := make([]projection, len(records))
+	for i, r := range records {
+		projected[i] = projection{r, fields}
+	}
+	return projected
+}
+
+// projection is a record limited to some of its fields, in the order they
+// were given. Fields the record doesn't have are null
+type projection struct {
+	r      record
+	fields []string
+}
+
+func (p projection) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(p.r)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range p.fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f)
+		b.Write(key)
+		b.WriteByte(':')
+
+		value, ok := all[f]
+		if !ok {
+			if value, err = computedJSON(p.r, f); err != nil {
+				return nil, err
+			}
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// computedJSON is the JSON value of a field that isn't part of a record's
+// JSON, or null
+func computedJSON(r record, field string) (json.RawMessage, error) {
+	switch field {
+	case "addresses":
+		if addrs := recordAddresses(r); len(addrs) > 0 {
+			return json.Marshal(addrs)
+		}
+	case "validity_days":
+		if v, ok := fieldValue(r, field); ok {
+			return json.RawMessage(v), nil
+		}
+	case "public_key":
+		if v, _ := fieldValue(r, field); len(v) > 0 {
+			return json.Marshal(v)
+		}
+	}
+	return json.RawMessage("null"), nil
+}
+
+// tabularColumns are the columns written for records: the --fields, or
+// every crt.sh field plus the enrichment fields that at least one record has
+func tabularColumns(records []record, fields []string) []string {
+	if len(fields) > 0 {
+		return fields
+	}
+	return withExtraColumns(certColumns, records)
+}
+
+// withExtraColumns appends the enrichment fields that at least one record
+// has to columns
+func withExtraColumns(columns []string, records []record) []string {
+	columns = append([]string{}, columns...)
+	for _, col := range extraColumns {
+		for _, r := range records {
+			if v, _ := fieldValue(r, col); len(v) > 0 {
+				columns = append(columns, col)
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// writeDelimited writes records as CSV separated by comma, with a header
+func writeDelimited(comma rune) func(w io.Writer, records []record, fields []string) error {
+	return func(w io.Writer, records []record, fields []string) error {
+		columns := tabularColumns(records, fields)
+		rows := make([][]string, len(records))
+		for i, r := range records {
+			rows[i] = make([]string, len(columns))
+			for j, col := range columns {
+				rows[i][j], _ = fieldValue(r, col)
+			}
+		}
+		return writeRows(w, comma, columns, rows)
+	}
+}
+
+func writeRows(w io.Writer, comma rune, header []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// summaryFormats are the formats --aggregate and --group-by-registrable
+// can be written in
+var summaryFormats = []string{"json", "ndjson", "csv", "tsv", "table", "markdown"}
+
+// checkSummaryFormat rejects an --output --aggregate or
+// --group-by-registrable can't be written in, before searching
+func (o options) checkSummaryFormat() error {
+	if o.count || !o.aggregate && !o.groupRegistrable {
+		return nil
+	}
+	for _, f := range summaryFormats {
+		if f == o.output {
+			return nil
+		}
+	}
+	flag := "--aggregate"
+	if o.groupRegistrable {
+		flag = "--group-by-registrable"
+	}
+	return unsupportedSummaryFormat(o.output, flag)
+}
+
+func unsupportedSummaryFormat(format, flag string) error {
+	return fmt.Errorf("-o %s is unsupported with %s, use one of %s", format, flag, strings.Join(summaryFormats, ", "))
+}
+
+// writeSummaryJSON writes v as indented JSON
+func writeSummaryJSON(w io.Writer, v interface{}) error {
+	output, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(output))
+	return nil
+}
+
+// writeRegistrableGroups writes the groups as JSON, or as one row per name
+// for the tabular formats
+func writeRegistrableGroups(w io.Writer, format string, groups []registrableGroup) error {
+	switch format {
+	case "csv", "tsv", "table", "markdown":
+		var rows [][]string
+		for _, g := range groups {
+			for _, n := range g.Names {
+				rows = append(rows, []string{g.RegistrableDomain, n})
+			}
+		}
+		return writeSummaryRows(w, format, []string{"registrable_domain", "name"}, rows)
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, g := range groups {
+			if err := enc.Encode(g); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		if groups == nil {
+			groups = []registrableGroup{}
+		}
+		return writeSummaryJSON(w, groups)
+	}
+	return unsupportedSummaryFormat(format, "--group-by-registrable")
+}
+
+func delimiter(format string) rune {
+	if format == "tsv" {
+		return '\t'
+	}
+	return ','
+}
+
+// writeAggregates writes name aggregates as JSON, or one row per name for
+// the tabular formats
+func writeAggregates(w io.Writer, format string, names []NameAggregate) error {
+	switch format {
+	case "csv", "tsv", "table", "markdown":
+		rows := make([][]string, len(names))
+		for i, n := range names {
+			rows[i] = []string{n.Name, n.RegistrableDomain, n.FirstSeen, n.LastSeen, strconv.Itoa(n.CertCount)}
+		}
+		return writeSummaryRows(w, format, []string{"name", "registrable_domain", "first_seen", "last_seen", "cert_count"}, rows)
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, n := range names {
+			if err := enc.Encode(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		if names == nil {
+			names = []NameAggregate{}
+		}
+		return writeSummaryJSON(w, names)
+	}
+	return unsupportedSummaryFormat(format, "--aggregate")
+}
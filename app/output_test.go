@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+func TestWriteJSONEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[]\n" {
+		t.Errorf("writeJSON of no records = %q, want []", got)
+	}
+}
+
+func TestEmptyOutputContract(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		count  bool
+		want   string
+	}{
+		{"json", "json", false, "[]\n"},
+		{"csv", "csv", false, strings.Join(certColumns, ",") + "\n"},
+		{"tsv", "tsv", false, strings.Join(certColumns, "\t") + "\n"},
+		{"table", "table", false, "ID  NOT_BEFORE  NOT_AFTER  COMMON_NAME  NAME_VALUE  ISSUER_NAME\n"},
+		{"count", "json", true, "Number of certs found: 0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := fakeCrtsh(t, []client.CertResponse{})
+			o := opts
+			o.client = c
+			o.domains = []string{"%.example.com"}
+			o.output = tt.output
+			o.count = tt.count
+
+			var buf bytes.Buffer
+			err := run(context.Background(), o, &buf)
+			if got := exitStatus(err); got != exitNoResults {
+				t.Errorf("exit status %d (%v), want %d", got, err, exitNoResults)
+			}
+			if got := buf.String(); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("output %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultsExitStatus(t *testing.T) {
+	c, _ := fakeCrtsh(t, []client.CertResponse{testCert(1, "www.example.com")})
+	o := opts
+	o.client = c
+	o.domains = []string{"%.example.com"}
+
+	var buf bytes.Buffer
+	err := run(context.Background(), o, &buf)
+	if got := exitStatus(err); got != exitResults {
+		t.Errorf("exit status %d (%v), want %d", got, err, exitResults)
+	}
+	if !strings.Contains(buf.String(), "www.example.com") {
+		t.Errorf("output %q is missing the cert", buf.String())
+	}
+}
+
+func TestNotJSONExitStatus(t *testing.T) {
+	o := opts
+	o.client = fakeCrtshPage(t, "text/html", "<html><body>Sorry, something went wrong</body></html>")
+	o.domains = []string{"%.example.com"}
+
+	var buf bytes.Buffer
+	err := run(context.Background(), o, &buf)
+	var notJSON *client.NotJSONError
+	if !errors.As(err, &notJSON) {
+		t.Errorf("got error %v, want a NotJSONError", err)
+	}
+	if got := exitStatus(err); got != exitQueryError {
+		t.Errorf("exit status %d, want %d", got, exitQueryError)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("a failed query wrote %q", buf.String())
+	}
+}
+
+func TestFormatForFile(t *testing.T) {
+	for path, want := range map[string]string{
+		"results.json":    "json",
+		"results.jsonl":   "ndjson",
+		"results.md":      "markdown",
+		"results.CSV":     "csv",
+		"results.db":      formatSQLite,
+		"results.sqlite":  formatSQLite,
+		"results.sqlite3": formatSQLite,
+		"results.txt":     "",
+	} {
+		if got := formatForFile(path); got != want {
+			t.Errorf("formatForFile(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestSummaryOutputFormats(t *testing.T) {
+	c, _ := fakeCrtsh(t, []client.CertResponse{testCert(1, "www.example.com"), testCert(2, "mail.example.com")})
+	for _, flag := range []string{"--aggregate", "--group-by-registrable"} {
+		for format, want := range map[string]string{
+			"json":     "[\n    {\n",
+			"ndjson":   `{"`,
+			"csv":      "name",
+			"table":    "NAME",
+			"markdown": "| name",
+		} {
+			o := opts
+			o.client, o.output = c, format
+			o.domains = []string{"%.example.com"}
+			if flag == "--aggregate" {
+				o.aggregate = true
+			} else {
+				o.groupRegistrable = true
+				want = strings.Replace(strings.Replace(want, "name", "registrable_domain", 1), "NAME", "REGISTRABLE_DOMAIN", 1)
+			}
+			var buf bytes.Buffer
+			if err := run(context.Background(), o, &buf); err != nil {
+				t.Errorf("%s -o %s: %v", flag, format, err)
+				continue
+			}
+			if !strings.HasPrefix(buf.String(), want) {
+				t.Errorf("%s -o %s wrote %q, want it starting %q", flag, format, buf.String(), want)
+			}
+		}
+
+		for _, format := range []string{"xlsx", "stix", "misp"} {
+			o := opts
+			o.client, o.output = c, format
+			o.domains = []string{"%.example.com"}
+			o.aggregate, o.groupRegistrable = flag == "--aggregate", flag == "--group-by-registrable"
+			var buf bytes.Buffer
+			err := run(context.Background(), o, &buf)
+			if err == nil || !strings.Contains(err.Error(), "unsupported with "+flag) || buf.Len() > 0 {
+				t.Errorf("%s -o %s = %v, %q, want it rejected", flag, format, err, buf.String())
+			}
+		}
+	}
+}
+
+func TestStreamDedupesWithoutIDs(t *testing.T) {
+	// certs from sources other than crt.sh have no id, only a serial
+	a, b := testCert(0, "www.example.com"), testCert(0, "mail.example.com")
+	b.SerialNumber = "02"
+	c, _ := fakeCrtsh(t, []client.CertResponse{a, b})
+	o := opts
+	o.client, o.output = c, "ndjson"
+	o.domains = []string{"example.com", "example.org"}
+	if !o.streams() {
+		t.Fatal("an ndjson search doesn't stream")
+	}
+	var buf bytes.Buffer
+	if err := run(context.Background(), o, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(buf.String(), "\n"); got != 2 {
+		t.Errorf("streamed %d certs, want each of the 2 found by both domains once:\n%s", got, buf.String())
+	}
+}
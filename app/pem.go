@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var fetchCmd = &cobra.Command{
+	Use:   "fetch <id>...",
+	Short: "Download certificates from crt.sh by id and print them as PEM",
+	Long: `fetch downloads the certificates with the given crt.sh ids and prints them
+as PEM, or with --download-certs writes each to <dir>/<id>.pem`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		records := make([]record, len(args))
+		for i, a := range args {
+			id, err := strconv.Atoi(a)
+			if err != nil {
+				return fmt.Errorf("invalid crt.sh id %q", a)
+			}
+			records[i].ID = id
+		}
+
+		out, err := opts.openOutput(false)
+		if err != nil {
+			return err
+		}
+		if err := fetchCerts(context.Background(), opts, records, out); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	},
+}
+
+func init() {
+	cmd.AddCommand(fetchCmd)
+}
+
+func fetchCerts(ctx context.Context, o options, records []record, w io.Writer) error {
+	c, closeClient, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	downloadCerts(ctx, c, records, o.concurrency)
+	for _, r := range records {
+		if r.cert == nil {
+			return fmt.Errorf("couldn't download cert %d", r.ID)
+		}
+	}
+
+	if len(o.downloadDir) > 0 {
+		if err := writePEMFiles(o.downloadDir, records); err != nil {
+			return err
+		}
+		for _, r := range records {
+			fmt.Fprintln(w, r.PEMFile)
+		}
+		return nil
+	}
+
+	for _, r := range records {
+		if err := pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: r.cert.Raw}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePEMFiles writes each downloaded cert to <dir>/<id>.pem, recording
+// the path in the record. Certs that weren't downloaded are skipped
+func writePEMFiles(dir string, records []record) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for i := range records {
+		r := &records[i]
+		if r.cert == nil {
+			continue
+		}
+
+		path := filepath.Join(dir, strconv.Itoa(r.ID)+".pem")
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.cert.Raw})
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("writing cert %d: %s", r.ID, err)
+		}
+		r.PEMFile = path
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+)
+
+// permutation is a candidate lookalike domain from a permutation tool
+type permutation struct {
+	Domain string `json:"permutation"`
+	Fuzzer string `json:"fuzzer,omitempty"`
+}
+
+// permutationGroup is the output of --permutations-file for one candidate
+type permutationGroup struct {
+	permutation
+	CertCount int      `json:"cert_count"`
+	Certs     []record `json:"certs"`
+}
+
+// domainColumns are the CSV and JSON keys that hold the candidate domain in
+// dnstwist and urlcrazy output
+var domainColumns = []string{"domain", "domain-name", "domain_name", "typo"}
+
+// fuzzerColumns hold the permutation technique
+var fuzzerColumns = []string{"fuzzer", "typo type", "typo_type"}
+
+// parsePermutations reads dnstwist JSON or CSV, urlcrazy CSV or a plain list
+// of domains. dnstwist's entry for the original domain is skipped
+func parsePermutations(r io.Reader) ([]permutation, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+
+	var perms []permutation
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		perms, err = parsePermutationsJSON(trimmed)
+	case isPermutationCSV(trimmed):
+		perms, err = parsePermutationsCSV(trimmed)
+	default:
+		domains, readErr := readDomains(bytes.NewReader(trimmed))
+		for _, d := range domains {
+			perms = append(perms, permutation{Domain: strings.Fields(d)[0]})
+		}
+		err = readErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kept := perms[:0]
+	seen := make(map[string]struct{})
+	for _, p := range perms {
+		p.Domain = strings.ToLower(strings.TrimSpace(p.Domain))
+		if len(p.Domain) == 0 || strings.Contains(strings.ToLower(p.Fuzzer), "original") {
+			continue
+		}
+		if _, ok := seen[p.Domain]; ok {
+			continue
+		}
+		seen[p.Domain] = struct{}{}
+		kept = append(kept, p)
+	}
+	return kept, nil
+}
+
+func parsePermutationsJSON(data []byte) ([]permutation, error) {
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("parsing permutations: %s", err)
+	}
+
+	perms := make([]permutation, 0, len(rows))
+	for _, row := range rows {
+		lower := make(map[string]string, len(row))
+		for k, v := range row {
+			if s, ok := v.(string); ok {
+				lower[strings.ToLower(k)] = s
+			}
+		}
+		perms = append(perms, permutation{
+			Domain: firstKey(lower, domainColumns),
+			Fuzzer: firstKey(lower, fuzzerColumns),
+		})
+	}
+	return perms, nil
+}
+
+func isPermutationCSV(data []byte) bool {
+	header, err := bufio.NewReader(bytes.NewReader(data)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	fields, err := csv.NewReader(strings.NewReader(header)).Read()
+	if err != nil {
+		return false
+	}
+	for _, f := range fields {
+		for _, col := range domainColumns {
+			if strings.EqualFold(strings.TrimSpace(f), col) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parsePermutationsCSV(data []byte) ([]permutation, error) {
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing permutations: %s", err)
+	}
+
+	header := rows[0]
+	perms := make([]permutation, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		byName := make(map[string]string, len(header))
+		for i, h := range header {
+			if i < len(row) {
+				byName[strings.ToLower(strings.TrimSpace(h))] = row[i]
+			}
+		}
+		perms = append(perms, permutation{
+			Domain: firstKey(byName, domainColumns),
+			Fuzzer: firstKey(byName, fuzzerColumns),
+		})
+	}
+	return perms, nil
+}
+
+func firstKey(m map[string]string, keys []string) string {
+	for _, k := range keys {
+		if v, ok := m[k]; ok && len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+// searchBatches queries domains batchSize at a time, waiting delay between
+// batches so that long permutation lists don't hammer crt.sh
+func searchBatches(ctx context.Context, c *client.Client, q client.Query, domains []string, o options) ([]record, error) {
+	batchSize := o.batchSize
+	if batchSize < 1 {
+		batchSize = len(domains)
+	}
+
+	var records []record
+	var lastErr error
+	failedBatches, batches := 0, 0
+
+	for start := 0; start < len(domains); start += batchSize {
+		if start > 0 && o.batchDelay > 0 {
+			select {
+			case <-time.After(o.batchDelay):
+			case <-ctx.Done():
+				return records, ctx.Err()
+			}
+		}
+
+		end := start + batchSize
+		if end > len(domains) {
+			end = len(domains)
+		}
+		log.Infof("querying permutations %d-%d of %d", start+1, end, len(domains))
+
+		batches++
+		batch, err := searchAll(ctx, c, q, domains[start:end], o, true)
+		if err != nil {
+			failedBatches++
+			lastErr = err
+			continue
+		}
+		records = mergeRecords(records, batch)
+	}
+
+	if failedBatches == batches {
+		return nil, lastErr
+	}
+	return records, nil
+}
+
+// groupByPermutation collects records under the permutation that found them,
+// dropping permutations without any certs
+func groupByPermutation(perms []permutation, records []record) []permutationGroup {
+	byDomain := make(map[string][]record)
+	for _, r := range records {
+		byDomain[r.SourceDomain] = append(byDomain[r.SourceDomain], r)
+	}
+
+	groups := make([]permutationGroup, 0)
+	for _, p := range perms {
+		if certs, ok := byDomain[p.Domain]; ok {
+			groups = append(groups, permutationGroup{permutation: p, CertCount: len(certs), Certs: certs})
+		}
+	}
+	return groups
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/apex/log"
+)
+
+// poisonOID marks a precertificate, which otherwise chains as normal
+var poisonOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+// the entry types of a record
+const (
+	entryCertificate    = "certificate"
+	entryPrecertificate = "precertificate"
+)
+
+// isPrecert reports whether cert is a precertificate, which carries the
+// critical poison extension so it can't be used in place of the leaf
+func isPrecert(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(poisonOID) {
+			return true
+		}
+	}
+	return false
+}
+
+// precertsStage sets whether each record is a precertificate or a leaf
+// certificate, which crt.sh's JSON doesn't say, and with --precerts-only
+// keeps just the precertificates
+func (p *pipeline) precertsStage(ctx context.Context, records []record) ([]record, error) {
+	downloadCerts(ctx, p.c, records, p.concurrency("precerts"))
+
+	kept := records[:0]
+	unknown := 0
+	for _, r := range records {
+		switch {
+		case r.cert == nil:
+			// gcrt stream knows without the cert
+			if len(r.EntryType) == 0 {
+				unknown++
+			}
+		case isPrecert(r.cert):
+			r.EntryType = entryPrecertificate
+		default:
+			r.EntryType = entryCertificate
+		}
+		if !p.o.precertsOnly || r.EntryType == entryPrecertificate {
+			kept = append(kept, r)
+		}
+	}
+	if unknown > 0 && p.o.precertsOnly {
+		log.Warnf("left out %d cert(s) that couldn't be downloaded to check if they're precertificates", unknown)
+	}
+	return kept, nil
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"context"
+)
+
+// ProbeResult is how a name on a cert answered over HTTPS, or HTTP
+type ProbeResult struct {
+	Name       string `json:"name"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Parked     bool   `json:"parked,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// probeStage requests every resolvable name of the records, probing each
+// distinct name once
+func (p *pipeline) probeStage(ctx context.Context, records []record) ([]record, error) {
+	pr := newProber(p.o.probeTimeout)
+
+	var names []string
+	for _, r := range records {
+		names = append(names, resolvableNames(r)...)
+	}
+	results := p.lookupAll(ctx, "probe", names, func(ctx context.Context, name string) interface{} {
+		res := ProbeResult{Name: name}
+		code, body, err := pr.probe(ctx, name)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.HTTPStatus = code
+		res.Parked = parkedPattern.Match(body)
+		return res
+	})
+
+	for i := range records {
+		r := &records[i]
+		r.HTTP = nil
+		for _, n := range resolvableNames(*r) {
+			r.HTTP = append(r.HTTP, results[n].(ProbeResult))
+		}
+	}
+	return records, nil
+}
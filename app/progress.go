@@ -0,0 +1,163 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// progressWidth is how many characters of the progress line are drawn, so
+// it fits an 80 column terminal
+const progressWidth = 79
+
+// progressBar draws the state of a run's searches on stderr: the domains
+// searched so far, and how much of the response for the one last heard from
+// has been read. On a terminal it's redrawn in place, otherwise a line is
+// written every few seconds. A nil progressBar draws nothing
+type progressBar struct {
+	w        io.Writer
+	terminal bool
+	stop     chan struct{}
+
+	mu      sync.Mutex
+	total   int
+	done    int
+	domain  string
+	entries int
+	pages   int
+	drawn   bool
+	changed bool
+}
+
+func newProgressBar(f *os.File) *progressBar {
+	b := &progressBar{w: f, stop: make(chan struct{})}
+	if fi, err := f.Stat(); err == nil {
+		b.terminal = fi.Mode()&os.ModeCharDevice != 0
+	}
+
+	every := 5 * time.Second
+	if b.terminal {
+		every = 200 * time.Millisecond
+	}
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				b.mu.Lock()
+				if b.changed {
+					b.draw()
+				}
+				b.mu.Unlock()
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+	return b
+}
+
+// expect adds n domains to those being searched
+func (b *progressBar) expect(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total += n
+	b.changed = true
+}
+
+// reading notes how much of the response for a domain has been read
+func (b *progressBar) reading(domain string, entries, pages int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.domain, b.entries, b.pages = domain, entries, pages
+	b.changed = true
+}
+
+// searched counts a domain's search as done
+func (b *progressBar) searched(domain string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done++
+	if b.domain == domain {
+		b.domain = ""
+	}
+	b.changed = true
+}
+
+// finish stops drawing and clears the bar
+func (b *progressBar) finish() {
+	if b == nil {
+		return
+	}
+	close(b.stop)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+}
+
+// line is the progress as a line of text
+func (b *progressBar) line() string {
+	const barWidth = 20
+	filled := 0
+	if b.total > 0 {
+		filled = b.done * barWidth / b.total
+	}
+	line := fmt.Sprintf("[%s%s] %d/%d domains", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), b.done, b.total)
+	if len(b.domain) > 0 {
+		line += fmt.Sprintf(" | %s: %d entries, page %d", b.domain, b.entries, b.pages)
+	}
+	if len(line) > progressWidth {
+		line = line[:progressWidth]
+	}
+	return line
+}
+
+// draw writes the progress, over the last line drawn on a terminal. The
+// caller holds mu
+func (b *progressBar) draw() {
+	b.changed = false
+	if b.total == 0 {
+		return
+	}
+	if b.terminal {
+		fmt.Fprint(b.w, "\r\033[K"+b.line())
+		b.drawn = true
+		return
+	}
+	fmt.Fprintln(b.w, b.line())
+}
+
+// clear removes the bar from the terminal. The caller holds mu
+func (b *progressBar) clear() {
+	if b.drawn {
+		fmt.Fprint(b.w, "\r\033[K")
+		b.drawn = false
+	}
+}
+
+// handler wraps a log handler so log messages aren't drawn over by the bar,
+// clearing it before each message and drawing it again afterwards
+func (b *progressBar) handler(next log.Handler) log.Handler {
+	return log.HandlerFunc(func(e *log.Entry) error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.clear()
+		b.changed = true
+		return next.HandleLog(e)
+	})
+}
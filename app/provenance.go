@@ -0,0 +1,377 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jhinds/gcrt/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// manifestVersion is the version of the manifest format
+const manifestVersion = "1"
+
+// manifest records how an output file was produced along with its digest,
+// so later changes to it can be detected
+type manifest struct {
+	ManifestVersion string            `json:"manifest_version"`
+	Tool            string            `json:"tool"`
+	Version         string            `json:"version"`
+	GoVersion       string            `json:"go_version"`
+	Host            string            `json:"host,omitempty"`
+	Command         []string          `json:"command"`
+	Flags           map[string]string `json:"flags"`
+	StartedAt       time.Time         `json:"started_at"`
+	FinishedAt      time.Time         `json:"finished_at"`
+	Status          string            `json:"status"`
+	File            manifestFile      `json:"file"`
+	KeyID           string            `json:"key_id,omitempty"`
+}
+
+type manifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func manifestPath(outFile string) string { return outFile + ".manifest.json" }
+
+func signaturePath(manifest string) string { return manifest + ".sig" }
+
+// provenance collects the metadata of a run for its manifest
+type provenance struct {
+	m   manifest
+	out string
+	key ed25519.PrivateKey
+}
+
+// startProvenance checks the run can have a manifest written for it and
+// notes the settings it was started with, or returns nil when --manifest
+// and --sign-key aren't set
+func (o options) startProvenance(c *cobra.Command) (*provenance, error) {
+	if !o.manifest && len(o.signKey) == 0 {
+		return nil, nil
+	}
+	if len(o.outFile) == 0 {
+		return nil, errors.New("--manifest and --sign-key need --out-file")
+	}
+	if len(o.rotateSize) > 0 || len(o.rotateAge) > 0 || o.rotateKeep > 0 || o.rotateCompress {
+		return nil, errors.New("--manifest and --sign-key can't be used with the rotation options, as the file keeps changing")
+	}
+
+	p := &provenance{
+		out: o.outFile,
+		m: manifest{
+			ManifestVersion: manifestVersion,
+			Tool:            "gcrt",
+			Version:         buildVersion(),
+			GoVersion:       runtime.Version(),
+			Command:         redactArgs(os.Args),
+			Flags:           make(map[string]string),
+			StartedAt:       time.Now().UTC(),
+		},
+	}
+	p.m.Host, _ = os.Hostname()
+
+	// every setting that isn't the default, whether it came from the command
+	// line, the environment or the config file
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if v := f.Value.String(); v != f.DefValue {
+			p.m.Flags[f.Name] = redactFlag(f.Name, v)
+		}
+	})
+
+	if len(o.signKey) > 0 {
+		key, err := loadPrivateKey(o.signKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading --sign-key: %s", err)
+		}
+		p.key = key
+		p.m.KeyID = keyID(key.Public().(ed25519.PublicKey))
+	}
+	return p, nil
+}
+
+// secretFlags are the flags whose whole value is a credential, such as a
+// webhook URL with its token in the path
+var secretFlags = map[string]bool{"slack-webhook": true, "webhook-url": true}
+
+// redactFlag masks a flag's value for the manifest, which is shared along
+// with the output: secret flags entirely, and the credentials of URLs
+func redactFlag(name, value string) string {
+	if secretFlags[name] {
+		return "[redacted]"
+	}
+	return client.SanitizeURL(value)
+}
+
+// redactArgs is the command line with the flags' values masked by
+// redactFlag, whether given as --flag=value or --flag value
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	flag := ""
+	for i, a := range args {
+		switch {
+		case len(flag) > 0:
+			redacted[i] = redactFlag(flag, a)
+			flag = ""
+		case strings.HasPrefix(a, "--") && strings.Contains(a, "="):
+			parts := strings.SplitN(a, "=", 2)
+			redacted[i] = parts[0] + "=" + redactFlag(strings.TrimPrefix(parts[0], "--"), parts[1])
+		case strings.HasPrefix(a, "--") && secretFlags[strings.TrimPrefix(a, "--")]:
+			redacted[i], flag = a, strings.TrimPrefix(a, "--")
+		default:
+			redacted[i] = client.SanitizeURL(a)
+		}
+	}
+	return redacted
+}
+
+// manifestStatus is the status of a run that wrote output, or false for
+// one that failed outright
+func manifestStatus(runErr error) (string, bool) {
+	var (
+		timedOut    maxDurationError
+		interrupted interruptedError
+		partial     partialError
+		noResults   noResultsError
+		expiring    expiryFailure
+	)
+	switch {
+	case runErr == nil, errors.As(runErr, &noResults), errors.As(runErr, &expiring):
+		return "complete", true
+	case errors.As(runErr, &timedOut):
+		return "timeout", true
+	case errors.As(runErr, &interrupted):
+		return "interrupted", true
+	case errors.As(runErr, &partial):
+		return "partial", true
+	}
+	return "", false
+}
+
+// finish writes the manifest for the output file, and its signature with
+// --sign-key. Runs that failed outright don't get one, empty and partial
+// results do
+func (p *provenance) finish(runErr error) error {
+	p.m.FinishedAt = time.Now().UTC()
+	status, ok := manifestStatus(runErr)
+	if !ok {
+		return nil
+	}
+	p.m.Status = status
+
+	file, err := digestFile(p.out)
+	if err != nil {
+		return err
+	}
+	p.m.File = file
+
+	data, err := json.MarshalIndent(p.m, "", "    ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	path := manifestPath(p.out)
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("writing manifest: %s", err)
+	}
+
+	if p.key == nil {
+		return nil
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(p.key, data)) + "\n"
+	if err := writeFileAtomic(signaturePath(path), []byte(sig)); err != nil {
+		return fmt.Errorf("writing signature: %s", err)
+	}
+	return nil
+}
+
+func digestFile(path string) (manifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return manifestFile{}, err
+	}
+	return manifestFile{Name: filepath.Base(path), Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// buildVersion is the version of gcrt from its build info
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && len(info.Main.Version) > 0 {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// keyID identifies a public key by the start of its SHA-256
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("not a PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 key")
+	}
+	return priv, nil
+}
+
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("not a PEM public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 key")
+	}
+	return pub, nil
+}
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen <name>",
+	Short: "Create an Ed25519 key pair for --sign-key",
+	Long: `keygen writes a new private key to <name>.key, for --sign-key, and its
+public key to <name>.pub, for gcrt verify --public-key`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return err
+		}
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return err
+		}
+		pubDER, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return err
+		}
+
+		privPath, pubPath := args[0]+".key", args[0]+".pub"
+		for _, p := range []string{privPath, pubPath} {
+			if _, err := os.Stat(p); err == nil {
+				return fmt.Errorf("%s already exists", p)
+			}
+		}
+		if err := ioutil.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "wrote %s and %s, key id %s\n", privPath, pubPath, keyID(pub))
+		return nil
+	},
+}
+
+var verifyOpts struct {
+	publicKey string
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <file>",
+	Short: "Check an output file against its manifest and signature",
+	Long: `verify recomputes the digest of a file written with --manifest or --sign-key
+and compares it with <file>.manifest.json. With --public-key the manifest's
+signature must also be valid for that key`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := manifestPath(args[0])
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading manifest: %s", err)
+		}
+		var m manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("parsing manifest %s: %s", path, err)
+		}
+
+		file, err := digestFile(args[0])
+		if err != nil {
+			return err
+		}
+		if file.SHA256 != m.File.SHA256 || file.Size != m.File.Size {
+			return fmt.Errorf("%s has changed since it was written: its SHA-256 is %s, the manifest has %s", args[0], file.SHA256, m.File.SHA256)
+		}
+
+		signed := ""
+		if len(verifyOpts.publicKey) > 0 {
+			pub, err := loadPublicKey(verifyOpts.publicKey)
+			if err != nil {
+				return fmt.Errorf("loading --public-key: %s", err)
+			}
+			sig, err := ioutil.ReadFile(signaturePath(path))
+			if err != nil {
+				return fmt.Errorf("reading signature: %s", err)
+			}
+			raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+			if err != nil || !ed25519.Verify(pub, data, raw) {
+				return fmt.Errorf("the signature of %s isn't valid for key %s", path, keyID(pub))
+			}
+			signed = ", signed by key " + keyID(pub)
+		} else if len(m.KeyID) > 0 {
+			signed = ", its signature by key " + m.KeyID + " wasn't checked without --public-key"
+		}
+
+		var flags []string
+		for name, v := range m.Flags {
+			flags = append(flags, fmt.Sprintf("--%s=%s", name, v))
+		}
+		sort.Strings(flags)
+		fmt.Fprintf(cmd.OutOrStdout(), "%s matches its manifest%s\nwritten by gcrt %s on %s at %s, status %s\nsettings: %s\n",
+			args[0], signed, m.Version, m.Host, m.FinishedAt.Format(time.RFC3339), m.Status, strings.Join(flags, " "))
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyOpts.publicKey, "public-key", "", "Also check the manifest's signature with this public key from gcrt keygen")
+	cmd.AddCommand(keygenCmd, verifyCmd)
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redacted replaces redacted values
+const redacted = "REDACTED"
+
+// redactableFields are the string fields of a record that --redact can mask
+var redactableFields = map[string]func(r *record) *string{
+	"issuer_name":         func(r *record) *string { return &r.IssuerName },
+	"common_name":         func(r *record) *string { return &r.CommonName },
+	"name_value":          func(r *record) *string { return &r.NameValue },
+	"entry_timestamp":     func(r *record) *string { return &r.EntryTimestamp },
+	"not_before":          func(r *record) *string { return &r.NotBefore },
+	"not_after":           func(r *record) *string { return &r.NotAfter },
+	"serial_number":       func(r *record) *string { return &r.SerialNumber },
+	"source_domain":       func(r *record) *string { return &r.SourceDomain },
+	"feed_title":          func(r *record) *string { return &r.FeedTitle },
+	"validation_level":    func(r *record) *string { return &r.ValidationLevel },
+	"key_algorithm":       func(r *record) *string { return &r.KeyAlgorithm },
+	"signature_algorithm": func(r *record) *string { return &r.SignatureAlgorithm },
+	"sha256_fingerprint":  func(r *record) *string { return &r.SHA256Fingerprint },
+	"severity":            func(r *record) *string { return &r.Severity },
+	"pem_file":            func(r *record) *string { return &r.PEMFile },
+}
+
+// redactableLists are the list fields of a record that --redact can mask
+var redactableLists = map[string]func(r *record) *[]string{
+	"sans":          func(r *record) *[]string { return &r.SANs },
+	"ext_key_usage": func(r *record) *[]string { return &r.ExtKeyUsage },
+	"cert_types":    func(r *record) *[]string { return &r.CertTypes },
+	"findings":      func(r *record) *[]string { return &r.Findings },
+}
+
+// redactor masks fields of records before they're output, so results can be
+// shared outside the team that gathered them
+type redactor struct {
+	fields   []string
+	patterns []*regexp.Regexp
+}
+
+func (o options) redactor() (*redactor, error) {
+	rd := &redactor{}
+	for _, f := range o.redact {
+		_, isString := redactableFields[f]
+		_, isList := redactableLists[f]
+		if !isString && !isList {
+			return nil, fmt.Errorf("can't redact field %q, must be one of %s", f, redactableFieldNames())
+		}
+		rd.fields = append(rd.fields, f)
+	}
+
+	for _, p := range o.redactPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --redact-pattern %s: %s", p, err)
+		}
+		rd.patterns = append(rd.patterns, re)
+	}
+	return rd, nil
+}
+
+func redactableFieldNames() string {
+	var names []string
+	for f := range redactableFields {
+		names = append(names, f)
+	}
+	for f := range redactableLists {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// mask replaces whatever the patterns match in s
+func (rd *redactor) mask(s string) string {
+	for _, re := range rd.patterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// apply redacts the records in place: the --redact fields are replaced
+// entirely, then anything matching a --redact-pattern is masked in every
+// string field
+func (rd *redactor) apply(records []record) {
+	for i := range records {
+		r := &records[i]
+
+		for _, f := range rd.fields {
+			if field, ok := redactableFields[f]; ok {
+				if p := field(r); len(*p) > 0 {
+					*p = redacted
+				}
+				continue
+			}
+			if p := redactableLists[f](r); len(*p) > 0 {
+				*p = []string{redacted}
+			}
+		}
+
+		if len(rd.patterns) == 0 {
+			continue
+		}
+		for _, field := range redactableFields {
+			p := field(r)
+			*p = rd.mask(*p)
+		}
+		for _, field := range redactableLists {
+			p := field(r)
+			masked := make([]string, len(*p))
+			for j, v := range *p {
+				masked[j] = rd.mask(v)
+			}
+			if len(masked) > 0 {
+				*p = masked
+			}
+		}
+		if len(r.DNS) > 0 {
+			dns := make([]DNSResult, len(r.DNS))
+			for j, d := range r.DNS {
+				d.Name = rd.mask(d.Name)
+				d.CNAME = rd.mask(d.CNAME)
+				dns[j] = d
+			}
+			r.DNS = dns
+		}
+		if len(r.HTTP) > 0 {
+			probes := make([]ProbeResult, len(r.HTTP))
+			for j, h := range r.HTTP {
+				h.Name = rd.mask(h.Name)
+				h.Error = rd.mask(h.Error)
+				probes[j] = h
+			}
+			r.HTTP = probes
+		}
+		if len(r.Whois) > 0 {
+			whois := make([]WhoisResult, len(r.Whois))
+			for j, wr := range r.Whois {
+				wr.Domain = rd.mask(wr.Domain)
+				whois[j] = wr
+			}
+			r.Whois = whois
+		}
+		if len(r.GeoIP) > 0 {
+			geo := make([]GeoIPResult, len(r.GeoIP))
+			for j, gr := range r.GeoIP {
+				gr.IP = rd.mask(gr.IP)
+				geo[j] = gr
+			}
+			r.GeoIP = geo
+		}
+		if len(r.Lint) > 0 {
+			lint := make([]LintFinding, len(r.Lint))
+			for j, l := range r.Lint {
+				l.Details = rd.mask(l.Details)
+				lint[j] = l
+			}
+			r.Lint = lint
+		}
+	}
+}
+
+// applyNames masks the patterns in name statuses
+func (rd *redactor) applyNames(statuses []NameStatus) {
+	for i := range statuses {
+		s := &statuses[i]
+		s.Name = rd.mask(s.Name)
+		s.RegistrableDomain = rd.mask(s.RegistrableDomain)
+		s.CNAME = rd.mask(s.CNAME)
+		s.Reason = rd.mask(s.Reason)
+	}
+}
+
+// applyDigest masks the patterns in a report, and the --redact fields of
+// its expired certs
+func (rd *redactor) applyDigest(d *digest) {
+	for i, n := range d.NewNames {
+		d.NewNames[i] = rd.mask(n)
+	}
+	for i, n := range d.NewIssuers {
+		d.NewIssuers[i] = rd.mask(n)
+	}
+	rd.apply(d.Expired)
+	for i := range d.StatusChanges {
+		d.StatusChanges[i].Name = rd.mask(d.StatusChanges[i].Name)
+	}
+}
+
+// applyDiff redacts the certs of a diff and masks the patterns in its names
+func (rd *redactor) applyDiff(d *resultsDiff) {
+	rd.apply(d.AddedCertificates)
+	rd.apply(d.RemovedCertificates)
+	for i, n := range d.AddedNames {
+		d.AddedNames[i] = rd.mask(n)
+	}
+	for i, n := range d.RemovedNames {
+		d.RemovedNames[i] = rd.mask(n)
+	}
+}
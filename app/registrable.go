@@ -0,0 +1,97 @@
+package app
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// registrableDomain returns the eTLD+1 of a name using the Public Suffix
+// List, including its private section, so names on shared platforms like
+// github.io are grouped per tenant. Names that are themselves a public
+// suffix are returned unchanged
+func registrableDomain(name string) string {
+	name = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(name), "*."), ".")
+	d, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return name
+	}
+	return d
+}
+
+// registrableGroup is one entry of the --group-by-registrable output
+type registrableGroup struct {
+	RegistrableDomain string   `json:"registrable_domain"`
+	CertCount         int      `json:"cert_count"`
+	Names             []string `json:"names"`
+}
+
+// groupByRegistrable collects the hostnames of records under their
+// registrable domain
+func groupByRegistrable(records []record, keepWildcards bool) []registrableGroup {
+	byDomain := make(map[string]*registrableGroup)
+	var order []string
+
+	group := func(d string) *registrableGroup {
+		g, ok := byDomain[d]
+		if !ok {
+			g = &registrableGroup{RegistrableDomain: d}
+			byDomain[d] = g
+			order = append(order, d)
+		}
+		return g
+	}
+
+	for _, r := range records {
+		counted := make(map[string]bool)
+		for _, n := range hostnames([]record{r}, keepWildcards) {
+			g := group(registrableDomain(n))
+			g.Names = append(g.Names, n)
+			if !counted[g.RegistrableDomain] {
+				counted[g.RegistrableDomain] = true
+				g.CertCount++
+			}
+		}
+	}
+
+	sort.Strings(order)
+	groups := make([]registrableGroup, len(order))
+	for i, d := range order {
+		g := byDomain[d]
+		g.Names = uniqueSorted(g.Names)
+		groups[i] = *g
+	}
+	return groups
+}
+
+// filterRegistrable keeps records with at least one name under one of the
+// given registrable domains
+func filterRegistrable(records []record, domains []string) []record {
+	want := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		want[registrableDomain(d)] = true
+	}
+
+	var kept []record
+	for _, r := range records {
+		for _, n := range r.Names() {
+			if want[registrableDomain(n)] {
+				kept = append(kept, r)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+func uniqueSorted(s []string) []string {
+	sort.Strings(s)
+	out := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
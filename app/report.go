@@ -0,0 +1,229 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var reportOpts struct {
+	history string
+	period  string
+	format  string
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarise what watch --history recorded over a period",
+	Long: `report reads the history kept by watch --history and writes a digest of the
+period: the names and issuers seen for the first time, the certificates that
+expired, and the names that started or stopped resolving. The text format
+suits email and the slack format a Slack message`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		period, err := parseDuration(reportOpts.period)
+		if err != nil || period <= 0 {
+			return fmt.Errorf("invalid --period %q, e.g. 7d or 24h", reportOpts.period)
+		}
+		write, ok := digestFormats[reportOpts.format]
+		if !ok {
+			return fmt.Errorf("invalid --format %q, must be text, markdown, slack or json", reportOpts.format)
+		}
+		rd, err := opts.redactor()
+		if err != nil {
+			return err
+		}
+		h, err := loadHistory(reportOpts.history)
+		if err != nil {
+			return err
+		}
+
+		d := h.digest(time.Now().UTC(), period)
+		d.Period = reportOpts.period
+		rd.applyDigest(&d)
+
+		out, err := opts.openOutput(false)
+		if err != nil {
+			return err
+		}
+		if err := write(out, d); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	},
+}
+
+func init() {
+	reportCmd.Flags().StringVar(&reportOpts.history, "history", "gcrt-history.jsonl", "The history file written by watch --history")
+	reportCmd.Flags().StringVar(&reportOpts.period, "period", "7d", "How far back the report goes, e.g. 7d or 24h")
+	reportCmd.Flags().StringVar(&reportOpts.format, "format", "text", "Report format: text, markdown, slack or json")
+	cmd.AddCommand(reportCmd)
+}
+
+// digest is what changed in the history over a period
+type digest struct {
+	GeneratedAt     time.Time `json:"generated_at"`
+	Period          string    `json:"period"`
+	Since           time.Time `json:"since"`
+	NewCertificates int       `json:"new_certificates"`
+	// names and issuers on certs found in the period that weren't on any
+	// found before it
+	NewNames      []string       `json:"new_names"`
+	NewIssuers    []string       `json:"new_issuers"`
+	Expired       []record       `json:"expired"`
+	StatusChanges []statusChange `json:"status_changes"`
+}
+
+// statusChange is a name that started or stopped resolving
+type statusChange struct {
+	Name string    `json:"name"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// digest summarises the period leading up to now
+func (h *history) digest(now time.Time, period time.Duration) digest {
+	since := now.Add(-period)
+	d := digest{
+		GeneratedAt:   now,
+		Since:         since,
+		NewNames:      []string{},
+		NewIssuers:    []string{},
+		Expired:       []record{},
+		StatusChanges: []statusChange{},
+	}
+
+	oldNames := make(map[string]bool)
+	oldIssuers := make(map[string]bool)
+	newNames := make(map[string]bool)
+	newIssuers := make(map[string]bool)
+	certs := make(map[int]bool)
+	status := make(map[string]string)
+
+	for _, e := range h.events {
+		recent := !e.Time.Before(since) && !e.Time.After(now)
+		switch e.Kind {
+		case eventCert:
+			// a cert can be in the history more than once if a poll's output
+			// failed and it was found again
+			if e.Cert == nil || certs[e.Cert.ID] {
+				continue
+			}
+			certs[e.Cert.ID] = true
+			r := *e.Cert
+
+			if !recent {
+				for _, n := range r.Names() {
+					oldNames[n] = true
+				}
+				oldIssuers[r.IssuerName] = true
+			} else {
+				d.NewCertificates++
+				for _, n := range r.Names() {
+					newNames[n] = true
+				}
+				newIssuers[r.IssuerName] = true
+			}
+
+			if notAfter, err := r.NotAfterTime(); err == nil && !notAfter.Before(since) && notAfter.Before(now) {
+				d.Expired = append(d.Expired, r)
+			}
+		case eventStatus:
+			// the first status of a name is where it started, not a change
+			if prev, ok := status[e.Name]; ok && recent && prev != e.Status {
+				d.StatusChanges = append(d.StatusChanges, statusChange{Name: e.Name, From: prev, To: e.Status, At: e.Time})
+			}
+			status[e.Name] = e.Status
+		}
+	}
+
+	for n := range newNames {
+		if !oldNames[n] {
+			d.NewNames = append(d.NewNames, n)
+		}
+	}
+	for i := range newIssuers {
+		if !oldIssuers[i] && len(i) > 0 {
+			d.NewIssuers = append(d.NewIssuers, i)
+		}
+	}
+	sort.Strings(d.NewNames)
+	sort.Strings(d.NewIssuers)
+	sort.SliceStable(d.Expired, func(i, j int) bool {
+		return d.Expired[i].NotAfter < d.Expired[j].NotAfter
+	})
+	return d
+}
+
+// digestFormats write a digest for each --format
+var digestFormats = map[string]func(w io.Writer, d digest) error{
+	"text": func(w io.Writer, d digest) error {
+		return writeDigestText(w, d, func(s string) string { return s + "\n" + strings.Repeat("-", len(s)) },
+			func(url, text string) string { return text + " (" + url + ")" }, "  ")
+	},
+	"markdown": func(w io.Writer, d digest) error {
+		return writeDigestText(w, d, func(s string) string { return "## " + s },
+			func(url, text string) string { return "[" + text + "](" + url + ")" }, "- ")
+	},
+	"slack": func(w io.Writer, d digest) error {
+		return writeDigestText(w, d, func(s string) string { return "*" + s + "*" },
+			func(url, text string) string { return "<" + url + "|" + text + ">" }, "• ")
+	},
+	"json": func(w io.Writer, d digest) error {
+		data, err := json.MarshalIndent(d, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	},
+}
+
+// writeDigestText writes the digest as sections with a heading and a line
+// per item, capped at notifyLineLimit like alerts are
+func writeDigestText(w io.Writer, d digest, heading func(string) string, link func(url, text string) string, bullet string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", heading(fmt.Sprintf("gcrt report for %s to %s", d.Since.Format("2006-01-02 15:04"), d.GeneratedAt.Format("2006-01-02 15:04 MST"))))
+	fmt.Fprintf(&b, "%d new certificate(s), %d new name(s), %d new issuer(s), %d expired certificate(s), %d DNS change(s)\n",
+		d.NewCertificates, len(d.NewNames), len(d.NewIssuers), len(d.Expired), len(d.StatusChanges))
+
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s\n", heading(title))
+		for i, l := range lines {
+			if i == notifyLineLimit {
+				fmt.Fprintf(&b, "%s... and %d more\n", bullet, len(lines)-notifyLineLimit)
+				break
+			}
+			fmt.Fprintf(&b, "%s%s\n", bullet, l)
+		}
+	}
+
+	section("New names", d.NewNames)
+	section("New issuers", d.NewIssuers)
+
+	var expired []string
+	for _, r := range d.Expired {
+		expired = append(expired, fmt.Sprintf("%s %s issued by %s, expired %s",
+			link(r.Link(), fmt.Sprintf("#%d", r.ID)), strings.Join(r.Names(), ", "), r.IssuerName, r.NotAfter))
+	}
+	section("Expired certificates", expired)
+
+	var changes []string
+	for _, c := range d.StatusChanges {
+		changes = append(changes, fmt.Sprintf("%s went from %s to %s at %s", c.Name, c.From, c.To, c.At.Format("2006-01-02 15:04 MST")))
+	}
+	section("DNS changes", changes)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNSResult is what a name on a cert resolved to
+type DNSResult struct {
+	Name  string   `json:"name"`
+	CNAME string   `json:"cname,omitempty"`
+	A     []string `json:"a,omitempty"`
+	AAAA  []string `json:"aaaa,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// live reports whether the name resolved to an address
+func (d DNSResult) live() bool {
+	return len(d.A) > 0 || len(d.AAAA) > 0
+}
+
+// resolvableNames are the names of a cert that can be looked up: wildcards
+// and names that aren't hostnames are skipped
+func resolvableNames(r record) []string {
+	var names []string
+	for _, n := range r.Names() {
+		if strings.HasPrefix(n, "*.") || strings.ContainsAny(n, "@ ") {
+			continue
+		}
+		names = append(names, n)
+	}
+	return names
+}
+
+// resolveNames looks up every resolvable name of records, resolving each
+// distinct name once
+func (p *pipeline) resolveNames(ctx context.Context, records []record) {
+	var names []string
+	for _, r := range records {
+		names = append(names, resolvableNames(r)...)
+	}
+	results := p.lookupAll(ctx, "resolve", names, func(ctx context.Context, name string) interface{} {
+		return resolve(ctx, name, p.o.probeTimeout)
+	})
+
+	for i := range records {
+		r := &records[i]
+		r.DNS = nil
+		for _, n := range resolvableNames(*r) {
+			r.DNS = append(r.DNS, results[n].(DNSResult))
+		}
+	}
+}
+
+func resolve(ctx context.Context, name string, timeout time.Duration) DNSResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res := DNSResult{Name: name}
+	if cname, err := net.DefaultResolver.LookupCNAME(ctx, name); err == nil {
+		if cname = strings.TrimSuffix(cname, "."); !strings.EqualFold(cname, name) {
+			res.CNAME = cname
+		}
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			res.Error = "not found"
+		} else {
+			res.Error = err.Error()
+		}
+		return res
+	}
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			res.A = append(res.A, a.IP.String())
+		} else {
+			res.AAAA = append(res.AAAA, a.IP.String())
+		}
+	}
+	return res
+}
+
+// resolveRecords resolves the names of records for --resolve, and with
+// --only-live drops the certs with no name that resolves
+func (p *pipeline) resolveRecords(ctx context.Context, records []record) []record {
+	p.resolveNames(ctx, records)
+	if !p.o.onlyLive {
+		return records
+	}
+
+	var kept []record
+	for _, r := range records {
+		for _, d := range r.DNS {
+			if d.live() {
+				kept = append(kept, r)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// liveNames keeps the names that resolved for some record
+func liveNames(names []string, records []record) []string {
+	live := make(map[string]bool)
+	for _, r := range records {
+		for _, d := range r.DNS {
+			if d.live() {
+				live[d.Name] = true
+			}
+		}
+	}
+
+	var kept []string
+	for _, n := range names {
+		if live[n] {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// recordAddresses is every address the names of a record resolved to
+func recordAddresses(r record) []string {
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, d := range r.DNS {
+		for _, a := range append(append([]string{}, d.A...), d.AAAA...) {
+			if !seen[a] {
+				seen[a] = true
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	return addrs
+}
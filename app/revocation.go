@@ -0,0 +1,452 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// the revocation statuses of a cert
+const (
+	revocationGood    = "good"
+	revocationRevoked = "revoked"
+	revocationUnknown = "unknown"
+)
+
+// maxCRLSize is the largest CRL downloaded, some CAs' run to tens of MB
+const maxCRLSize = 64 << 20
+
+// crlReasons are the RFC 5280 names of the revocation reason codes
+var crlReasons = map[int]string{
+	0:  "unspecified",
+	1:  "keyCompromise",
+	2:  "cACompromise",
+	3:  "affiliationChanged",
+	4:  "superseded",
+	5:  "cessationOfOperation",
+	6:  "certificateHold",
+	8:  "removeFromCRL",
+	9:  "privilegeWithdrawn",
+	10: "aACompromise",
+}
+
+func crlReason(code int) string {
+	if name, ok := crlReasons[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason %d", code)
+}
+
+var (
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasic     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidCRLReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+)
+
+// ocspSignatureAlgorithms are the OCSP response signature algorithms that
+// are checked
+var ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+	"1.3.101.112":           x509.PureEd25519,
+}
+
+// the RFC 6960 OCSP request and response structures
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertID
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID           ocspCertID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// revocationCheck is what a responder or CRL says about a cert
+type revocationCheck struct {
+	status    string
+	revokedAt time.Time
+	reason    string
+	err       string
+}
+
+// crlFetch is a downloaded CRL's revoked serials, or why it couldn't be used
+type crlFetch struct {
+	revoked map[string]revocationCheck
+	err     string
+}
+
+// revocationStage asks each cert's OCSP responder whether it has been
+// revoked, falling back to its CRL when there's no responder or it doesn't
+// know. The issuer is needed to ask, and to check the answer is signed by
+// it, so it's downloaded too
+func (p *pipeline) revocationStage(ctx context.Context, records []record) ([]record, error) {
+	downloadCerts(ctx, p.c, records, p.concurrency("revocation"))
+	hc := &http.Client{Timeout: p.o.probeTimeout}
+
+	// the issuers are shared with --fetch-issuers' downloads
+	var urls []string
+	for _, r := range records {
+		if r.cert != nil && len(r.cert.IssuingCertificateURL) > 0 {
+			urls = append(urls, r.cert.IssuingCertificateURL[0])
+		}
+	}
+	issuers := p.lookupAll(ctx, "issuers", urls, func(ctx context.Context, url string) interface{} {
+		cert, err := fetchIssuer(ctx, hc, url)
+		if err != nil {
+			return issuerFetch{err: err.Error()}
+		}
+		return issuerFetch{cert: cert}
+	})
+	issuerOf := func(r record) *x509.Certificate {
+		if len(r.cert.IssuingCertificateURL) == 0 {
+			return nil
+		}
+		f, _ := issuers[r.cert.IssuingCertificateURL[0]].(issuerFetch)
+		return f.cert
+	}
+
+	// each cert is asked about on its own, each CRL only downloaded once
+	type ocspJob struct {
+		url          string
+		cert, issuer *x509.Certificate
+	}
+	ocspJobs := make(map[string]ocspJob)
+	var ocspKeys []string
+	for _, r := range records {
+		if r.cert == nil || len(r.cert.OCSPServer) == 0 {
+			continue
+		}
+		issuer := issuerOf(r)
+		if issuer == nil {
+			continue
+		}
+		key := ocspKey(r.cert)
+		ocspJobs[key] = ocspJob{url: r.cert.OCSPServer[0], cert: r.cert, issuer: issuer}
+		ocspKeys = append(ocspKeys, key)
+	}
+	answers := p.lookupAll(ctx, "revocation", ocspKeys, func(ctx context.Context, key string) interface{} {
+		j := ocspJobs[key]
+		check, err := checkOCSP(ctx, hc, j.url, j.cert, j.issuer)
+		if err != nil {
+			return revocationCheck{status: revocationUnknown, err: fmt.Sprintf("asking %s: %s", j.url, err)}
+		}
+		return check
+	})
+
+	crlIssuers := make(map[string]*x509.Certificate)
+	var crlURLs []string
+	for _, r := range records {
+		if r.cert == nil || len(r.cert.CRLDistributionPoints) == 0 {
+			continue
+		}
+		url := r.cert.CRLDistributionPoints[0]
+		issuer, ok := crlIssuers[url]
+		if !ok {
+			crlURLs = append(crlURLs, "crl "+url)
+		}
+		if issuer == nil {
+			crlIssuers[url] = issuerOf(r)
+		}
+	}
+	// CRLs are much bigger than anything else downloaded, so get longer
+	crlClient := &http.Client{Timeout: p.o.crlTimeout}
+	crls := p.lookupAll(ctx, "revocation", crlURLs, func(ctx context.Context, key string) interface{} {
+		url := key[len("crl "):]
+		// a CRL that can't be checked against its issuer could say anything
+		if crlIssuers[url] == nil {
+			return crlFetch{err: "its issuer couldn't be downloaded to check " + url}
+		}
+		revoked, err := fetchCRL(ctx, crlClient, url, crlIssuers[url], time.Now())
+		if err != nil {
+			return crlFetch{err: fmt.Sprintf("downloading %s: %s", url, err)}
+		}
+		return crlFetch{revoked: revoked}
+	})
+
+	revoked := 0
+	for i := range records {
+		r := &records[i]
+		if r.cert == nil {
+			continue
+		}
+		check := revocationCheck{status: revocationUnknown, err: "the cert has no OCSP responder or CRL"}
+		source := ""
+		if len(r.cert.OCSPServer) > 0 {
+			check, source = revocationCheck{status: revocationUnknown, err: "its issuer couldn't be downloaded"}, "ocsp"
+			key := ocspKey(r.cert)
+			if v, ok := answers[key].(revocationCheck); ok {
+				check = v
+			}
+		}
+		if check.status == revocationUnknown && len(r.cert.CRLDistributionPoints) > 0 {
+			if f, ok := crls["crl "+r.cert.CRLDistributionPoints[0]].(crlFetch); ok {
+				source = "crl"
+				check = revocationCheck{status: revocationGood}
+				if len(f.err) > 0 {
+					check = revocationCheck{status: revocationUnknown, err: f.err}
+				} else if c, ok := f.revoked[r.cert.SerialNumber.Text(16)]; ok {
+					check = c
+				}
+			}
+		}
+
+		r.RevocationStatus = check.status
+		r.RevocationSource = source
+		r.RevocationReason = check.reason
+		r.RevocationError = check.err
+		if !check.revokedAt.IsZero() {
+			r.RevokedAt = check.revokedAt.UTC().Format("2006-01-02T15:04:05")
+		}
+		if check.status == revocationRevoked {
+			revoked++
+		}
+	}
+	if revoked > 0 {
+		log.Warnf("%d cert(s) have been revoked", revoked)
+	}
+	return records, nil
+}
+
+// ocspKey identifies an OCSP check for cert
+func ocspKey(cert *x509.Certificate) string {
+	return fmt.Sprintf("ocsp %s %x %s", cert.OCSPServer[0], cert.AuthorityKeyId, cert.SerialNumber.Text(16))
+}
+
+// checkOCSP asks an OCSP responder about cert, checking the answer is
+// signed by its issuer or a responder the issuer delegated to
+func checkOCSP(ctx context.Context, hc *http.Client, url string, cert, issuer *x509.Certificate) (revocationCheck, error) {
+	id, err := ocspID(cert, issuer)
+	if err != nil {
+		return revocationCheck{}, err
+	}
+	body, err := asn1.Marshal(ocspRequest{TBSRequest: ocspTBSRequest{RequestList: []ocspSingleRequest{{Cert: id}}}})
+	if err != nil {
+		return revocationCheck{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return revocationCheck{}, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("User-Agent", "gcrt")
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return revocationCheck{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return revocationCheck{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return revocationCheck{}, err
+	}
+	return parseOCSP(data, cert, issuer)
+}
+
+// ocspID identifies cert to its issuer's responder, by SHA-1 hashes of the
+// issuer's name and key
+func ocspID(cert, issuer *x509.Certificate) (ocspCertID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return ocspCertID{}, fmt.Errorf("parsing the issuer's key: %s", err)
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  cert.SerialNumber,
+	}, nil
+}
+
+// parseOCSP reads an OCSP response's answer for cert
+func parseOCSP(data []byte, cert, issuer *x509.Certificate) (revocationCheck, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(data, &resp); err != nil {
+		return revocationCheck{}, fmt.Errorf("parsing response: %s", err)
+	}
+	if resp.Status != 0 {
+		return revocationCheck{}, fmt.Errorf("responder returned status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasic) {
+		return revocationCheck{}, errors.New("unsupported response type")
+	}
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return revocationCheck{}, fmt.Errorf("parsing response: %s", err)
+	}
+
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		delegate, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return revocationCheck{}, fmt.Errorf("parsing responder certificate: %s", err)
+		}
+		if !bytes.Equal(delegate.Raw, issuer.Raw) {
+			if err := delegate.CheckSignatureFrom(issuer); err != nil {
+				return revocationCheck{}, errors.New("responder certificate isn't signed by the issuer")
+			}
+			if !containsEKU(delegate.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning) {
+				return revocationCheck{}, errors.New("responder certificate isn't for OCSP signing")
+			}
+			signer = delegate
+		}
+	}
+	algorithm, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return revocationCheck{}, fmt.Errorf("unsupported signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(algorithm, basic.TBSResponseData.Raw, basic.Signature.RightAlign()); err != nil {
+		return revocationCheck{}, fmt.Errorf("bad signature: %s", err)
+	}
+
+	for _, r := range basic.TBSResponseData.Responses {
+		if r.CertID.SerialNumber == nil || r.CertID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		switch {
+		case bool(r.Good):
+			return revocationCheck{status: revocationGood}, nil
+		case !r.Revoked.RevocationTime.IsZero():
+			return revocationCheck{status: revocationRevoked, revokedAt: r.Revoked.RevocationTime, reason: crlReason(int(r.Revoked.Reason))}, nil
+		}
+		return revocationCheck{status: revocationUnknown, err: "the responder doesn't know the cert"}, nil
+	}
+	return revocationCheck{}, errors.New("the response isn't for the cert")
+}
+
+func containsEKU(have []x509.ExtKeyUsage, want x509.ExtKeyUsage) bool {
+	for _, u := range have {
+		if u == want {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchCRL downloads a CRL and returns its revoked serials in hex
+func fetchCRL(ctx context.Context, hc *http.Client, url string, issuer *x509.Certificate, now time.Time) (map[string]revocationCheck, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "gcrt")
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseCRL(data, issuer, now)
+}
+
+// parseCRL reads the revoked serials of a CRL, which must be signed by the
+// issuer and not be past its next update
+func parseCRL(data []byte, issuer *x509.Certificate, now time.Time) (map[string]revocationCheck, error) {
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing CRL: %s", err)
+	}
+	if err := issuer.CheckCRLSignature(crl); err != nil {
+		return nil, fmt.Errorf("CRL isn't signed by the issuer: %s", err)
+	}
+	if next := crl.TBSCertList.NextUpdate; !next.IsZero() && now.After(next) {
+		return nil, fmt.Errorf("CRL is stale, its next update was due %s", next.UTC().Format(time.RFC3339))
+	}
+
+	revoked := make(map[string]revocationCheck, len(crl.TBSCertList.RevokedCertificates))
+	for _, rc := range crl.TBSCertList.RevokedCertificates {
+		check := revocationCheck{status: revocationRevoked, revokedAt: rc.RevocationTime}
+		for _, ext := range rc.Extensions {
+			var reason asn1.Enumerated
+			if ext.Id.Equal(oidCRLReasonCode) {
+				if _, err := asn1.Unmarshal(ext.Value, &reason); err == nil {
+					check.reason = crlReason(int(reason))
+				}
+			}
+		}
+		revoked[rc.SerialNumber.Text(16)] = check
+	}
+	return revoked, nil
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestParseCRL(t *testing.T) {
+	ca, key := testCA(t, "test CA")
+	other, _ := testCA(t, "other CA")
+	now := time.Now()
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Hour),
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(0xabc), RevocationTime: now.Add(-time.Minute)},
+		},
+	}, ca, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := parseCRL(crl, ca, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := revoked["abc"]; !ok || c.status != revocationRevoked {
+		t.Errorf("revoked = %v, want abc revoked", revoked)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		issuer *x509.Certificate
+		now    time.Time
+		want   string
+	}{
+		{"wrong issuer", other, now, "isn't signed by the issuer"},
+		{"stale", ca, now.Add(2 * time.Hour), "CRL is stale"},
+	} {
+		_, err := parseCRL(crl, tc.issuer, tc.now)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
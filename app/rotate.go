@@ -0,0 +1,192 @@
+package app
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// rotatedTimeLayout is appended to the name of a rotated file
+const rotatedTimeLayout = "20060102T150405.000"
+
+// rotatingFile appends to a file, moving it aside once it would grow past
+// maxSize. Rotated files older than maxAge, or beyond the newest keep, are
+// deleted. Zero values disable the corresponding limit
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	keep     int
+	compress bool
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, keep int, compress bool) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		keep:     keep,
+		compress: compress,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating first if p would take it past the
+// size limit. p is never split across files
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	rotated := r.path + "." + time.Now().UTC().Format(rotatedTimeLayout)
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	if r.compress {
+		if err := gzipFile(rotated); err != nil {
+			log.WithError(err).Warnf("error compressing %s", rotated)
+		}
+	}
+	r.prune()
+	return nil
+}
+
+// prune removes rotated files past the age and count limits
+func (r *rotatingFile) prune() {
+	if r.maxAge <= 0 && r.keep <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+	// the timestamp suffix sorts chronologically, newest first after this
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+
+	cutoff := time.Now().Add(-r.maxAge)
+	for i, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, r.path+"."), ".gz")
+		rotatedAt, err := time.Parse(rotatedTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		if (r.keep > 0 && i >= r.keep) || (r.maxAge > 0 && rotatedAt.Before(cutoff)) {
+			if err := os.Remove(m); err != nil {
+				log.WithError(err).Warnf("error removing %s", m)
+			}
+		}
+	}
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// parseSize parses sizes such as 512K, 100MB or 1G into bytes
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GB", 1 << 30}, {"G", 1 << 30},
+		{"MB", 1 << 20}, {"M", 1 << 20},
+		{"KB", 1 << 10}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSuffix(upper, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,424 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+	"github.com/jhinds/gcrt/tracing"
+)
+
+// runTraced is run with OpenTelemetry tracing when it's configured
+func runTraced(ctx context.Context, o options, w io.Writer) error {
+	if len(o.otelURL) == 0 {
+		return run(ctx, o, w)
+	}
+
+	t := tracing.New(o.otelURL, "gcrt")
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Warn("error exporting traces")
+		}
+	}()
+
+	ctx, span := tracing.Start(tracing.WithTracer(ctx, t), "gcrt")
+	defer span.End()
+
+	err := run(ctx, o, w)
+	span.SetError(err)
+	return err
+}
+
+// run searches crt.sh as described by o and writes the results to w
+func run(ctx context.Context, o options, w io.Writer) error {
+	q, err := o.query()
+	if err != nil {
+		return err
+	}
+	rd, err := o.redactor()
+	if err != nil {
+		return err
+	}
+	if err := o.checkSort(); err != nil {
+		return err
+	}
+	if err := o.checkSummaryFormat(); err != nil {
+		return err
+	}
+	if o.tmpl, err = o.parseTemplate(); err != nil {
+		return err
+	}
+	// gcrt bulk passes its own outcome to read the tallies from
+	if o.outcome == nil {
+		o.outcome = &runOutcome{}
+	}
+
+	if o.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.maxDuration)
+		defer cancel()
+	}
+
+	c, closeClient, err := o.sharedClient()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	if o.backoff, err = o.loadBackoff(); err != nil {
+		return err
+	}
+	if o.source, err = o.newSource(c); err != nil {
+		return err
+	}
+	p, err := o.pipeline(c)
+	if err != nil {
+		return err
+	}
+
+	if o.streams() {
+		return o.runStream(ctx, c, q, p, rd, w)
+	}
+
+	var records []record
+	var perms []permutation
+	if len(o.permutationsFile) > 0 || o.squat != nil {
+		if o.squat != nil {
+			perms = o.squat.perms
+		} else if perms, err = o.permutations(); err != nil {
+			return err
+		}
+		domains := make([]string, len(perms))
+		for i, p := range perms {
+			domains[i] = p.Domain
+		}
+		records, err = searchBatches(ctx, c, q, domains, o)
+	} else {
+		var domains []string
+		if domains, err = o.targets(os.Stdin); err != nil {
+			return err
+		}
+		records, err = searchAll(ctx, c, q, domains, o, len(domains) > 1)
+	}
+	if o.backoff != nil {
+		if saveErr := o.backoff.save(o.backoffFile); saveErr != nil {
+			return fmt.Errorf("saving backoff state: %s", saveErr)
+		}
+	}
+	if o.stopped(ctx) {
+		// output whatever was found in time
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, f := range o.merge {
+		prev, loadErr := loadCerts(f)
+		if loadErr != nil {
+			return fmt.Errorf("loading results from %s: %s", f, loadErr)
+		}
+		records = mergeRecords(records, newRecords(q.Filter(prev)))
+	}
+
+	if records, err = o.filter(records); err != nil {
+		return err
+	}
+
+	records, err = p.run(ctx, records)
+	if err != nil {
+		return err
+	}
+
+	o.stopped(ctx)
+
+	if len(o.diffAgainst) > 0 {
+		prev, err := loadCerts(o.diffAgainst)
+		if err != nil {
+			return fmt.Errorf("loading results from %s: %s", o.diffAgainst, err)
+		}
+		// only what the search could have found again counts as removed
+		d := diffRecords(newRecords(q.Filter(prev)), records, o.keepWildcards)
+		rd.applyDiff(&d)
+		if err := o.writeDiff(w, d); err != nil {
+			return err
+		}
+		if err := o.stopErr(); err != nil {
+			return err
+		}
+		o.outcome.add(len(records))
+		return o.outcome.err()
+	}
+
+	// names are only redacted once they've been probed
+	if !o.liveness && !o.stalenessReport {
+		rd.apply(records)
+	}
+
+	if len(o.sqlite) > 0 {
+		// what was found before the run stopped is still saved, so the
+		// export can't be cancelled with it
+		if err := exportSQLite(context.Background(), o.sqlite, records); err != nil {
+			return err
+		}
+	} else if o.expiry != nil {
+		if err := o.writeExpiry(w, records); err != nil {
+			return err
+		}
+	} else if o.squat != nil {
+		if err := o.writeSquat(w, records); err != nil {
+			return err
+		}
+	} else if o.timeline != nil {
+		if err := o.writeTimeline(w, records); err != nil {
+			return err
+		}
+	} else if o.analysis != nil {
+		if err := o.writeAnalysis(w, records); err != nil {
+			return err
+		}
+	} else if o.summary {
+		if err := o.writeSummary(w, records); err != nil {
+			return err
+		}
+	} else if o.liveness || o.stalenessReport {
+		if err := o.writeLiveness(ctx, w, records, rd); err != nil {
+			return err
+		}
+	} else if len(perms) > 0 && o.output == "json" && !o.count && !o.aggregate && !o.namesOnly && !o.groupRegistrable {
+		output, err := json.MarshalIndent(groupByPermutation(perms, records), "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+	} else if err := o.write(w, records); err != nil {
+		return err
+	}
+
+	if err := o.stopErr(); err != nil {
+		return err
+	}
+	o.outcome.add(len(records))
+	return o.outcome.err()
+}
+
+// runStream is run for output that's written as it's found
+func (o options) runStream(ctx context.Context, c *client.Client, q client.Query, p *pipeline, rd *redactor, w io.Writer) error {
+	if err := o.checkFields(); err != nil {
+		return err
+	}
+	domains, err := o.targets(os.Stdin)
+	if err != nil {
+		return err
+	}
+	err = streamAll(ctx, c, q, domains, o, p, rd, w)
+	if o.backoff != nil {
+		if saveErr := o.backoff.save(o.backoffFile); saveErr != nil {
+			return fmt.Errorf("saving backoff state: %s", saveErr)
+		}
+	}
+	if o.stopped(ctx) {
+		return o.stopErr()
+	}
+	if err != nil {
+		return err
+	}
+	return o.outcome.err()
+}
+
+// maxDurationError is returned when a run is stopped by --max-duration,
+// after its partial results have been output
+type maxDurationError struct {
+	limit time.Duration
+}
+
+func (e maxDurationError) Error() string {
+	return fmt.Sprintf("the run took longer than --max-duration of %s, the results are partial", e.limit)
+}
+
+func errMaxDuration(o options) error {
+	return maxDurationError{o.maxDuration}
+}
+
+// stopped reports whether the run was stopped early, by --max-duration
+// or an interrupt, noting which for the output status
+func (o *options) stopped(ctx context.Context) bool {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		o.timedOut = true
+	case context.Canceled:
+		o.interrupted = true
+	}
+	return o.timedOut || o.interrupted
+}
+
+// stopErr is the error of a run stopped early, once its partial results
+// have been output
+func (o options) stopErr() error {
+	switch {
+	case o.timedOut:
+		return errMaxDuration(o)
+	case o.interrupted:
+		return errInterrupted
+	}
+	return nil
+}
+
+// mergeRecords appends the records from b that aren't already in a
+func mergeRecords(a, b []record) []record {
+	seen := make(map[string]struct{}, len(a))
+	for _, r := range a {
+		seen[certKey(r.CertResponse)] = struct{}{}
+	}
+	for _, r := range b {
+		if _, ok := seen[certKey(r.CertResponse)]; !ok {
+			seen[certKey(r.CertResponse)] = struct{}{}
+			a = append(a, r)
+		}
+	}
+	return a
+}
+
+// filter drops records excluded by the filters that only need the crt.sh
+// response, before anything is downloaded for them
+func (o options) filter(records []record) ([]record, error) {
+	if len(o.registrable) > 0 {
+		records = filterRegistrable(records, o.registrable)
+	}
+
+	if o.wildcardsOnly {
+		records = filterWildcards(records)
+	}
+
+	if len(o.matchNames) > 0 || len(o.excludeNames) > 0 {
+		include, exclude, err := o.nameMatchers()
+		if err != nil {
+			return nil, err
+		}
+		records = filterNames(records, include, exclude)
+	}
+
+	if len(o.issuers) > 0 || len(o.excludeIssuers) > 0 || len(o.issuerCAIDs) > 0 {
+		include, err := newIssuerMatchers(o.issuers)
+		if err != nil {
+			return nil, err
+		}
+		exclude, err := newIssuerMatchers(o.excludeIssuers)
+		if err != nil {
+			return nil, err
+		}
+		records = filterIssuers(records, include, exclude, o.issuerCAIDs)
+	}
+
+	vf, err := o.validityFilter()
+	if err != nil {
+		return nil, err
+	}
+	if vf.enabled() {
+		records = vf.apply(records, time.Now().UTC())
+	}
+	return records, nil
+}
+
+// checkFields checks --fields can be output in the --output format
+func (o options) checkFields() error {
+	for _, f := range o.fields {
+		if !knownFields[f] && !jsonOnlyFields[f] {
+			return fmt.Errorf("unknown field %q in --fields, must be one of %s", f, knownFieldNames())
+		}
+		if jsonOnlyFields[f] && o.output != "json" && o.output != "ndjson" {
+			return fmt.Errorf("--fields %s can only be output as json or ndjson", f)
+		}
+	}
+	return nil
+}
+
+// write outputs the records, or a summary of them, in the requested form
+func (o options) write(w io.Writer, records []record) error {
+	writer, ok := outputFormats[o.output]
+	if !ok {
+		return fmt.Errorf("unknown output format %q, must be one of %s", o.output, outputFormatNames())
+	}
+	if err := o.checkFields(); err != nil {
+		return err
+	}
+	if err := sortRecords(records, o.sortField, o.reverse); err != nil {
+		return err
+	}
+
+	if o.namesOnly {
+		names := hostnames(records, o.keepWildcards)
+		if o.onlyLive {
+			names = liveNames(names, records)
+		}
+		// the certs kept can have other names on them too
+		if len(o.matchNames) > 0 || len(o.excludeNames) > 0 {
+			include, exclude, err := o.nameMatchers()
+			if err != nil {
+				return err
+			}
+			names = matchingNames(names, include, exclude)
+		}
+		if o.count {
+			fmt.Fprintf(w, "Number of names found: %d\n", len(names))
+			return nil
+		}
+		for _, n := range names {
+			fmt.Fprintln(w, n)
+		}
+		return nil
+	}
+
+	if o.groupRegistrable {
+		groups := groupByRegistrable(records, o.keepWildcards)
+		if o.count {
+			fmt.Fprintf(w, "Number of registrable domains found: %d\n", len(groups))
+			return nil
+		}
+		return writeRegistrableGroups(w, o.output, groups)
+	}
+
+	if o.wildcardSummary {
+		summaries := summariseWildcards(records, time.Now().UTC())
+		if o.count {
+			fmt.Fprintf(w, "Number of wildcard names found: %d\n", len(summaries))
+			return nil
+		}
+		return writeWildcardSummary(w, o.output, summaries)
+	}
+
+	if o.aggregate {
+		names := aggregateNames(records)
+		if o.count {
+			fmt.Fprintf(w, "Number of names found: %d\n", len(names))
+			return nil
+		}
+		return writeAggregates(w, o.output, names)
+	}
+
+	if o.count {
+		fmt.Fprintf(w, "Number of certs found: %d\n", len(records))
+		return nil
+	}
+	if o.tmpl != nil {
+		return writeTemplate(w, o.tmpl, records)
+	}
+	if o.envelope && o.output == "json" {
+		status := "complete"
+		switch {
+		case o.timedOut:
+			status = "timeout"
+		case o.interrupted:
+			status = "interrupted"
+		}
+		return writeEnvelope(w, status, records, o.fields)
+	}
+	return writer(w, records, o.fields)
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+)
+
+// sampler keeps a representative sample of a stream of certs: the newest
+// and oldest by crt.sh id, plus a random selection of the rest
+type sampler struct {
+	n       int
+	scanned int
+	rnd     *rand.Rand
+
+	newest    []client.CertResponse // highest id first
+	oldest    []client.CertResponse // lowest id first
+	reservoir []client.CertResponse
+}
+
+func newSampler(n int) *sampler {
+	return &sampler{n: n, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// add offers a cert to the sample
+func (s *sampler) add(c client.CertResponse) {
+	s.scanned++
+	k := s.n / 3
+	s.newest = keepTop(s.newest, c, k, func(a, b client.CertResponse) bool { return a.ID > b.ID })
+	s.oldest = keepTop(s.oldest, c, k, func(a, b client.CertResponse) bool { return a.ID < b.ID })
+
+	// a reservoir as large as the whole sample, so there's enough left to
+	// fill it once the newest and oldest are taken out
+	if len(s.reservoir) < s.n {
+		s.reservoir = append(s.reservoir, c)
+	} else if i := s.rnd.Intn(s.scanned); i < s.n {
+		s.reservoir[i] = c
+	}
+}
+
+// keepTop inserts c into the k certs that sort first by less
+func keepTop(top []client.CertResponse, c client.CertResponse, k int, less func(a, b client.CertResponse) bool) []client.CertResponse {
+	if k == 0 {
+		return top
+	}
+	i := sort.Search(len(top), func(i int) bool { return less(c, top[i]) })
+	if i >= k {
+		return top
+	}
+	top = append(top, client.CertResponse{})
+	copy(top[i+1:], top[i:])
+	top[i] = c
+	if len(top) > k {
+		top = top[:k]
+	}
+	return top
+}
+
+// sample is the newest third, the oldest third and a random selection of
+// the rest, newest first
+func (s *sampler) sample() []client.CertResponse {
+	picked := make(map[int]bool)
+	var certs []client.CertResponse
+	take := func(c client.CertResponse) {
+		if len(certs) < s.n && !picked[c.ID] {
+			picked[c.ID] = true
+			certs = append(certs, c)
+		}
+	}
+	for _, c := range s.newest {
+		take(c)
+	}
+	for _, c := range s.oldest {
+		take(c)
+	}
+	s.rnd.Shuffle(len(s.reservoir), func(i, j int) {
+		s.reservoir[i], s.reservoir[j] = s.reservoir[j], s.reservoir[i]
+	})
+	for _, c := range s.reservoir {
+		take(c)
+	}
+
+	sort.SliceStable(certs, func(i, j int) bool {
+		return certs[i].ID > certs[j].ID
+	})
+	return certs
+}
+
+// searchSample is Search for --sample, reading at most --sample-scan certs
+// of the response before choosing the sample from them
+func searchSample(ctx context.Context, c *client.Client, q client.Query, o options) ([]client.CertResponse, error) {
+	s := newSampler(o.sample)
+	limited := false
+	err := c.Each(ctx, q, func(cert client.CertResponse) bool {
+		if o.sampleScan > 0 && s.scanned >= o.sampleScan {
+			limited = true
+			return false
+		}
+		s.add(cert)
+		return true
+	})
+	// a sample of a response that was cut off is returned with the error
+	var partial *client.PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, err
+	}
+
+	certs := s.sample()
+	if limited {
+		log.Infof("%s: sampled %d of the first %d certs, stopping there", q.Domain, len(certs), s.scanned)
+	} else {
+		log.Infof("%s: sampled %d of %d certs", q.Domain, len(certs), s.scanned)
+	}
+	return certs, err
+}
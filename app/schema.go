@@ -0,0 +1,59 @@
+package app
+
+import (
+	// embed is needed for the schema
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// schemaVersion is the version of schema.json that the output follows.
+// Adding optional fields keeps the version, removing or changing the
+// meaning of a field bumps it
+const schemaVersion = "1"
+
+//go:embed schema.json
+var outputSchema []byte
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of gcrt's JSON output",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, err := cmd.OutOrStdout().Write(outputSchema)
+		return err
+	},
+}
+
+func init() {
+	cmd.AddCommand(schemaCmd)
+}
+
+// envelope wraps the records with the schema version for --envelope
+type envelope struct {
+	SchemaVersion string    `json:"schema_version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	Status        string    `json:"status"`
+	Count         int       `json:"count"`
+	// the records, or their --fields
+	Certificates interface{} `json:"certificates"`
+}
+
+func writeEnvelope(w io.Writer, status string, records []record, fields []string) error {
+	output, err := json.MarshalIndent(envelope{
+		SchemaVersion: schemaVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Status:        status,
+		Count:         len(records),
+		Certificates:  jsonRecords(records, fields),
+	}, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(output))
+	return nil
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// validate checks v against the parts of JSON Schema draft 7 that
+// schema.json uses, returning what doesn't match
+func validate(root, schema map[string]interface{}, v interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		var def interface{} = root
+		for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, _ := def.(map[string]interface{})
+			def = m[key]
+		}
+		if def == nil {
+			return []string{fmt.Sprintf("%s: unknown $ref %s", path, ref)}
+		}
+		return validate(root, def.(map[string]interface{}), v, path)
+	}
+
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+	if t, ok := schema["type"].(string); ok && !hasType(v, t) {
+		fail("%v isn't of type %s", v, t)
+		return problems
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, v) {
+		fail("%v isn't %v", v, c)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+		if !found {
+			fail("%v isn't one of %v", v, enum)
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		options, ok := schema[key].([]interface{})
+		if !ok {
+			continue
+		}
+		matched := 0
+		for _, o := range options {
+			if len(validate(root, o.(map[string]interface{}), v, path)) == 0 {
+				matched++
+			}
+		}
+		if matched == 0 || (key == "oneOf" && matched > 1) {
+			fail("matches %d of the %s schemas", matched, key)
+		}
+	}
+	if n, ok := v.(float64); ok {
+		if min, ok := schema["minimum"].(float64); ok && n < min {
+			fail("%v is below the minimum %v", n, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && n > max {
+			fail("%v is above the maximum %v", n, max)
+		}
+	}
+	if s, ok := v.(string); ok {
+		if p, ok := schema["pattern"].(string); ok && !regexp.MustCompile(p).MatchString(s) {
+			fail("%q doesn't match %s", s, p)
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		a, _ := v.([]interface{})
+		for i, item := range a {
+			problems = append(problems, validate(root, items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	if obj, ok := v.(map[string]interface{}); ok {
+		required, _ := schema["required"].([]interface{})
+		for _, r := range required {
+			if _, ok := obj[r.(string)]; !ok {
+				fail("%s is missing", r)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for k, p := range properties {
+			if pv, ok := obj[k]; ok {
+				problems = append(problems, validate(root, p.(map[string]interface{}), pv, path+"."+k)...)
+			}
+		}
+	}
+	return problems
+}
+
+func hasType(v interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+// checkSchema validates output against schema.json
+func checkSchema(t *testing.T, output []byte) {
+	t.Helper()
+	var schema map[string]interface{}
+	if err := json.Unmarshal(outputSchema, &schema); err != nil {
+		t.Fatalf("parsing schema.json: %s", err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(output, &v); err != nil {
+		t.Fatalf("parsing output: %s", err)
+	}
+	for _, p := range validate(schema, schema, v, "$") {
+		t.Error(p)
+	}
+}
+
+// schemaRecords are records with every kind of field set
+func schemaRecords() []record {
+	r := record{CertResponse: testCert(1, "www.example.com")}
+	r.SHA256Fingerprint = strings.Repeat("ab", 32)
+	r.SANs = []string{"www.example.com"}
+	return []record{r, {CertResponse: testCert(2, "api.example.com")}}
+}
+
+func TestSchemaEnvelopeStatuses(t *testing.T) {
+	for _, status := range []string{"complete", "timeout", "interrupted"} {
+		t.Run(status, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeEnvelope(&buf, status, schemaRecords(), nil); err != nil {
+				t.Fatal(err)
+			}
+			checkSchema(t, buf.Bytes())
+		})
+	}
+}
+
+func TestSchemaRejectsUnknownStatus(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeEnvelope(&buf, "exploded", schemaRecords(), nil); err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	var v interface{}
+	json.Unmarshal(outputSchema, &schema)
+	json.Unmarshal(buf.Bytes(), &v)
+	if len(validate(schema, schema, v, "$")) == 0 {
+		t.Error("an envelope with an unknown status passed the schema")
+	}
+}
+
+func TestSchemaProjections(t *testing.T) {
+	for _, fields := range [][]string{
+		{"id", "common_name"},
+		{"common_name", "sans", "addresses", "dns", "validity_days", "severity"},
+	} {
+		t.Run(strings.Join(fields, ","), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeJSON(&buf, schemaRecords(), fields); err != nil {
+				t.Fatal(err)
+			}
+			checkSchema(t, buf.Bytes())
+
+			buf.Reset()
+			if err := writeEnvelope(&buf, "complete", schemaRecords(), fields); err != nil {
+				t.Fatal(err)
+			}
+			checkSchema(t, buf.Bytes())
+		})
+	}
+}
+
+// every field --fields can select is in the schema
+func TestSchemaHasEveryField(t *testing.T) {
+	var schema struct {
+		Definitions map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(outputSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	record, projection := schema.Definitions["record"].Properties, schema.Definitions["projection"].Properties
+	for _, f := range strings.Split(knownFieldNames(), ", ") {
+		if _, ok := record[f]; !ok {
+			t.Errorf("%s isn't in the schema's record", f)
+		}
+	}
+	for f := range record {
+		if _, ok := projection[f]; !ok {
+			t.Errorf("%s isn't in the schema's projection", f)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+)
+
+var serveOpts struct {
+	listen   string
+	cacheTTL time.Duration
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve searches as an HTTP API returning JSON",
+	Long: `serve runs gcrt as a small HTTP server, so dashboards and other tools can
+search crt.sh through one gateway rather than each querying it themselves.
+GET /search takes the search as query parameters and returns the same JSON
+as gcrt -o json. The other flags given to serve, such as --enrich,
+--exclude-issuer or --rate-limit, apply to every search. Results are cached
+for --cache-ttl, and identical searches made at the same time share one
+query to crt.sh.
+
+The parameters of /search are domain, org and identity (each may be
+repeated), days, between, not_after_between, expired, active_only,
+expiring_within, match, exclude, issuer and fields. GET /healthz returns ok, and GET /metrics
+reports on the server for Prometheus`,
+	Example: `  gcrt serve --listen :8080 --rate-limit 1
+  curl 'http://localhost:8080/search?domain=%25.example.com&days=7'`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runServe(ctx, opts)
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveOpts.listen, "listen", ":8080", "The address to listen on")
+	serveCmd.Flags().DurationVar(&serveOpts.cacheTTL, "cache-ttl", time.Hour, "How long search results are cached for, 0 to not cache them")
+	cmd.AddCommand(serveCmd)
+}
+
+// server answers searches from one client shared by every request, so
+// --rate-limit covers the whole server
+type server struct {
+	o   options
+	ttl time.Duration
+
+	mu      sync.Mutex
+	results map[string]*searchResult
+}
+
+// searchResult is a cached search, or one still running that later
+// requests for the same search wait on
+type searchResult struct {
+	done   chan struct{}
+	body   []byte
+	err    error
+	status int
+	at     time.Time
+	// partial is whether some of the domains searched failed, which
+	// isn't cached
+	partial bool
+}
+
+func runServe(ctx context.Context, o options) error {
+	if o.retryBudget >= 0 {
+		return errors.New("--retry-budget can't be used with gcrt serve, as it would be spent over the server's lifetime")
+	}
+	if len(o.outFile) > 0 {
+		return errors.New("--out-file can't be used with gcrt serve, searches are returned to the client")
+	}
+	// searches are always returned as the certs' JSON
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"--count", o.count},
+		{"--template", len(o.template) > 0},
+		{"--aggregate", o.aggregate},
+		{"--group-by-registrable", o.groupRegistrable},
+		{"--names-only", o.namesOnly},
+		{"--wildcard-summary", o.wildcardSummary},
+		{"--diff-against", len(o.diffAgainst) > 0},
+		{"--liveness", o.liveness},
+		{"--staleness-report", o.stalenessReport},
+		{"--permutations-file", len(o.permutationsFile) > 0},
+	} {
+		if f.set {
+			return fmt.Errorf("%s can't be used with gcrt serve, searches return the certificates as JSON", f.name)
+		}
+	}
+
+	o.metrics = newMetrics()
+	c, closeClient, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+	o.client = c
+	o.output = "json"
+	// the searches share the backoff state, rather than each loading and
+	// saving their own copy
+	if o.backoff, err = o.loadBackoff(); err != nil {
+		return err
+	}
+
+	s := &server{o: o, ttl: serveOpts.cacheTTL, results: make(map[string]*searchResult)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", s.search)
+	mux.Handle("/metrics", o.metrics.registry)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	srv := &http.Server{Addr: serveOpts.listen, Handler: mux}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	log.Infof("serving searches on %s", serveOpts.listen)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	log.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// searchOptions applies the query parameters of a /search request to the
+// server's options
+func (s *server) searchOptions(params url.Values) (options, error) {
+	o := s.o
+	o.domains = nil
+	o.stdin = false
+	for _, d := range params["domain"] {
+		if d = strings.TrimSpace(d); len(d) > 0 && d != "-" {
+			o.domains = append(o.domains, d)
+		}
+	}
+	o.orgs = params["org"]
+	o.identities = params["identity"]
+	if len(o.domains) == 0 && len(o.identityTargets()) == 0 {
+		return o, errors.New("a domain, org or identity parameter is required")
+	}
+
+	if v := params.Get("days"); len(v) > 0 {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return o, fmt.Errorf("invalid days %q", v)
+		}
+		o.days = days
+	}
+	if v := params.Get("between"); len(v) > 0 {
+		o.between = v
+	}
+	if v := params.Get("not_after_between"); len(v) > 0 {
+		o.notAfterBetween = v
+	}
+	for name, flag := range map[string]*bool{"expired": &o.expired, "active_only": &o.activeOnly} {
+		if v := params.Get(name); len(v) > 0 {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return o, fmt.Errorf("invalid %s %q, must be true or false", name, v)
+			}
+			*flag = b
+		}
+	}
+	if v := params.Get("expiring_within"); len(v) > 0 {
+		o.expiringWithin = v
+	}
+	if v, ok := params["match"]; ok {
+		o.matchNames = v
+	}
+	if v, ok := params["exclude"]; ok {
+		o.excludeNames = v
+	}
+	if v, ok := params["issuer"]; ok {
+		o.issuers = v
+	}
+	if v := params.Get("fields"); len(v) > 0 {
+		o.fields = strings.Split(v, ",")
+	}
+
+	// the errors run would only find once it's searched
+	if _, err := o.query(); err != nil {
+		return o, err
+	}
+	if _, err := o.validityFilter(); err != nil {
+		return o, err
+	}
+	if _, _, err := o.nameMatchers(); err != nil {
+		return o, err
+	}
+	if err := o.checkFields(); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeServeError(w, r, http.StatusMethodNotAllowed, errors.New("only GET is supported"))
+		return
+	}
+	params := r.URL.Query()
+	o, err := s.searchOptions(params)
+	if err != nil {
+		writeServeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// url.Values.Encode sorts the parameters, so the same search in a
+	// different order is cached once
+	key := params.Encode()
+	res, cached := s.result(key, o)
+	select {
+	case <-res.done:
+	case <-r.Context().Done():
+		return
+	}
+	if cached {
+		w.Header().Set("X-Cache", "hit")
+	} else {
+		w.Header().Set("X-Cache", "miss")
+	}
+	if res.err != nil {
+		writeServeError(w, r, res.status, res.err)
+		return
+	}
+	if res.partial {
+		w.Header().Set("X-Partial-Results", "true")
+	}
+	writeServeBody(w, r, http.StatusOK, res.body)
+}
+
+// result returns the cached or running search for key, starting it if
+// there's neither, and whether it was already there
+func (s *server) result(key string, o options) (*searchResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if res, ok := s.results[key]; ok {
+		select {
+		case <-res.done:
+			if res.err == nil && !res.partial && now.Sub(res.at) < s.ttl {
+				return res, true
+			}
+		default:
+			return res, true
+		}
+	}
+	// forget the searches that have expired while there's the lock
+	for k, res := range s.results {
+		select {
+		case <-res.done:
+			if res.err != nil || res.partial || now.Sub(res.at) >= s.ttl {
+				delete(s.results, k)
+			}
+		default:
+		}
+	}
+
+	res := &searchResult{done: make(chan struct{})}
+	s.results[key] = res
+	go func() {
+		defer close(res.done)
+		log.WithField("search", key).Info("searching")
+		// the search isn't tied to the request that started it, as others
+		// may be waiting on it
+		var buf bytes.Buffer
+		err := run(context.Background(), o, &buf)
+		var timedOut maxDurationError
+		var partial partialError
+		switch {
+		case err == errNoResults:
+			err = nil
+		case errors.As(err, &partial):
+			// the domains that worked are still returned
+			log.WithError(err).WithField("search", key).Warn("search partly failed")
+			res.partial, err = true, nil
+		case errors.As(err, &timedOut):
+			res.err, res.status = err, http.StatusGatewayTimeout
+		case err != nil:
+			res.err, res.status = err, http.StatusBadGateway
+		}
+		res.body = buf.Bytes()
+		res.at = time.Now()
+		o.metrics.finished(err)
+		if err != nil {
+			log.WithError(err).WithField("search", key).Error("search failed")
+		}
+	}()
+	return res, false
+}
+
+// writeServeError writes an error as a JSON object
+func writeServeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	body, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+	writeServeBody(w, r, status, append(body, '\n'))
+}
+
+// writeServeBody writes a JSON response, only its headers for HEAD
+func writeServeBody(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
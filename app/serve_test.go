@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+func TestServeHead(t *testing.T) {
+	c, _ := fakeCrtsh(t, []client.CertResponse{testCert(1, "www.example.com")})
+	o := opts
+	o.client, o.output, o.metrics = c, "json", newMetrics()
+	s := &server{o: o, results: make(map[string]*searchResult)}
+
+	for _, tc := range []struct {
+		target string
+		status int
+	}{
+		{"/search?domain=%25.example.com", http.StatusOK},
+		{"/search", http.StatusBadRequest},
+	} {
+		get := httptest.NewRecorder()
+		s.search(get, httptest.NewRequest(http.MethodGet, tc.target, nil))
+		head := httptest.NewRecorder()
+		s.search(head, httptest.NewRequest(http.MethodHead, tc.target, nil))
+
+		if get.Code != tc.status || head.Code != tc.status {
+			t.Errorf("%s: GET %d, HEAD %d, want %d", tc.target, get.Code, head.Code, tc.status)
+		}
+		if get.Body.Len() == 0 || head.Body.Len() != 0 {
+			t.Errorf("%s: GET body %q, HEAD body %q, want only GET's", tc.target, get.Body, head.Body)
+		}
+		if want := get.Header().Get("Content-Length"); head.Header().Get("Content-Length") != want {
+			t.Errorf("%s: HEAD Content-Length %q, want GET's %q", tc.target, head.Header().Get("Content-Length"), want)
+		}
+	}
+}
+
+func TestServeRejectsOutputFlags(t *testing.T) {
+	for name, set := range map[string]func(*options){
+		"--count":                func(o *options) { o.count = true },
+		"--template":             func(o *options) { o.template = "{{.ID}}" },
+		"--aggregate":            func(o *options) { o.aggregate = true },
+		"--group-by-registrable": func(o *options) { o.groupRegistrable = true },
+		"--names-only":           func(o *options) { o.namesOnly = true },
+		"--wildcard-summary":     func(o *options) { o.wildcardSummary = true },
+		"--diff-against":         func(o *options) { o.diffAgainst = "previous.json" },
+		"--liveness":             func(o *options) { o.liveness = true },
+		"--staleness-report":     func(o *options) { o.stalenessReport = true },
+		"--permutations-file":    func(o *options) { o.permutationsFile = "permutations.csv" },
+	} {
+		o := opts
+		set(&o)
+		if err := runServe(context.Background(), o); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: runServe = %v, want it rejected", name, err)
+		}
+	}
+}
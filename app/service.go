@@ -0,0 +1,87 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var serviceOpts struct {
+	name    string
+	dryRun  bool
+	unitDir string
+}
+
+// service describes the service that runs gcrt watch
+type service struct {
+	name        string
+	description string
+	exe         string
+	args        []string
+}
+
+func newService(args []string) (service, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return service{}, err
+	}
+	if exe, err = filepath.Abs(exe); err != nil {
+		return service{}, err
+	}
+	return service{
+		name:        serviceOpts.name,
+		description: "gcrt certificate transparency monitor",
+		exe:         exe,
+		args:        append([]string{"watch"}, args...),
+	}, nil
+}
+
+var installCmd = &cobra.Command{
+	Use:   "install [-- watch flags]",
+	Short: "Install gcrt watch as a systemd unit or Windows service",
+	Long: `install registers a service that runs gcrt watch with the flags given
+after --, and starts it:
+
+  gcrt monitor install -- -d %.example.com --interval 30m --webhook-url https://...
+
+On Linux a systemd unit is written and enabled, with its state kept in
+/var/lib/<name>. On Windows a service is created that starts automatically,
+with its state kept in %ProgramData%\gcrt. Stopping the service finishes the
+current poll's output and saves the state before exiting`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := newService(args)
+		if err != nil {
+			return err
+		}
+		if serviceOpts.dryRun {
+			fmt.Print(s.definition())
+			return nil
+		}
+		return installService(s)
+	},
+}
+
+var uninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop and remove the service installed by install",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := newService(nil)
+		if err != nil {
+			return err
+		}
+		return uninstallService(s)
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{installCmd, uninstallCmd} {
+		c.Flags().StringVar(&serviceOpts.name, "name", "gcrt-monitor", "Name of the service")
+		watchCmd.AddCommand(c)
+	}
+	installCmd.Flags().BoolVar(&serviceOpts.dryRun, "dry-run", false, "Print the service definition instead of installing it")
+	installCmd.Flags().StringVar(&serviceOpts.unitDir, "unit-dir", "/etc/systemd/system", "Directory the systemd unit is written to")
+	uninstallCmd.Flags().StringVar(&serviceOpts.unitDir, "unit-dir", "/etc/systemd/system", "Directory the systemd unit was written to")
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func (s service) unitPath() string {
+	return filepath.Join(serviceOpts.unitDir, s.name+".service")
+}
+
+// systemdQuote quotes an ExecStart word, escaping the % specifiers that
+// domain patterns are full of
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if len(arg) > 0 && !strings.ContainsAny(arg, " \t\"'\\;$") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`).Replace(arg) + `"`
+}
+
+// definition is the systemd unit. systemd stops it with SIGTERM, which
+// watch handles by finishing the current poll
+func (s service) definition() string {
+	words := []string{systemdQuote(s.exe)}
+	for _, a := range s.args {
+		words = append(words, systemdQuote(a))
+	}
+
+	return fmt.Sprintf(`[Unit]
+Description=%s
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=simple
+ExecStart=%s
+Restart=on-failure
+RestartSec=30
+StateDirectory=%s
+WorkingDirectory=/var/lib/%s
+KillSignal=SIGTERM
+TimeoutStopSec=60
+
+[Install]
+WantedBy=multi-user.target
+`, s.description, strings.Join(words, " "), s.name, s.name)
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func installService(s service) error {
+	if _, err := os.Stat(s.unitPath()); err == nil {
+		return fmt.Errorf("%s already exists, uninstall it first", s.unitPath())
+	}
+	if err := os.WriteFile(s.unitPath(), []byte(s.definition()), 0644); err != nil {
+		return err
+	}
+	err := systemctl("daemon-reload")
+	if err == nil {
+		err = systemctl("enable", "--now", s.name+".service")
+	}
+	if err != nil {
+		os.Remove(s.unitPath())
+	}
+	return err
+}
+
+func uninstallService(s service) error {
+	if _, err := os.Stat(s.unitPath()); err != nil {
+		return fmt.Errorf("%s isn't installed: %s", s.name, err)
+	}
+	if err := systemctl("disable", "--now", s.name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(s.unitPath()); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
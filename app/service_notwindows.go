@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package app
+
+import "context"
+
+// runService runs fn under the Windows service manager when gcrt was
+// started by it. Elsewhere services are stopped with a signal, which watch
+// already handles
+func runService(ctx context.Context, fn func(context.Context) error) (bool, error) {
+	return false, nil
+}
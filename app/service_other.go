@@ -0,0 +1,21 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package app
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func (s service) definition() string {
+	return ""
+}
+
+func installService(s service) error {
+	return fmt.Errorf("installing a service isn't supported on %s", runtime.GOOS)
+}
+
+func uninstallService(s service) error {
+	return fmt.Errorf("installing a service isn't supported on %s", runtime.GOOS)
+}
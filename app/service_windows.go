@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// definition describes the service that install creates
+func (s service) definition() string {
+	return fmt.Sprintf("Service: %s\nDescription: %s\nCommand: %q %s\nStart: automatic\n",
+		s.name, s.description, s.exe, strings.Join(s.args, " "))
+}
+
+func installService(s service) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if existing, err := m.OpenService(s.name); err == nil {
+		existing.Close()
+		return fmt.Errorf("service %s already exists, uninstall it first", s.name)
+	}
+
+	service, err := m.CreateService(s.name, s.exe, mgr.Config{
+		DisplayName: s.name,
+		Description: s.description,
+		StartType:   mgr.StartAutomatic,
+	}, s.args...)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	return service.Start()
+}
+
+func uninstallService(s service) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	service, err := m.OpenService(s.name)
+	if err != nil {
+		return fmt.Errorf("%s isn't installed: %s", s.name, err)
+	}
+	defer service.Close()
+
+	if status, err := service.Control(svc.Stop); err == nil {
+		// give watch the chance to save its state
+		for deadline := time.Now().Add(time.Minute); status.State != svc.Stopped && time.Now().Before(deadline); {
+			time.Sleep(time.Second)
+			if status, err = service.Query(); err != nil {
+				break
+			}
+		}
+	}
+	return service.Delete()
+}
+
+// runService runs fn under the Windows service manager when gcrt was
+// started by it, cancelling fn's context when the service is stopped
+func runService(ctx context.Context, fn func(context.Context) error) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, err
+	}
+
+	// services start in the system directory, keep the state somewhere
+	// sensible instead
+	dir := filepath.Join(os.Getenv("ProgramData"), "gcrt")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return true, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return true, err
+	}
+
+	h := &serviceHandler{ctx: ctx, fn: fn}
+	if err := svc.Run("gcrt", h); err != nil {
+		return true, err
+	}
+	return true, h.err
+}
+
+type serviceHandler struct {
+	ctx context.Context
+	fn  func(context.Context) error
+	err error
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- h.fn(ctx) }()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.err = <-done:
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severity ranks how interesting a certificate is for triage
+type Severity int
+
+// Severity levels, lowest first
+const (
+	SeverityInfo Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"info", "low", "medium", "high", "critical"}
+
+func (s Severity) String() string {
+	if s < SeverityInfo || s > SeverityCritical {
+		return "unknown"
+	}
+	return severityNames[s]
+}
+
+// Score is the numeric form of the severity, from 0 to 100
+func (s Severity) Score() int {
+	return int(s) * 25
+}
+
+func parseSeverity(s string) (Severity, error) {
+	for i, n := range severityNames {
+		if strings.EqualFold(s, n) {
+			return Severity(i), nil
+		}
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q, must be one of %s", s, strings.Join(severityNames, ", "))
+}
+
+// Rule assigns a severity to every cert whose field satisfies all of the
+// rule's conditions
+type Rule struct {
+	Name     string   `json:"name"`
+	Severity string   `json:"severity"`
+	Field    string   `json:"field"`
+	Match    string   `json:"match,omitempty"`
+	NotMatch string   `json:"not_match,omitempty"`
+	Below    *float64 `json:"below,omitempty"`
+	Above    *float64 `json:"above,omitempty"`
+
+	severity Severity
+	match    *regexp.Regexp
+	notMatch *regexp.Regexp
+}
+
+// RuleSet is the format of the file passed to --rules
+type RuleSet struct {
+	Rules []Rule `json:"rules"`
+}
+
+func floatPtr(f float64) *float64 { return &f }
+
+// defaultRules are used by --score when no rules file is given. Weak keys
+// are RSA and DSA keys under 2048 bits and ECDSA keys under 256, which need
+// --enrich x509, and an unexpected CA is one CAA doesn't authorize, which
+// needs --check-caa
+var defaultRules = RuleSet{Rules: []Rule{
+	{Name: "routine-lets-encrypt", Severity: "info", Field: "issuer_name", Match: `Let's Encrypt`},
+	{Name: "wildcard", Severity: "low", Field: "name_value", Match: `(^|\n)\*\.`},
+	{Name: "long-validity", Severity: "medium", Field: "validity_days", Above: floatPtr(398)},
+	{Name: "weak-key", Severity: "medium", Field: "public_key", Match: `^((RSA|DSA) (\d{1,3}|1\d{3}|20[0-3]\d|204[0-7])|ECDSA (\d{1,2}|1\d\d|2[0-4]\d|25[0-5]))$`},
+	{Name: "unexpected-ca", Severity: "high", Field: "caa_status", Match: `^unauthorized$`},
+}}
+
+func loadRules(path string) (RuleSet, error) {
+	var rs RuleSet
+
+	f, err := os.Open(path)
+	if err != nil {
+		return rs, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&rs); err != nil {
+		return rs, fmt.Errorf("parsing rules: %s", err)
+	}
+	return rs, nil
+}
+
+// compile validates every rule and prepares its patterns
+func (rs *RuleSet) compile() error {
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+
+		if !knownFields[r.Field] {
+			return fmt.Errorf("rule %q: unknown field %q", r.Name, r.Field)
+		}
+
+		sev, err := parseSeverity(r.Severity)
+		if err != nil {
+			return fmt.Errorf("rule %q: %s", r.Name, err)
+		}
+		r.severity = sev
+
+		if len(r.Match) > 0 {
+			if r.match, err = regexp.Compile(r.Match); err != nil {
+				return fmt.Errorf("rule %q: %s", r.Name, err)
+			}
+		}
+		if len(r.NotMatch) > 0 {
+			if r.notMatch, err = regexp.Compile(r.NotMatch); err != nil {
+				return fmt.Errorf("rule %q: %s", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r Rule) matches(c record) bool {
+	v, ok := fieldValue(c, r.Field)
+	if !ok {
+		return false
+	}
+	if r.match != nil && !r.match.MatchString(v) {
+		return false
+	}
+	if r.notMatch != nil && r.notMatch.MatchString(v) {
+		return false
+	}
+	if r.Below != nil || r.Above != nil {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return false
+		}
+		if r.Below != nil && f >= *r.Below {
+			return false
+		}
+		if r.Above != nil && f <= *r.Above {
+			return false
+		}
+	}
+	return true
+}
+
+// score sets the severity of each cert to the highest severity of the
+// rules it matches and records which rules those were
+func (rs RuleSet) score(certs []record) {
+	for i := range certs {
+		c := &certs[i]
+		sev := SeverityInfo
+		c.Findings = nil
+
+		for _, r := range rs.Rules {
+			if !r.matches(*c) {
+				continue
+			}
+			c.Findings = append(c.Findings, r.Name)
+			if r.severity > sev {
+				sev = r.severity
+			}
+		}
+
+		// info scores 0, which is still output
+		score := sev.Score()
+		c.Severity = sev.String()
+		c.SeverityScore = &score
+	}
+}
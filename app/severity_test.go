@@ -0,0 +1,66 @@
+package app
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func compiledDefaultRules(t *testing.T) RuleSet {
+	t.Helper()
+	rs := RuleSet{Rules: append([]Rule{}, defaultRules.Rules...)}
+	if err := rs.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestDefaultRules(t *testing.T) {
+	rs := compiledDefaultRules(t)
+	for _, tc := range []struct {
+		name string
+		set  func(*record)
+		want string
+	}{
+		{"routine", func(r *record) {}, "info"},
+		{"weak RSA key", func(r *record) { r.KeyAlgorithm, r.KeySize = "RSA", 1024 }, "medium"},
+		{"RSA key", func(r *record) { r.KeyAlgorithm, r.KeySize = "RSA", 2048 }, "info"},
+		{"weak ECDSA key", func(r *record) { r.KeyAlgorithm, r.KeySize = "ECDSA", 224 }, "medium"},
+		{"ECDSA key", func(r *record) { r.KeyAlgorithm, r.KeySize = "ECDSA", 256 }, "info"},
+		{"unexpected CA", func(r *record) { r.CAAStatus = caaUnauthorized }, "high"},
+		{"authorized CA", func(r *record) { r.CAAStatus = caaAuthorized }, "info"},
+	} {
+		r := record{CertResponse: testCert(1, "www.example.com")}
+		r.NotAfter = "2024-03-31T00:00:00"
+		tc.set(&r)
+		records := []record{r}
+		rs.score(records)
+		if records[0].Severity != tc.want {
+			t.Errorf("%s: severity = %s (%v), want %s", tc.name, records[0].Severity, records[0].Findings, tc.want)
+		}
+	}
+}
+
+func TestInfoScoreOutput(t *testing.T) {
+	records := []record{{CertResponse: testCert(1, "www.example.com")}}
+	records[0].NotAfter = "2024-03-31T00:00:00"
+	compiledDefaultRules(t).score(records)
+	if records[0].Severity != "info" {
+		t.Fatalf("severity = %s, want info", records[0].Severity)
+	}
+	data, err := json.Marshal(records[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"severity_score":0`) {
+		t.Errorf("an info cert's JSON is %s, want its severity_score of 0", data)
+	}
+
+	data, err = json.Marshal(record{CertResponse: testCert(1, "www.example.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "severity_score") {
+		t.Errorf("an unscored cert's JSON is %s, want no severity_score", data)
+	}
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// sortFields are the fields --sort can order records by. Timestamps sort as
+// strings, as crt.sh's format orders them chronologically
+var sortFields = []string{"not_before", "not_after", "entry_timestamp", "issuer_name", "common_name", "id"}
+
+// checkSort rejects an unknown --sort before searching rather than once
+// the results are in
+func (o options) checkSort() error {
+	if len(o.sortField) == 0 {
+		return nil
+	}
+	for _, f := range sortFields {
+		if f == o.sortField {
+			return nil
+		}
+	}
+	return fmt.Errorf("can't --sort by %q, must be one of %s", o.sortField, strings.Join(sortFields, ", "))
+}
+
+// sortRecords orders records by a field, keeping crt.sh's order for records
+// with the same value. Records without the field go last either way
+func sortRecords(records []record, field string, reverse bool) error {
+	if len(field) == 0 {
+		if reverse {
+			for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+				records[i], records[j] = records[j], records[i]
+			}
+		}
+		return nil
+	}
+
+	var less func(a, b record) bool
+	var missing func(r record) bool
+	switch field {
+	case "id":
+		less = func(a, b record) bool { return a.ID < b.ID }
+		missing = func(r record) bool { return r.ID == 0 }
+	case "not_before", "not_after", "entry_timestamp", "issuer_name", "common_name":
+		less = func(a, b record) bool {
+			av, _ := fieldValue(a, field)
+			bv, _ := fieldValue(b, field)
+			return av < bv
+		}
+		missing = func(r record) bool {
+			v, _ := fieldValue(r, field)
+			return len(v) == 0
+		}
+	default:
+		return fmt.Errorf("can't --sort by %q, must be one of %s", field, strings.Join(sortFields, ", "))
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if ma, mb := missing(a), missing(b); ma || mb {
+			return !ma && mb
+		}
+		if reverse {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	return nil
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+)
+
+// crtshSource is the --source name of crt.sh
+const crtshSource = "crtsh"
+
+// onlyCrtsh reports whether crt.sh is the only source searched, which the
+// features that rely on crt.sh ids need
+func (o options) onlyCrtsh() bool {
+	for _, s := range o.sources {
+		if s != crtshSource {
+			return false
+		}
+	}
+	return true
+}
+
+// requireCrtsh fails for features that can't use the other sources
+func (o options) requireCrtsh(feature string) error {
+	if o.onlyCrtsh() {
+		return nil
+	}
+	return fmt.Errorf("%s only searches crt.sh, as it keeps track of certs by their crt.sh id", feature)
+}
+
+// newSource builds the --source searches, or returns nil when crt.sh is
+// searched on its own. The sources other than crt.sh share c's retries,
+// rate limit and proxy settings, and read their credentials from the
+// environment so they don't show up in process listings
+func (o options) newSource(c *client.Client) (client.Source, error) {
+	if o.onlyCrtsh() {
+		return nil, nil
+	}
+	if o.shard || o.sample > 0 {
+		return nil, errors.New("--shard and --sample only work with crt.sh, not other sources")
+	}
+
+	var sources []client.Source
+	seen := make(map[string]bool)
+	for _, name := range o.sources {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		switch name {
+		case crtshSource:
+			sources = append(sources, c)
+		case "censys":
+			id, secret := os.Getenv("GCRT_CENSYS_API_ID"), os.Getenv("GCRT_CENSYS_API_SECRET")
+			if len(id) == 0 || len(secret) == 0 {
+				return nil, errors.New("--source censys needs an API id and secret in GCRT_CENSYS_API_ID and GCRT_CENSYS_API_SECRET")
+			}
+			sources = append(sources, c.NewCensys(client.DefaultCensysURL, id, secret))
+		case "facebook":
+			token := os.Getenv("GCRT_FACEBOOK_TOKEN")
+			if len(token) == 0 {
+				return nil, errors.New("--source facebook needs an access token in GCRT_FACEBOOK_TOKEN, e.g. <app id>|<app secret>")
+			}
+			sources = append(sources, c.NewFacebook(client.DefaultFacebookURL, token))
+		case "ctlog":
+			if len(o.ctLogs) == 0 || o.ctLogEntries <= 0 {
+				return nil, errors.New("--source ctlog needs at least one --ct-log and a positive --ct-log-entries")
+			}
+			sources = append(sources, c.NewCTLog(o.ctLogs, o.ctLogEntries))
+		default:
+			return nil, fmt.Errorf("invalid --source %q, must be crtsh, censys, facebook or ctlog", name)
+		}
+	}
+	return client.Merged{
+		Sources: sources,
+		OnError: func(s client.Source, err error) {
+			log.WithError(err).Warnf("searching %s failed, using the other sources", s.Name())
+		},
+	}, nil
+}
+
+// certKey identifies a cert across domains and runs: its crt.sh id, or for
+// certs from other sources, its serial number and not before date
+func certKey(c client.CertResponse) string {
+	if c.ID != 0 {
+		return strconv.Itoa(c.ID)
+	}
+	return c.CrossSourceKey()
+}
@@ -0,0 +1,369 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apex/log"
+	// the SQLite driver for database/sql
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/cobra"
+)
+
+// sqliteSchemaVersion is the version of sqliteSchema, kept in the database's
+// user_version
+const sqliteSchemaVersion = 1
+
+// sqliteSchema is the layout of an export: one row per cert, its issuer and
+// each of its names, along with the runs that found them
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at TEXT NOT NULL,
+	command    TEXT NOT NULL,
+	certs      INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS issuers (
+	id   INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS certs (
+	id              INTEGER PRIMARY KEY,
+	issuer_id       INTEGER NOT NULL REFERENCES issuers(id),
+	common_name     TEXT NOT NULL,
+	serial_number   TEXT NOT NULL,
+	entry_timestamp TEXT NOT NULL,
+	not_before      TEXT NOT NULL,
+	not_after       TEXT NOT NULL,
+	source_domain   TEXT NOT NULL DEFAULT '',
+	first_run       INTEGER NOT NULL REFERENCES runs(id),
+	last_run        INTEGER NOT NULL REFERENCES runs(id),
+	record          TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS certs_not_after ON certs(not_after);
+CREATE INDEX IF NOT EXISTS certs_last_run ON certs(last_run);
+CREATE TABLE IF NOT EXISTS names (
+	cert_id INTEGER NOT NULL REFERENCES certs(id),
+	name    TEXT NOT NULL,
+	PRIMARY KEY (cert_id, name)
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS names_name ON names(name);
+`
+
+// openSQLite opens the export database, creating its tables when it's new
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %s", path, err)
+	}
+	if version > sqliteSchemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("%s was written by a newer gcrt, its schema is version %d", path, version)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating tables in %s: %s", path, err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// exportSQLite adds the records to the database as found by a new run.
+// Certs already in it keep the run that first found them and are updated
+// with what's been learned since
+func exportSQLite(ctx context.Context, path string, records []record) error {
+	db, err := openSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO runs (started_at, command, certs) VALUES (?, ?, ?)",
+		time.Now().UTC().Format(time.RFC3339), strings.Join(os.Args, " "), len(records))
+	if err != nil {
+		return err
+	}
+	run, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	issuer, err := tx.Prepare("INSERT INTO issuers (id, name) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name")
+	if err != nil {
+		return err
+	}
+	cert, err := tx.Prepare(`INSERT INTO certs (id, issuer_id, common_name, serial_number, entry_timestamp, not_before, not_after, source_domain, first_run, last_run, record)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET last_run = excluded.last_run, record = excluded.record,
+			source_domain = CASE WHEN excluded.source_domain = '' THEN certs.source_domain ELSE excluded.source_domain END`)
+	if err != nil {
+		return err
+	}
+	name, err := tx.Prepare("INSERT OR IGNORE INTO names (cert_id, name) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+
+	skipped := 0
+	for _, r := range records {
+		// the certs of other sources loaded with --from have no id to key them
+		if r.ID == 0 {
+			skipped++
+			continue
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := issuer.Exec(r.IssuerCAID, r.IssuerName); err != nil {
+			return fmt.Errorf("exporting issuer of cert %d: %s", r.ID, err)
+		}
+		if _, err := cert.Exec(r.ID, r.IssuerCAID, r.CommonName, r.SerialNumber, r.EntryTimestamp, r.NotBefore, r.NotAfter, r.SourceDomain, run, run, string(data)); err != nil {
+			return fmt.Errorf("exporting cert %d: %s", r.ID, err)
+		}
+		for _, n := range r.Names() {
+			if _, err := name.Exec(r.ID, n); err != nil {
+				return fmt.Errorf("exporting names of cert %d: %s", r.ID, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if skipped > 0 {
+		log.Warnf("skipped %d cert(s) without a crt.sh id", skipped)
+	}
+	log.Infof("exported %d certs to %s as run %d", len(records), path, run)
+	return nil
+}
+
+var exportOpts struct {
+	sqlite string
+	from   []string
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Search crt.sh and add the results to a SQLite database",
+	Long: `export runs a search like gcrt does and adds the certificates found, their
+names and issuers to a SQLite database, noting the run that first and last
+found each one. Results saved by earlier runs can be added with --from
+instead of searching. See gcrt db query to read it back`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// --out-file results.db works as --sqlite does
+		path := exportOpts.sqlite
+		if len(path) == 0 {
+			path = opts.sqlite
+		}
+		if len(path) == 0 {
+			return errors.New(`required flag(s) "sqlite" not set`)
+		}
+		if len(opts.diffAgainst) > 0 {
+			return errors.New("--diff-against can't be used with gcrt export")
+		}
+		if err := opts.requireCrtsh("gcrt export"); err != nil {
+			return err
+		}
+		if len(exportOpts.from) > 0 {
+			var records []record
+			for _, f := range exportOpts.from {
+				certs, err := loadCerts(f)
+				if err != nil {
+					return fmt.Errorf("loading results from %s: %s", f, err)
+				}
+				records = mergeRecords(records, newRecords(certs))
+			}
+			return exportSQLite(context.Background(), path, records)
+		}
+
+		o := opts
+		o.sqlite = path
+		ctx, stop := interruptContext()
+		defer stop()
+		return runTraced(ctx, o, ioutil.Discard)
+	},
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Work with databases written by gcrt export",
+}
+
+var dbQueryCmd = &cobra.Command{
+	Use:   "query <file.db> <sql>",
+	Short: "Run a read-only SQL query against a gcrt export",
+	Long: `query runs a SQL statement against a database written by gcrt export and
+prints the rows in the --output format: json, ndjson, csv, tsv or table. The
+tables are certs, names, issuers and runs, and each cert's full record is in
+certs.record as JSON for json_extract`,
+	Example: `  gcrt db query results.db "SELECT name, count(*) FROM names GROUP BY name ORDER BY 2 DESC" -o table
+  gcrt db query results.db "SELECT id, not_after FROM certs WHERE first_run = (SELECT max(id) FROM runs)"`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := os.Stat(args[0]); err != nil {
+			return err
+		}
+		db, err := sql.Open("sqlite3", "file:"+args[0]+"?mode=ro&_busy_timeout=5000")
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		rows, err := db.Query(args[1])
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		out, err := opts.openOutput(false)
+		if err != nil {
+			return err
+		}
+		if err := writeQueryRows(out, opts.output, rows); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportOpts.sqlite, "sqlite", "", "The SQLite database to add the results to, created if it doesn't exist")
+	exportCmd.Flags().StringArrayVar(&exportOpts.from, "from", nil, "Add the JSON or ndjson output of a previous run instead of searching (may be repeated)")
+	dbCmd.AddCommand(dbQueryCmd)
+	cmd.AddCommand(exportCmd, dbCmd)
+}
+
+// writeQueryRows writes the result of a query in format
+func writeQueryRows(w io.Writer, format string, rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var table [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		table = append(table, values)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cell := func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+
+	switch format {
+	case "json", "ndjson":
+		objects := make([]queryRow, len(table))
+		for i, values := range table {
+			objects[i] = queryRow{columns, values}
+		}
+		if format == "ndjson" {
+			enc := json.NewEncoder(w)
+			for _, o := range objects {
+				if err := enc.Encode(o); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		output, err := json.MarshalIndent(objects, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	case "csv", "tsv":
+		cells := make([][]string, len(table))
+		for i, values := range table {
+			cells[i] = make([]string, len(values))
+			for j, v := range values {
+				cells[i][j] = cell(v)
+			}
+		}
+		return writeRows(w, delimiter(format), columns, cells)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, values := range table {
+			cells := make([]string, len(values))
+			for j, v := range values {
+				cells[j] = truncate(strings.Join(strings.Fields(cell(v)), " "), tableCellWidth)
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("gcrt db query can't write %s output, use json, ndjson, csv, tsv or table", format)
+}
+
+// queryRow is a row of a query result, marshalled as an object with its
+// columns in order
+type queryRow struct {
+	columns []string
+	values  []interface{}
+}
+
+func (r queryRow) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, c := range r.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
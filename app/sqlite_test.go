@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+func TestExportInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	// the results of an earlier run are merged in once the search stops
+	prev := filepath.Join(dir, "previous.json")
+	data, err := json.Marshal([]client.CertResponse{testCert(1, "www.example.com"), testCert(2, "mail.example.com")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(prev, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := fakeCrtsh(t, nil)
+	o := opts
+	o.client = c
+	o.domains = []string{"%.example.com"}
+	o.merge = []string{prev}
+	o.sqlite = filepath.Join(dir, "results.db")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = run(ctx, o, ioutil.Discard)
+	if exitStatus(err) != exitPartial {
+		t.Fatalf("run = %v, exit status %d, want %d", err, exitStatus(err), exitPartial)
+	}
+
+	db, err := openSQLite(o.sqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM certs").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d certs were exported, want 2", n)
+	}
+}
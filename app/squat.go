@@ -0,0 +1,412 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/idna"
+)
+
+// the statuses of a permutation with certs
+const (
+	squatLive    = "live"
+	squatExpired = "expired"
+)
+
+// squatFuzzers generate the permutations of a domain's name, named as
+// dnstwist names them
+var squatFuzzers = map[string]func(name string) []string{
+	"homoglyph":     homoglyphPermutations,
+	"bitsquatting":  bitsquatPermutations,
+	"hyphenation":   hyphenationPermutations,
+	"omission":      omissionPermutations,
+	"repetition":    repetitionPermutations,
+	"transposition": transpositionPermutations,
+	// the TLD swaps keep the name and change the suffix instead
+	"tld-swap": nil,
+}
+
+// defaultSquatTLDs are the suffixes domains are swapped onto, those most
+// registered and most abused
+var defaultSquatTLDs = []string{"com", "net", "org", "co", "io", "info", "biz", "us", "co.uk", "app", "dev", "xyz", "online", "site", "shop", "top", "me", "cc"}
+
+var squatOpts struct {
+	fuzzers  []string
+	tlds     []string
+	liveOnly bool
+}
+
+var squatCmd = &cobra.Command{
+	Use:   "squat",
+	Short: "Find certificates for typosquatting permutations of a domain",
+	Long: `squat generates the permutations of each --domain that typosquatters and
+phishers register, and searches for the certificates of each one. CT logs
+are usually the first sign of phishing infrastructure, as a site needs a
+certificate before it can be used. The permutations with certificates are
+reported, live ones with a currently valid cert first.
+
+The fuzzers are:
+
+  homoglyph      characters swapped for ones that look alike, in ASCII
+                 (examp1e) and as internationalised domains (exаmple)
+  bitsquatting   one bit of a character flipped, as memory errors do
+  tld-swap       the name under another suffix, from --tld
+  hyphenation    a hyphen put between two characters
+  omission       a character left out
+  repetition     a character typed twice
+  transposition  two neighbouring characters swapped
+
+Permutations are searched --batch-size at a time with a --batch-delay
+pause between batches`,
+	Example: `  gcrt squat -d example.com -o table
+  gcrt squat -d example.com --fuzzers homoglyph,tld-swap --live-only`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		o := opts
+		if len(o.permutationsFile) > 0 {
+			return errors.New("--permutations-file can't be used with gcrt squat, which makes its own permutations")
+		}
+		s, err := newSquatSweep(o.domains, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		o.squat = s
+		// only the permutations are searched
+		o.domains, o.identities, o.orgs, o.stdin = nil, nil, nil, false
+
+		out, err := o.openOutput(false)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		runErr := runTraced(ctx, o, out)
+		if err := out.Close(); err != nil && runErr == nil {
+			runErr = err
+		}
+		return runErr
+	},
+}
+
+func init() {
+	squatCmd.Flags().StringSliceVar(&squatOpts.fuzzers, "fuzzers", nil, "The fuzzers to make permutations with (default all): "+squatFuzzerNames())
+	squatCmd.Flags().StringSliceVar(&squatOpts.tlds, "tld", defaultSquatTLDs, "The suffixes tld-swap puts the name under")
+	squatCmd.Flags().BoolVar(&squatOpts.liveOnly, "live-only", false, "Only report permutations with a currently valid cert")
+	cmd.AddCommand(squatCmd)
+}
+
+func squatFuzzerNames() string {
+	names := make([]string, 0, len(squatFuzzers))
+	for n := range squatFuzzers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// squatSweep is the configuration of gcrt squat
+type squatSweep struct {
+	now      time.Time
+	domains  []string
+	perms    []permutation
+	liveOnly bool
+}
+
+func newSquatSweep(domains []string, now time.Time) (*squatSweep, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("give the domains to find the permutations of with --domain")
+	}
+	fuzzers := squatOpts.fuzzers
+	if len(fuzzers) == 0 {
+		for n := range squatFuzzers {
+			fuzzers = append(fuzzers, n)
+		}
+		sort.Strings(fuzzers)
+	}
+	for _, f := range fuzzers {
+		if _, ok := squatFuzzers[f]; !ok {
+			return nil, fmt.Errorf("unknown fuzzer %q, must be one of %s", f, squatFuzzerNames())
+		}
+	}
+
+	s := &squatSweep{now: now, liveOnly: squatOpts.liveOnly}
+	seen := make(map[string]bool)
+	for _, d := range domains {
+		base := registrableDomain(strings.TrimLeft(d, "%."))
+		if seen[base] {
+			continue
+		}
+		seen[base] = true
+		if _, suffix := splitSuffix(base); base == suffix {
+			return nil, fmt.Errorf("%s is a public suffix, give a registrable domain such as example.com", d)
+		}
+		s.domains = append(s.domains, base)
+	}
+	for _, base := range s.domains {
+		for _, p := range squatPermutations(base, fuzzers, squatOpts.tlds) {
+			if !seen[p.Domain] {
+				seen[p.Domain] = true
+				s.perms = append(s.perms, p)
+			}
+		}
+	}
+	if len(s.perms) == 0 {
+		return nil, errors.New("no permutations were made, try more --fuzzers")
+	}
+	return s, nil
+}
+
+// hostLabel is a valid ASCII hostname label
+var hostLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// squatPermutations are the permutations of a registrable domain made by
+// the fuzzers, without the domain itself or any that aren't valid names
+func squatPermutations(base string, fuzzers, tlds []string) []permutation {
+	name, suffix := splitSuffix(base)
+	var perms []permutation
+	for _, f := range fuzzers {
+		if f == "tld-swap" {
+			for _, tld := range tlds {
+				if tld = strings.ToLower(strings.Trim(tld, ". ")); len(tld) > 0 && tld != suffix {
+					perms = append(perms, permutation{Domain: name + "." + tld, Fuzzer: f})
+				}
+			}
+			continue
+		}
+		for _, n := range squatFuzzers[f](name) {
+			ascii, err := idna.ToASCII(n)
+			if err != nil || ascii == name || !hostLabel.MatchString(ascii) {
+				continue
+			}
+			// the third and fourth characters are only -- in punycode
+			if len(ascii) >= 4 && ascii[2:4] == "--" && !strings.HasPrefix(ascii, "xn--") {
+				continue
+			}
+			perms = append(perms, permutation{Domain: ascii + "." + suffix, Fuzzer: f})
+		}
+	}
+	return perms
+}
+
+// asciiHomoglyphs are the ASCII spellings that pass for a character, the
+// reverse of confusables
+var asciiHomoglyphs = map[string][]string{
+	"a": {"4"}, "b": {"8"}, "d": {"cl"}, "e": {"3"}, "g": {"q"}, "i": {"1", "l"}, "l": {"1", "i"},
+	"m": {"rn"}, "o": {"0"}, "q": {"g"}, "s": {"5"}, "t": {"7"}, "w": {"vv"},
+}
+
+// latinHomoglyphs are the other scripts' letters that pass for each Latin
+// one, from homoglyphs
+var latinHomoglyphs = func() map[rune][]rune {
+	m := make(map[rune][]rune)
+	for other, latin := range homoglyphs {
+		m[latin] = append(m[latin], other)
+	}
+	for _, others := range m {
+		sort.Slice(others, func(i, j int) bool { return others[i] < others[j] })
+	}
+	return m
+}()
+
+// homoglyphPermutations replace one character of name with each
+// lookalike
+func homoglyphPermutations(name string) []string {
+	var perms []string
+	runes := []rune(name)
+	for i, r := range runes {
+		for _, h := range asciiHomoglyphs[string(r)] {
+			perms = append(perms, string(runes[:i])+h+string(runes[i+1:]))
+		}
+		for _, h := range latinHomoglyphs[r] {
+			perms = append(perms, string(runes[:i])+string(h)+string(runes[i+1:]))
+		}
+	}
+	return perms
+}
+
+// bitsquatPermutations flip each bit of each character of name, keeping
+// those that are still hostname characters
+func bitsquatPermutations(name string) []string {
+	var perms []string
+	for i := 0; i < len(name); i++ {
+		for bit := uint(0); bit < 8; bit++ {
+			c := name[i] ^ (1 << bit)
+			if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' {
+				perms = append(perms, name[:i]+string(c)+name[i+1:])
+			}
+		}
+	}
+	return perms
+}
+
+func hyphenationPermutations(name string) []string {
+	var perms []string
+	for i := 1; i < len(name); i++ {
+		if name[i-1] != '-' && name[i] != '-' {
+			perms = append(perms, name[:i]+"-"+name[i:])
+		}
+	}
+	return perms
+}
+
+func omissionPermutations(name string) []string {
+	var perms []string
+	for i := 0; i < len(name) && len(name) > 1; i++ {
+		perms = append(perms, name[:i]+name[i+1:])
+	}
+	return perms
+}
+
+func repetitionPermutations(name string) []string {
+	var perms []string
+	for i := 0; i < len(name); i++ {
+		perms = append(perms, name[:i+1]+name[i:])
+	}
+	return perms
+}
+
+func transpositionPermutations(name string) []string {
+	var perms []string
+	for i := 1; i < len(name); i++ {
+		if name[i-1] != name[i] {
+			perms = append(perms, name[:i-1]+string(name[i])+string(name[i-1])+name[i+1:])
+		}
+	}
+	return perms
+}
+
+// SquatResult is what the certs found say about a permutation
+type SquatResult struct {
+	Permutation string `json:"permutation"`
+	Fuzzer      string `json:"fuzzer"`
+	Status      string `json:"status"`
+	CertCount   int    `json:"cert_count"`
+	LiveCount   int    `json:"live_count"`
+	// FirstSeen is the earliest entry timestamp of the permutation's
+	// certs and LastIssued the latest not before date
+	FirstSeen  string   `json:"first_seen"`
+	LastIssued string   `json:"last_issued"`
+	Issuers    []string `json:"issuers"`
+	Certs      []int    `json:"certs"`
+
+	firstSeen, lastIssued time.Time
+}
+
+// squatReport is the gcrt squat output
+type squatReport struct {
+	GeneratedAt  time.Time     `json:"generated_at"`
+	Domains      []string      `json:"domains"`
+	Permutations int           `json:"permutations"`
+	WithCerts    int           `json:"with_certs"`
+	Live         int           `json:"live"`
+	Results      []SquatResult `json:"results"`
+}
+
+// results groups the certs found by the permutation that found them, live
+// ones first and then by the number of certs
+func (s *squatSweep) results(records []record) []SquatResult {
+	bySource := make(map[string][]record)
+	for _, r := range records {
+		bySource[r.SourceDomain] = append(bySource[r.SourceDomain], r)
+	}
+
+	results := make([]SquatResult, 0)
+	for _, p := range s.perms {
+		certs, ok := bySource[p.Domain]
+		if !ok {
+			continue
+		}
+		res := SquatResult{Permutation: p.Domain, Fuzzer: p.Fuzzer, CertCount: len(certs), Issuers: []string{}}
+		issuers := make(map[string]bool)
+		for _, r := range certs {
+			res.Certs = append(res.Certs, r.ID)
+			if entered, err := r.EntryTime(); err == nil && (res.firstSeen.IsZero() || entered.Before(res.firstSeen)) {
+				res.firstSeen = entered
+				res.FirstSeen = r.EntryTimestamp
+			}
+			notBefore, err := r.NotBeforeTime()
+			if err != nil {
+				continue
+			}
+			if notBefore.After(res.lastIssued) {
+				res.lastIssued = notBefore
+				res.LastIssued = r.NotBefore
+			}
+			if notAfter, err := r.NotAfterTime(); err == nil && !s.now.Before(notBefore) && s.now.Before(notAfter) {
+				res.LiveCount++
+			}
+			if org := issuerOrg(r.IssuerName); !issuers[org] {
+				issuers[org] = true
+				res.Issuers = append(res.Issuers, org)
+			}
+		}
+		sort.Ints(res.Certs)
+		sort.Strings(res.Issuers)
+		res.Status = squatExpired
+		if res.LiveCount > 0 {
+			res.Status = squatLive
+		} else if s.liveOnly {
+			continue
+		}
+		results = append(results, res)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].LiveCount > 0) != (results[j].LiveCount > 0) {
+			return results[i].LiveCount > 0
+		}
+		if results[i].CertCount != results[j].CertCount {
+			return results[i].CertCount > results[j].CertCount
+		}
+		return results[i].Permutation < results[j].Permutation
+	})
+	return results
+}
+
+// writeSquat writes the permutations the search found certs for
+func (o options) writeSquat(w io.Writer, records []record) error {
+	s := o.squat
+	results := s.results(records)
+	report := squatReport{GeneratedAt: s.now, Domains: s.domains, Permutations: len(s.perms), Results: results}
+	for _, r := range results {
+		report.WithCerts++
+		if r.LiveCount > 0 {
+			report.Live++
+		}
+	}
+	if o.count {
+		fmt.Fprintf(w, "Number of permutations with certs found: %d\n", len(results))
+		return nil
+	}
+	return writeSquatReport(w, o.output, report)
+}
+
+// writeSquatReport writes the report as JSON, one row per permutation for
+// the tabular formats
+func writeSquatReport(w io.Writer, format string, report squatReport) error {
+	header := []string{"permutation", "fuzzer", "status", "cert_count", "live_count", "first_seen", "last_issued", "issuers"}
+	rows := make([][]string, len(report.Results))
+	for i, r := range report.Results {
+		rows[i] = []string{r.Permutation, r.Fuzzer, r.Status, strconv.Itoa(r.CertCount), strconv.Itoa(r.LiveCount), r.FirstSeen, r.LastIssued, strings.Join(r.Issuers, ", ")}
+	}
+
+	switch format {
+	case "csv", "tsv", "table", "markdown":
+		return writeSummaryRows(w, format, header, rows)
+	default:
+		output, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	}
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+// stixNamespace is the namespace STIX 2.1 derives the UUIDv5 ids of cyber
+// observables from, so the same cert or domain always has the same id
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// formatUUID writes a UUID in its usual hyphenated form
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// uuid5 is the version 5 UUID of name in namespace
+func uuid5(namespace [16]byte, name []byte) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write(name)
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+// uuid4 is a random UUID
+func uuid4() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u), nil
+}
+
+// stixID is the deterministic id of an observable from the JSON of its id
+// contributing properties
+func stixID(kind string, contributing interface{}) string {
+	data, _ := json.Marshal(contributing)
+	return kind + "--" + uuid5(stixNamespace, data)
+}
+
+// stixBundle is a STIX 2.1 bundle
+type stixBundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+}
+
+// stixCertificate is an x509-certificate cyber observable
+type stixCertificate struct {
+	Type               string            `json:"type"`
+	SpecVersion        string            `json:"spec_version"`
+	ID                 string            `json:"id"`
+	IsSelfSigned       bool              `json:"is_self_signed,omitempty"`
+	Hashes             map[string]string `json:"hashes,omitempty"`
+	SerialNumber       string            `json:"serial_number,omitempty"`
+	SignatureAlgorithm string            `json:"signature_algorithm,omitempty"`
+	Issuer             string            `json:"issuer,omitempty"`
+	ValidityNotBefore  string            `json:"validity_not_before,omitempty"`
+	ValidityNotAfter   string            `json:"validity_not_after,omitempty"`
+	Subject            string            `json:"subject,omitempty"`
+	PublicKeyAlgorithm string            `json:"subject_public_key_algorithm,omitempty"`
+	Extensions         *stixExtensions   `json:"x509_v3_extensions,omitempty"`
+}
+
+type stixExtensions struct {
+	SubjectAlternativeName string `json:"subject_alternative_name,omitempty"`
+}
+
+// stixDomain is a domain-name cyber observable
+type stixDomain struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+	Value       string `json:"value"`
+}
+
+// stixTime converts a crt.sh timestamp to the UTC form STIX requires
+func stixTime(ts string) string {
+	if len(ts) == 0 {
+		return ""
+	}
+	t, err := client.ParseTime(ts)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// sha256Of is the SHA-256 fingerprint of a record's cert, when it's known
+func sha256Of(r record) string {
+	if len(r.SHA256Fingerprint) > 0 {
+		return strings.ToLower(strings.Replace(r.SHA256Fingerprint, ":", "", -1))
+	}
+	return strings.ToLower(r.SHA256)
+}
+
+// domainNames are the distinct hostnames on the records, with any wildcard
+// prefix dropped as domain names can't have one
+func domainNames(records []record) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, r := range records {
+		for _, n := range r.Names() {
+			n = strings.TrimPrefix(n, "*.")
+			if strings.ContainsAny(n, "@ *") || seen[n] {
+				continue
+			}
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeSTIX writes records as a STIX 2.1 bundle of an x509-certificate for
+// each cert and a domain-name for each name on them, for threat intel
+// platforms to ingest
+func writeSTIX(w io.Writer, records []record, fields []string) error {
+	if len(fields) > 0 {
+		return errors.New("--fields can't be used with the stix output format")
+	}
+	id, err := uuid4()
+	if err != nil {
+		return err
+	}
+	bundle := stixBundle{Type: "bundle", ID: "bundle--" + id, Objects: make([]interface{}, 0)}
+
+	for _, r := range records {
+		c := stixCertificate{
+			Type:              "x509-certificate",
+			SpecVersion:       "2.1",
+			SerialNumber:      r.SerialNumber,
+			Issuer:            r.IssuerName,
+			ValidityNotBefore: stixTime(r.NotBefore),
+			ValidityNotAfter:  stixTime(r.NotAfter),
+		}
+		if len(r.CommonName) > 0 {
+			c.Subject = "CN=" + r.CommonName
+		}
+		if fp := sha256Of(r); len(fp) > 0 {
+			c.Hashes = map[string]string{"SHA-256": fp}
+		}
+		if r.cert != nil {
+			c.Subject = r.cert.Subject.String()
+			c.SignatureAlgorithm = r.cert.SignatureAlgorithm.String()
+			c.PublicKeyAlgorithm = r.cert.PublicKeyAlgorithm.String()
+			c.IsSelfSigned = selfSigned(r.cert)
+		}
+		var sans []string
+		for _, n := range r.Names() {
+			if strings.Contains(n, "@") {
+				sans = append(sans, "email:"+n)
+				continue
+			}
+			sans = append(sans, "DNS:"+n)
+		}
+		if len(sans) > 0 {
+			c.Extensions = &stixExtensions{SubjectAlternativeName: strings.Join(sans, ", ")}
+		}
+		c.ID = stixID(c.Type, struct {
+			Hashes       map[string]string `json:"hashes,omitempty"`
+			SerialNumber string            `json:"serial_number,omitempty"`
+		}{c.Hashes, c.SerialNumber})
+		bundle.Objects = append(bundle.Objects, c)
+	}
+	for _, n := range domainNames(records) {
+		id := stixID("domain-name", struct {
+			Value string `json:"value"`
+		}{n})
+		bundle.Objects = append(bundle.Objects, stixDomain{Type: "domain-name", SpecVersion: "2.1", ID: id, Value: n})
+	}
+
+	output, err := json.MarshalIndent(bundle, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(output))
+	return nil
+}
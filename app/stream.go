@@ -0,0 +1,189 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+	"github.com/jhinds/gcrt/tracing"
+)
+
+// streamBatchSize is how many certs are collected before they're enriched
+// and written, so enrichments still look up many at once
+const streamBatchSize = 100
+
+// streams reports whether the run's results can be written as they're
+// found: ndjson output of the certs themselves, from plain searches
+func (o options) streams() bool {
+	return o.output == "ndjson" && !o.count && !o.aggregate && !o.namesOnly && !o.groupRegistrable && !o.wildcardSummary &&
+		!o.liveness && !o.stalenessReport && len(o.permutationsFile) == 0 && len(o.merge) == 0 && len(o.diffAgainst) == 0 &&
+		o.sample == 0 && !o.shard && len(o.sqlite) == 0 && o.onlyCrtsh() &&
+		len(o.sortField) == 0 && !o.reverse && o.expiry == nil && !o.summary && o.analysis == nil && o.squat == nil && o.timeline == nil && !o.strict &&
+		len(o.template) == 0
+}
+
+// streamer writes records as ndjson as each domain's search returns them,
+// filtering and enriching them a batch at a time
+type streamer struct {
+	o        options
+	p        *pipeline
+	rd       *redactor
+	annotate bool
+	dedupe   bool
+
+	mu   sync.Mutex
+	enc  *json.Encoder
+	seen map[string]struct{}
+}
+
+// streamAll is searchAll for the streaming ndjson output
+func streamAll(ctx context.Context, c *client.Client, q client.Query, domains []string, o options, p *pipeline, rd *redactor, w io.Writer) error {
+	concurrency := o.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if o.backoff != nil {
+		if domains = o.backoff.plan(domains, time.Now().UTC()); len(domains) == 0 {
+			return nil
+		}
+	}
+
+	s := &streamer{
+		o:        o,
+		p:        p,
+		rd:       rd,
+		annotate: len(domains) > 1,
+		dedupe:   q.Dedupe != client.DedupeNone,
+		enc:      json.NewEncoder(w),
+		seen:     make(map[string]struct{}),
+	}
+
+	errs := make([]error, len(domains))
+	o.progress.expect(len(domains))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, d := range domains {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, d string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			sctx, span := tracing.StartKind(ctx, "crtsh.search", tracing.KindClient)
+			span.SetAttribute("gcrt.domain", d)
+			n := 0
+			started := time.Now()
+			dq := targetQuery(q, d)
+			errs[i] = s.search(sctx, c, dq, d, &n)
+			span.SetError(errs[i])
+			span.SetAttribute("gcrt.results", n)
+			span.End()
+			o.progress.searched(dq.Domain)
+			log.Debugf("searched %s in %s, found %d certs", d, time.Since(started).Round(time.Millisecond), n)
+
+			// failures caused by the run stopping aren't the domain's fault
+			if o.backoff != nil && ctx.Err() == nil {
+				o.backoff.record(d, errs[i], time.Now().UTC())
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, d := range domains {
+		var partial *client.PartialError
+		if errors.As(errs[i], &partial) {
+			log.WithError(errs[i]).Warnf("only part of the results for %s could be read", d)
+			o.outcome.cutOff()
+			errs[i] = nil
+		}
+		if errs[i] != nil && ctx.Err() == nil {
+			log.WithError(errs[i]).Errorf("error querying %s", d)
+			failed++
+			o.outcome.searched(errs[i])
+		} else {
+			o.outcome.searched(nil)
+		}
+	}
+	if failed == len(domains) {
+		return fmt.Errorf("error getting response: %w", errs[0])
+	}
+	return nil
+}
+
+// search streams the certs found for a single domain, counting them in n
+func (s *streamer) search(ctx context.Context, c *client.Client, q client.Query, domain string, n *int) error {
+	// without enrichments there's nothing to gain from waiting for a batch
+	size := streamBatchSize
+	if len(s.p.stages) == 0 {
+		size = 1
+	}
+
+	var batch []record
+	var writeErr error
+	err := c.Each(ctx, q, func(cert client.CertResponse) bool {
+		*n++
+		r := record{CertResponse: cert}
+		if s.annotate {
+			r.SourceDomain = domain
+		}
+		if batch = append(batch, r); len(batch) >= size {
+			writeErr = s.write(ctx, batch)
+			batch = nil
+		}
+		return writeErr == nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	// what was found before the run stopped is still written
+	if flushErr := s.write(ctx, batch); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+// write filters, enriches and redacts a batch and writes what's left. Certs
+// already written for another domain are skipped
+func (s *streamer) write(ctx context.Context, batch []record) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	records, err := s.o.filter(batch)
+	if err != nil {
+		return err
+	}
+	if records, err = s.p.run(ctx, records); err != nil {
+		return err
+	}
+	s.rd.apply(records)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range records {
+		// certs from other sources have no crt.sh id to tell them apart
+		key := certKey(r.CertResponse)
+		if _, ok := s.seen[key]; ok && s.dedupe {
+			continue
+		}
+		s.seen[key] = struct{}{}
+
+		var v interface{} = r
+		if len(s.o.fields) > 0 {
+			v = projection{r, s.o.fields}
+		}
+		if err := s.enc.Encode(v); err != nil {
+			return err
+		}
+		s.o.outcome.add(1)
+	}
+	return nil
+}
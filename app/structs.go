@@ -1,14 +1,127 @@
 package app
 
-// CertResponse represents a certificate response object
-type CertResponse struct {
-	IssuerCAID     int64  `json:"issuer_ca_id"`
-	IssuerName     string `json:"issuer_name"`
-	CommonName     string `json:"common_name"`
-	NameValue      string `json:"name_value"`
-	ID             int    `json:"id"`
-	EntryTimestamp string `json:"entry_timestamp"`
-	NotBefore      string `json:"not_before"`
-	NotAfter       string `json:"not_after"`
-	SerialNumber   string `json:"serial_number"`
+import (
+	"crypto/x509"
+	"encoding/json"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+// record is a certificate returned by crt.sh along with anything gcrt has
+// learned about it since
+type record struct {
+	client.CertResponse
+	enrichment
+
+	cert *x509.Certificate
+}
+
+// enrichment holds the fields gcrt adds to each crt.sh result
+type enrichment struct {
+	// the --domain that found the cert, when more than one was queried, or
+	// O=<name> for an --org
+	SourceDomain string `json:"source_domain,omitempty"`
+
+	// the title of the crt.sh feed entry, for certs found by watch --feed
+	FeedTitle string `json:"feed_title,omitempty"`
+
+	// set for certs found by gcrt stream, which crt.sh may not have yet
+	SHA1Fingerprint string `json:"sha1_fingerprint,omitempty"`
+
+	// set by --precerts-only or --enrich precerts, and by gcrt stream
+	EntryType string `json:"entry_type,omitempty"`
+
+	// set when the full certificate has been downloaded
+	ExtKeyUsage     []string `json:"ext_key_usage,omitempty"`
+	CertTypes       []string `json:"cert_types,omitempty"`
+	ValidationLevel string   `json:"validation_level,omitempty"`
+
+	// set by --enrich x509
+	SANs               []string `json:"sans,omitempty"`
+	KeyAlgorithm       string   `json:"key_algorithm,omitempty"`
+	KeySize            int      `json:"key_size,omitempty"`
+	SignatureAlgorithm string   `json:"signature_algorithm,omitempty"`
+	SHA256Fingerprint  string   `json:"sha256_fingerprint,omitempty"`
+	ValidityDays       int      `json:"validity_days,omitempty"`
+
+	// set by --resolve or --enrich resolve
+	DNS []DNSResult `json:"dns,omitempty"`
+
+	// set by --fetch-issuers
+	IssuerChain []IssuerCert `json:"issuer_chain,omitempty"`
+	IssuerError string       `json:"issuer_error,omitempty"`
+	Trust       string       `json:"trust,omitempty"`
+	TrustedBy   []string     `json:"trusted_by,omitempty"`
+	UntrustedBy []string     `json:"untrusted_by,omitempty"`
+
+	// set by --log-entries
+	LogEntries      []client.LogEntry `json:"log_entries,omitempty"`
+	LogOperators    []string          `json:"log_operators,omitempty"`
+	IssuerCertIDs   []int             `json:"issuer_cert_ids,omitempty"`
+	LogEntriesError string            `json:"log_entries_error,omitempty"`
+
+	// set by --check-revocation
+	RevocationStatus string `json:"revocation_status,omitempty"`
+	RevokedAt        string `json:"revoked_at,omitempty"`
+	RevocationReason string `json:"revocation_reason,omitempty"`
+	RevocationSource string `json:"revocation_source,omitempty"`
+	RevocationError  string `json:"revocation_error,omitempty"`
+
+	// set by --check-caa
+	CAAStatus            string   `json:"caa_status,omitempty"`
+	CAAUnauthorizedNames []string `json:"caa_unauthorized_names,omitempty"`
+	CAAError             string   `json:"caa_error,omitempty"`
+
+	// set by --enrich probe, whois and geoip
+	HTTP  []ProbeResult `json:"http,omitempty"`
+	Whois []WhoisResult `json:"whois,omitempty"`
+	GeoIP []GeoIPResult `json:"geoip,omitempty"`
+
+	// set by --download-certs
+	PEMFile string `json:"pem_file,omitempty"`
+
+	// set by --lint
+	Lint []LintFinding `json:"lint,omitempty"`
+
+	// set when scoring is enabled
+	Severity      string   `json:"severity,omitempty"`
+	SeverityScore *int     `json:"severity_score,omitempty"`
+	Findings      []string `json:"findings,omitempty"`
+}
+
+// Link is the crt.sh page for the cert. Certs from gcrt stream and other
+// sources have no crt.sh id, so they're looked up by fingerprint
+func (r record) Link() string {
+	switch {
+	case r.ID != 0:
+	case len(r.SHA256Fingerprint) > 0:
+		return "https://crt.sh/?sha256=" + r.SHA256Fingerprint
+	case len(r.SHA1Fingerprint) > 0:
+		return "https://crt.sh/?sha1=" + r.SHA1Fingerprint
+	}
+	return r.CertResponse.Link()
+}
+
+type certFields client.CertResponse
+
+// MarshalJSON outputs the crt.sh link and response fields followed by the
+// enrichment
+func (r record) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		CertShLink string `json:"crt_sh_link"`
+		certFields
+		enrichment
+	}{
+		CertShLink: r.Link(),
+		certFields: certFields(r.CertResponse),
+		enrichment: r.enrichment,
+	})
+}
+
+func newRecords(certs []client.CertResponse) []record {
+	records := make([]record, len(certs))
+	for i, c := range certs {
+		records[i].CertResponse = c
+	}
+	return records
 }
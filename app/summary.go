@@ -0,0 +1,171 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// the validity statuses of a hostname
+const (
+	hostValid   = "valid"
+	hostExpired = "expired"
+)
+
+var summaryCmd = &cobra.Command{
+	Use:   "summary",
+	Short: "Summarise the certificates found for each hostname",
+	Long: `summary runs a search like gcrt does and outputs one line per hostname
+instead of the certificates: when it was first seen, when it was last issued
+a cert, the issuers of its currently valid certs, how many certs it has had
+and whether any of them is still valid`,
+	Example: `  gcrt summary -d %.example.com -o table
+  gcrt summary -d %.example.com -o csv --out-file hosts.csv`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		o := opts
+		o.summary = true
+
+		out, err := o.openOutput(false)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		runErr := runTraced(ctx, o, out)
+		if err := out.Close(); err != nil && runErr == nil {
+			runErr = err
+		}
+		return runErr
+	},
+}
+
+func init() {
+	cmd.AddCommand(summaryCmd)
+}
+
+// HostSummary is what the certs found say about a hostname
+type HostSummary struct {
+	Name              string `json:"name"`
+	RegistrableDomain string `json:"registrable_domain"`
+	Status            string `json:"status"`
+	// FirstSeen is the earliest entry timestamp of the hostname's certs and
+	// LastIssued the latest not before date
+	FirstSeen      string   `json:"first_seen"`
+	LastIssued     string   `json:"last_issued"`
+	ValidUntil     string   `json:"valid_until,omitempty"`
+	CurrentIssuers []string `json:"current_issuers"`
+	CertCount      int      `json:"cert_count"`
+	ValidCount     int      `json:"valid_count"`
+
+	firstSeen, lastIssued, validUntil time.Time
+}
+
+// summariseHosts groups the certs by each hostname they cover. Wildcard
+// names are kept as they are, as they don't cover the domain itself
+func summariseHosts(records []record, now time.Time) []HostSummary {
+	byName := make(map[string]*HostSummary)
+	issuers := make(map[string]map[string]bool)
+
+	for _, r := range records {
+		entered, _ := r.EntryTime()
+		notBefore, err := r.NotBeforeTime()
+		if err != nil {
+			continue
+		}
+		notAfter, err := r.NotAfterTime()
+		if err != nil {
+			continue
+		}
+		valid := !now.Before(notBefore) && now.Before(notAfter)
+
+		for _, n := range r.Names() {
+			if strings.ContainsAny(n, "@ ") {
+				continue
+			}
+			h, ok := byName[n]
+			if !ok {
+				h = &HostSummary{Name: n, RegistrableDomain: registrableDomain(n), CurrentIssuers: []string{}}
+				byName[n] = h
+				issuers[n] = make(map[string]bool)
+			}
+			h.CertCount++
+
+			if !entered.IsZero() && (h.firstSeen.IsZero() || entered.Before(h.firstSeen)) {
+				h.firstSeen = entered
+				h.FirstSeen = r.EntryTimestamp
+			}
+			if notBefore.After(h.lastIssued) {
+				h.lastIssued = notBefore
+				h.LastIssued = r.NotBefore
+			}
+			if !valid {
+				continue
+			}
+			h.ValidCount++
+			if notAfter.After(h.validUntil) {
+				h.validUntil = notAfter
+				h.ValidUntil = r.NotAfter
+			}
+			if !issuers[n][r.IssuerName] {
+				issuers[n][r.IssuerName] = true
+				h.CurrentIssuers = append(h.CurrentIssuers, r.IssuerName)
+			}
+		}
+	}
+
+	summaries := make([]HostSummary, 0, len(byName))
+	for _, h := range byName {
+		h.Status = hostExpired
+		if h.ValidCount > 0 {
+			h.Status = hostValid
+		}
+		sort.Strings(h.CurrentIssuers)
+		summaries = append(summaries, *h)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// writeSummary writes the summary of each hostname on the certs found
+func (o options) writeSummary(w io.Writer, records []record) error {
+	summaries := summariseHosts(records, time.Now().UTC())
+	if o.count {
+		fmt.Fprintf(w, "Number of hostnames found: %d\n", len(summaries))
+		return nil
+	}
+	return writeHostSummaries(w, o.output, summaries)
+}
+
+// writeHostSummaries writes the summaries as JSON, or one row per hostname
+// for the tabular formats
+func writeHostSummaries(w io.Writer, format string, summaries []HostSummary) error {
+	header := []string{"name", "registrable_domain", "status", "first_seen", "last_issued", "valid_until", "current_issuers", "cert_count", "valid_count"}
+	rows := make([][]string, len(summaries))
+	for i, h := range summaries {
+		rows[i] = []string{
+			h.Name, h.RegistrableDomain, h.Status, h.FirstSeen, h.LastIssued, h.ValidUntil,
+			strings.Join(h.CurrentIssuers, "; "), strconv.Itoa(h.CertCount), strconv.Itoa(h.ValidCount),
+		}
+	}
+
+	switch format {
+	case "csv", "tsv", "table", "markdown":
+		return writeSummaryRows(w, format, header, rows)
+	default:
+		output, err := json.MarshalIndent(&summaries, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// tableCellWidth is the widest a table cell gets before it's truncated
+const tableCellWidth = 48
+
+// defaultTableColumns are the columns shown by the table and markdown
+// formats when --fields isn't given, along with any extraColumns in use
+var defaultTableColumns = []string{"id", "not_before", "not_after", "common_name", "name_value", "issuer_name"}
+
+func tableColumns(records []record, fields []string) []string {
+	if len(fields) > 0 {
+		return fields
+	}
+	return withExtraColumns(defaultTableColumns, records)
+}
+
+// cellValue is a field flattened onto one line, with the names in
+// name_value separated by commas
+func cellValue(r record, field string) string {
+	v, _ := fieldValue(r, field)
+	return strings.Join(strings.Fields(strings.ReplaceAll(v, "\n", ", ")), " ")
+}
+
+func truncate(s string, width int) string {
+	if r := []rune(s); len(r) > width {
+		return string(r[:width-3]) + "..."
+	}
+	return s
+}
+
+// writeTable writes records as aligned columns for reading in a terminal.
+// Long values are truncated
+func writeTable(w io.Writer, records []record, fields []string) error {
+	columns := tableColumns(records, fields)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = strings.ToUpper(col)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, r := range records {
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			cells[i] = truncate(cellValue(r, col), tableCellWidth)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+var markdownEscaper = strings.NewReplacer(`|`, `\|`, `*`, `\*`, `_`, `\_`, "`", "\\`")
+
+// writeMarkdown writes records as a markdown table for pasting into
+// tickets and reports. Values are never truncated
+func writeMarkdown(w io.Writer, records []record, fields []string) error {
+	columns := tableColumns(records, fields)
+
+	rule := make([]string, len(columns))
+	for i := range columns {
+		rule[i] = "---"
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(columns, " | "))
+	fmt.Fprintf(w, "| %s |\n", strings.Join(rule, " | "))
+
+	for _, r := range records {
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			cells[i] = markdownEscaper.Replace(cellValue(r, col))
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSummaryRows writes the rows of a report other than the certs
+// themselves as csv, tsv, a table or markdown
+func writeSummaryRows(w io.Writer, format string, header []string, rows [][]string) error {
+	switch format {
+	case "csv", "tsv":
+		return writeRows(w, delimiter(format), header, rows)
+	case "markdown":
+		rule := make([]string, len(header))
+		for i := range header {
+			rule[i] = "---"
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+		fmt.Fprintf(w, "| %s |\n", strings.Join(rule, " | "))
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, v := range row {
+				cells[i] = markdownEscaper.Replace(v)
+			}
+			if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, v := range row {
+				cells[i] = truncate(v, tableCellWidth)
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+		return tw.Flush()
+	}
+}
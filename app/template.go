@@ -0,0 +1,135 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+// templateFuncs are the helpers available to --template on top of the
+// text/template builtins
+var templateFuncs = template.FuncMap{
+	"time":        templateTime,
+	"date":        templateDate,
+	"daysLeft":    templateDaysLeft,
+	"field":       templateField,
+	"split":       templateSplit,
+	"join":        func(sep string, s []string) string { return strings.Join(s, sep) },
+	"first":       templateFirst,
+	"lower":       strings.ToLower,
+	"upper":       strings.ToUpper,
+	"trim":        strings.TrimSpace,
+	"replace":     func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"registrable": registrableDomain,
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// parseTemplate parses --template, so mistakes are reported before
+// searching rather than after
+func (o options) parseTemplate() (*template.Template, error) {
+	if len(o.template) == 0 {
+		return nil, nil
+	}
+	if len(o.fields) > 0 {
+		return nil, errors.New("--fields can't be used with --template, the template picks what's output")
+	}
+	t, err := template.New("template").Funcs(templateFuncs).Parse(o.template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --template: %s", err)
+	}
+	return t, nil
+}
+
+// writeTemplate executes the template once per record, ending each with a
+// newline unless the template already does
+func writeTemplate(w io.Writer, t *template.Template, records []record) error {
+	var b strings.Builder
+	for _, r := range records {
+		b.Reset()
+		if err := t.Execute(&b, r); err != nil {
+			return fmt.Errorf("executing --template for cert %d: %s", r.ID, err)
+		}
+		line := b.String()
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateTime parses a crt.sh timestamp, such as .NotAfter, or an RFC 3339
+// one. Times are passed through
+func templateTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		parsed, err := client.ParseTime(t)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("can't parse %q as a time", t)
+		}
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("can't use %T as a time", v)
+}
+
+// templateDate formats a timestamp with a Go time layout e.g.
+// {{date "2006-01-02" .NotAfter}}
+func templateDate(layout string, v interface{}) (string, error) {
+	t, err := templateTime(v)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+// templateDaysLeft is the number of whole days until a timestamp, negative
+// once it's passed
+func templateDaysLeft(v interface{}) (int, error) {
+	t, err := templateTime(v)
+	if err != nil {
+		return 0, err
+	}
+	return int(time.Until(t).Hours() / 24), nil
+}
+
+// templateField is a field by its output name, as it's written in the csv
+// format e.g. {{field . "sans"}}
+func templateField(r record, name string) (string, error) {
+	if !knownFields[name] {
+		return "", fmt.Errorf("unknown field %q, must be one of %s", name, knownFieldNames())
+	}
+	v, _ := fieldValue(r, name)
+	return v, nil
+}
+
+// templateSplit splits s on sep, dropping empty parts, so
+// {{split "\n" .NameValue}} is the names on a cert
+func templateSplit(sep, s string) []string {
+	var parts []string
+	for _, p := range strings.Split(s, sep) {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+func templateFirst(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
@@ -0,0 +1,268 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// timelineIntervals are the lengths of the periods issuance can be
+// bucketed by
+var timelineIntervals = []string{"day", "week", "month", "quarter", "year"}
+
+var timelineOpts struct {
+	interval string
+	width    int
+}
+
+var timelineCmd = &cobra.Command{
+	Use:   "timeline",
+	Short: "Chart how many certificates were issued over time",
+	Long: `timeline runs a search like gcrt does and counts the certificates issued
+in each --interval, by their not before date, from the first to the last.
+Periods without any issuance are kept so gaps show. Each period also counts
+the new names, those on a cert for the first time, so it shows when a domain
+started getting certs and when its issuance changed.
+
+The series is JSON, one row per period with -o csv, tsv or markdown, and a
+bar chart with -o table`,
+	Example: `  gcrt timeline -d example.com -o table
+  gcrt timeline -d %.example.com --interval week --between 2024-01-01:`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := newTimeline(timelineOpts.interval, timelineOpts.width, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		o := opts
+		o.timeline = t
+
+		out, err := o.openOutput(false)
+		if err != nil {
+			return err
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		runErr := runTraced(ctx, o, out)
+		if err := out.Close(); err != nil && runErr == nil {
+			runErr = err
+		}
+		return runErr
+	},
+}
+
+func init() {
+	timelineCmd.Flags().StringVar(&timelineOpts.interval, "interval", "month", "The period to count issuance by: "+strings.Join(timelineIntervals, ", "))
+	timelineCmd.Flags().IntVar(&timelineOpts.width, "width", 40, "The width of the longest bar of the -o table chart")
+	cmd.AddCommand(timelineCmd)
+}
+
+// timeline is the configuration of gcrt timeline
+type timeline struct {
+	now      time.Time
+	interval string
+	width    int
+}
+
+func newTimeline(interval string, width int, now time.Time) (*timeline, error) {
+	valid := false
+	for _, i := range timelineIntervals {
+		valid = valid || i == interval
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid --interval %q, must be one of %s", interval, strings.Join(timelineIntervals, ", "))
+	}
+	if width < 1 {
+		return nil, fmt.Errorf("invalid --width %d, must be at least 1", width)
+	}
+	return &timeline{now: now, interval: interval, width: width}, nil
+}
+
+// TimelinePeriod is the issuance of one interval
+type TimelinePeriod struct {
+	Period string `json:"period"`
+	// Start is the first day of the period and End the first after it
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Count    int    `json:"count"`
+	NewNames int    `json:"new_names"`
+}
+
+// timelineReport is the gcrt timeline output
+type timelineReport struct {
+	GeneratedAt time.Time        `json:"generated_at"`
+	Interval    string           `json:"interval"`
+	Certs       int              `json:"certs"`
+	FirstIssued string           `json:"first_issued,omitempty"`
+	LastIssued  string           `json:"last_issued,omitempty"`
+	Periods     []TimelinePeriod `json:"periods"`
+}
+
+// start is the beginning of the period t falls in. Weeks start on Monday,
+// as ISO 8601 weeks do
+func (tl *timeline) start(t time.Time) time.Time {
+	t = t.UTC()
+	y, m, d := t.Date()
+	switch tl.interval {
+	case "day":
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	case "week":
+		// days since Monday
+		back := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-back, 0, 0, 0, 0, time.UTC)
+	case "quarter":
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, time.UTC)
+	case "year":
+		return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// next is the start of the period after the one starting at start
+func (tl *timeline) next(start time.Time) time.Time {
+	switch tl.interval {
+	case "day":
+		return start.AddDate(0, 0, 1)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "quarter":
+		return start.AddDate(0, 3, 0)
+	case "year":
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// label names the period starting at start, e.g. 2024-03, 2024-W11 or
+// 2024-Q1
+func (tl *timeline) label(start time.Time) string {
+	switch tl.interval {
+	case "day":
+		return start.Format("2006-01-02")
+	case "week":
+		y, w := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	case "quarter":
+		return fmt.Sprintf("%d-Q%d", start.Year(), (int(start.Month())+2)/3)
+	case "year":
+		return strconv.Itoa(start.Year())
+	default:
+		return start.Format("2006-01")
+	}
+}
+
+// report buckets the certs by the period they were issued in, with every
+// period from the first issuance to the last
+func (tl *timeline) report(records []record) timelineReport {
+	report := timelineReport{GeneratedAt: tl.now, Interval: tl.interval, Periods: make([]TimelinePeriod, 0)}
+
+	type issued struct {
+		at    time.Time
+		names []string
+	}
+	var certs []issued
+	for _, r := range records {
+		notBefore, err := r.NotBeforeTime()
+		if err != nil {
+			continue
+		}
+		certs = append(certs, issued{notBefore, r.Names()})
+	}
+	if len(certs) == 0 {
+		return report
+	}
+	// names are new in the period of the earliest cert they're on
+	sort.SliceStable(certs, func(i, j int) bool { return certs[i].at.Before(certs[j].at) })
+	first, last := certs[0].at, certs[len(certs)-1].at
+	report.Certs = len(certs)
+	report.FirstIssued = first.Format(time.RFC3339)
+	report.LastIssued = last.Format(time.RFC3339)
+
+	index := make(map[time.Time]int)
+	for start := tl.start(first); !start.After(last); start = tl.next(start) {
+		index[start] = len(report.Periods)
+		report.Periods = append(report.Periods, TimelinePeriod{
+			Period: tl.label(start),
+			Start:  start.Format("2006-01-02"),
+			End:    tl.next(start).Format("2006-01-02"),
+		})
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range certs {
+		p := &report.Periods[index[tl.start(c.at)]]
+		p.Count++
+		for _, n := range c.names {
+			if n = strings.ToLower(n); !seen[n] {
+				seen[n] = true
+				p.NewNames++
+			}
+		}
+	}
+	return report
+}
+
+func (o options) writeTimeline(w io.Writer, records []record) error {
+	report := o.timeline.report(records)
+	if o.count {
+		fmt.Fprintf(w, "Number of certs found: %d\n", report.Certs)
+		return nil
+	}
+	return writeTimelineReport(w, o.output, o.timeline.width, report)
+}
+
+// writeTimelineReport writes the report as JSON, one row per period for
+// the tabular formats, or a bar chart for a terminal with -o table
+func writeTimelineReport(w io.Writer, format string, width int, report timelineReport) error {
+	switch format {
+	case "table":
+		return writeTimelineChart(w, width, report)
+	case "csv", "tsv", "markdown":
+		header := []string{"period", "start", "end", "count", "new_names"}
+		rows := make([][]string, len(report.Periods))
+		for i, p := range report.Periods {
+			rows[i] = []string{p.Period, p.Start, p.End, strconv.Itoa(p.Count), strconv.Itoa(p.NewNames)}
+		}
+		return writeSummaryRows(w, format, header, rows)
+	default:
+		output, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	}
+}
+
+// writeTimelineChart draws a bar for each period scaled to the busiest,
+// which is width characters long. Any issuance gets at least one
+func writeTimelineChart(w io.Writer, width int, report timelineReport) error {
+	busiest := 0
+	for _, p := range report.Periods {
+		if p.Count > busiest {
+			busiest = p.Count
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD\tCERTS\tNEW NAMES\t")
+	for _, p := range report.Periods {
+		bar := 0
+		if p.Count > 0 {
+			bar = p.Count * width / busiest
+			if bar == 0 {
+				bar = 1
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", p.Period, p.Count, p.NewNames, strings.Repeat("█", bar))
+	}
+	return tw.Flush()
+}
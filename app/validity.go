@@ -0,0 +1,63 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// validityFilter keeps certs by where now falls in their validity period
+type validityFilter struct {
+	expired        bool
+	activeOnly     bool
+	expiringWithin time.Duration
+}
+
+func (o options) validityFilter() (validityFilter, error) {
+	f := validityFilter{expired: o.expired, activeOnly: o.activeOnly}
+	if len(o.expiringWithin) > 0 {
+		d, err := parseDuration(o.expiringWithin)
+		if err != nil {
+			return f, fmt.Errorf("parsing --expiring-within: %s", err)
+		}
+		f.expiringWithin = d
+	}
+
+	if f.expired && (f.activeOnly || f.expiringWithin > 0) {
+		return f, fmt.Errorf("--expired can't be combined with --active-only or --expiring-within")
+	}
+	return f, nil
+}
+
+func (f validityFilter) enabled() bool {
+	return f.expired || f.activeOnly || f.expiringWithin > 0
+}
+
+// apply filters records on their validity at now. Certs whose dates can't
+// be parsed are dropped
+func (f validityFilter) apply(records []record, now time.Time) []record {
+	var kept []record
+	for _, r := range records {
+		notBefore, err := r.NotBeforeTime()
+		if err != nil {
+			continue
+		}
+		notAfter, err := r.NotAfterTime()
+		if err != nil {
+			continue
+		}
+
+		expired := !now.Before(notAfter)
+		active := !now.Before(notBefore) && !expired
+
+		switch {
+		case f.expired && !expired:
+			continue
+		case f.activeOnly && !active:
+			continue
+		case f.expiringWithin > 0 && (!active || notAfter.After(now.Add(f.expiringWithin))):
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept
+}
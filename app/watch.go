@@ -0,0 +1,434 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+	"github.com/spf13/cobra"
+)
+
+var watchOpts struct {
+	interval     time.Duration
+	state        string
+	once         bool
+	skipExisting bool
+	feed         bool
+	history      string
+	metrics      string
+}
+
+var watchCmd = &cobra.Command{
+	Use:     "watch",
+	Aliases: []string{"monitor"},
+	Short:   "Poll crt.sh on an interval and report certificates that haven't been seen before",
+	Long: `watch polls crt.sh for the given domains on an interval, remembering the
+certificates it has seen in a state file, and only outputs certificates that
+are new since the previous poll`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		run := func(ctx context.Context) error { return runWatch(ctx, opts) }
+		if isService, err := runService(ctx, run); isService {
+			return err
+		}
+		return run(ctx)
+	},
+}
+
+func init() {
+	watchCmd.Flags().DurationVar(&watchOpts.interval, "interval", time.Hour, "How often to poll crt.sh")
+	watchCmd.Flags().StringVar(&watchOpts.state, "state", "gcrt-state.json", "File that records the certificates already seen")
+	watchCmd.Flags().BoolVar(&watchOpts.once, "once", false, "Poll once and exit, for running from cron")
+	watchCmd.Flags().BoolVar(&watchOpts.skipExisting, "skip-existing", false, "Don't report the certificates found by the first poll of a domain, only record them")
+	watchCmd.Flags().BoolVar(&watchOpts.feed, "feed", false, "Poll the crt.sh Atom feed for each domain instead of searching, which is cheaper for busy domains but returns fewer details")
+	watchCmd.Flags().StringVar(&watchOpts.history, "history", "", "Also append the new certificates to this file for gcrt report, e.g. gcrt-history.jsonl. With --resolve, changes in whether their names resolve are recorded too")
+	watchCmd.Flags().StringVar(&watchOpts.metrics, "metrics-listen", "", "Serve Prometheus metrics on /metrics at this address, e.g. :9090")
+	addNotifyFlags(watchCmd)
+	cmd.AddCommand(watchCmd)
+}
+
+// watchState is the state file format
+type watchState struct {
+	Domains map[string]*domainState `json:"domains"`
+}
+
+type domainState struct {
+	LastPoll    time.Time `json:"last_poll"`
+	Seen        []int     `json:"seen"`
+	SeenEntries []string  `json:"seen_entries,omitempty"`
+
+	seen        map[int]struct{}
+	seenEntries map[string]struct{}
+}
+
+func loadWatchState(path string) (*watchState, error) {
+	s := &watchState{Domains: make(map[string]*domainState)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %s", path, err)
+	}
+
+	for _, d := range s.Domains {
+		d.seen = make(map[int]struct{}, len(d.Seen))
+		for _, id := range d.Seen {
+			d.seen[id] = struct{}{}
+		}
+		d.seenEntries = make(map[string]struct{}, len(d.SeenEntries))
+		for _, id := range d.SeenEntries {
+			d.seenEntries[id] = struct{}{}
+		}
+	}
+	return s, nil
+}
+
+// save writes the state, never leaving it half written
+func (s *watchState) save(path string) error {
+	for _, d := range s.Domains {
+		d.Seen = make([]int, 0, len(d.seen))
+		for id := range d.seen {
+			d.Seen = append(d.Seen, id)
+		}
+		sort.Ints(d.Seen)
+
+		d.SeenEntries = make([]string, 0, len(d.seenEntries))
+		for id := range d.seenEntries {
+			d.SeenEntries = append(d.SeenEntries, id)
+		}
+		sort.Strings(d.SeenEntries)
+	}
+
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+// so that an interrupted write never corrupts the previous contents
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".gcrt-state-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *watchState) domain(name string) (*domainState, bool) {
+	d, ok := s.Domains[name]
+	if !ok {
+		d = &domainState{seen: make(map[int]struct{}), seenEntries: make(map[string]struct{})}
+		s.Domains[name] = d
+	}
+	return d, ok
+}
+
+// watcher polls a fixed set of domains, tracking what it's seen
+type watcher struct {
+	o         options
+	c         *client.Client
+	q         client.Query
+	domains   []string
+	state     *watchState
+	notifiers []notifier
+	rd        *redactor
+	enrich    *pipeline
+	history   *history
+}
+
+func runWatch(ctx context.Context, o options) error {
+	if o.output == "xlsx" {
+		return fmt.Errorf("watch can't write xlsx output, as it appends the results of each poll")
+	}
+	if err := o.requireCrtsh("gcrt watch"); err != nil {
+		return err
+	}
+	domains, err := o.targets(os.Stdin)
+	if err != nil {
+		return err
+	}
+	q, err := o.query()
+	if err != nil {
+		return err
+	}
+	state, err := loadWatchState(watchOpts.state)
+	if err != nil {
+		return err
+	}
+
+	ns, err := notifiers()
+	if err != nil {
+		return err
+	}
+	rd, err := o.redactor()
+	if err != nil {
+		return err
+	}
+	if o.backoff, err = o.loadBackoff(); err != nil {
+		return err
+	}
+
+	if len(watchOpts.metrics) > 0 {
+		o.metrics = newMetrics()
+		if err := o.metrics.serveMetrics(ctx, watchOpts.metrics); err != nil {
+			return fmt.Errorf("serving metrics: %s", err)
+		}
+	}
+
+	c, closeClient, err := o.newClient()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	p, err := o.pipeline(c)
+	if err != nil {
+		return err
+	}
+
+	wt := &watcher{o: o, c: c, q: q, domains: domains, state: state, notifiers: ns, rd: rd, enrich: p}
+	if len(watchOpts.history) > 0 {
+		if wt.history, err = loadHistory(watchOpts.history); err != nil {
+			return err
+		}
+	}
+
+	for {
+		err := wt.poll(ctx)
+		if ctx.Err() == nil {
+			o.metrics.finished(err)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if watchOpts.once {
+				return err
+			}
+			log.WithError(err).Error("poll failed")
+		}
+		if watchOpts.once {
+			return nil
+		}
+
+		select {
+		case <-time.After(watchOpts.interval):
+		case <-ctx.Done():
+			log.Info("shutting down")
+			return nil
+		}
+	}
+}
+
+// poll queries every domain once, outputs the certs not seen before and
+// saves the state
+func (wt *watcher) poll(ctx context.Context) error {
+	known := make(map[string]bool, len(wt.domains))
+	for _, name := range wt.domains {
+		_, known[name] = wt.state.Domains[name]
+	}
+
+	// --max-duration bounds the queries of each poll, what's found in time
+	// is still output
+	qctx := ctx
+	if wt.o.maxDuration > 0 {
+		var cancel context.CancelFunc
+		qctx, cancel = context.WithTimeout(ctx, wt.o.maxDuration)
+		defer cancel()
+	}
+
+	var fresh []record
+	var found []sighting
+	var err error
+	if watchOpts.feed {
+		fresh, found, err = wt.pollFeeds(qctx, known)
+	} else {
+		fresh, found, err = wt.pollSearch(qctx, known)
+	}
+	timedOut := qctx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+	if err != nil && !timedOut {
+		return err
+	}
+
+	for _, name := range wt.domains {
+		d, _ := wt.state.domain(name)
+		d.LastPoll = time.Now().UTC()
+	}
+
+	wt.o.metrics.discoveredCerts(fresh)
+	if len(wt.domains) == 1 {
+		for i := range fresh {
+			fresh[i].SourceDomain = ""
+		}
+	}
+	log.Infof("found %d new certificates", len(fresh))
+
+	if len(fresh) > 0 {
+		if err := wt.emit(ctx, fresh); err != nil {
+			return err
+		}
+	}
+	// certs are only seen once they've been output and notified, so a
+	// failure means they're reported again by the next poll
+	wt.state.markSeen(found)
+
+	if wt.history != nil && wt.enrich.has("resolve") {
+		if err := wt.history.updateStatuses(ctx, wt.enrich); err != nil {
+			return fmt.Errorf("writing history: %s", err)
+		}
+	}
+
+	if err := wt.state.save(watchOpts.state); err != nil {
+		return err
+	}
+	if wt.o.backoff != nil {
+		if err := wt.o.backoff.save(wt.o.backoffFile); err != nil {
+			return fmt.Errorf("saving backoff state: %s", err)
+		}
+	}
+	if timedOut {
+		return errMaxDuration(wt.o)
+	}
+	return nil
+}
+
+// sighting is a cert a poll found that hadn't been seen, by its crt.sh id
+// or feed entry id
+type sighting struct {
+	domain string
+	id     int
+	entry  string
+}
+
+// markSeen records the certs found by a poll as seen
+func (s *watchState) markSeen(found []sighting) {
+	for _, f := range found {
+		d, _ := s.domain(f.domain)
+		if len(f.entry) > 0 {
+			d.seenEntries[f.entry] = struct{}{}
+		} else {
+			d.seen[f.id] = struct{}{}
+		}
+	}
+}
+
+// pollSearch finds new certs by searching, keyed on their crt.sh ids
+func (wt *watcher) pollSearch(ctx context.Context, known map[string]bool) ([]record, []sighting, error) {
+	records, err := searchAll(ctx, wt.c, wt.q, wt.domains, wt.o, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fresh []record
+	var found []sighting
+	for _, r := range records {
+		d, _ := wt.state.domain(r.SourceDomain)
+		if _, ok := d.seen[r.ID]; ok {
+			continue
+		}
+		found = append(found, sighting{domain: r.SourceDomain, id: r.ID})
+		if known[r.SourceDomain] || !watchOpts.skipExisting {
+			fresh = append(fresh, r)
+		}
+	}
+	return fresh, found, nil
+}
+
+// pollFeeds finds new certs from the Atom feed of each domain, keyed on the
+// feed entry ids
+func (wt *watcher) pollFeeds(ctx context.Context, known map[string]bool) ([]record, []sighting, error) {
+	var fresh []record
+	var found []sighting
+	failed := 0
+
+	for _, name := range wt.domains {
+		entries, err := wt.c.Feed(ctx, targetQuery(wt.q, name))
+		wt.o.metrics.searched(name, len(entries), err)
+		if err != nil {
+			log.WithError(err).Errorf("error reading feed for %s", name)
+			failed++
+			continue
+		}
+
+		d, _ := wt.state.domain(name)
+		for _, e := range entries {
+			if _, ok := d.seenEntries[e.ID]; ok {
+				continue
+			}
+			found = append(found, sighting{domain: name, entry: e.ID})
+			if known[name] || !watchOpts.skipExisting {
+				r := record{CertResponse: e.CertResponse()}
+				r.SourceDomain = name
+				r.FeedTitle = e.Title
+				fresh = append(fresh, r)
+			}
+		}
+	}
+
+	if failed == len(wt.domains) {
+		return nil, nil, fmt.Errorf("error reading feeds")
+	}
+	return fresh, found, nil
+}
+
+func (wt *watcher) emit(ctx context.Context, records []record) error {
+	records, err := wt.o.filter(records)
+	if err != nil {
+		return err
+	}
+	if records, err = wt.enrich.run(ctx, records); err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	// the history is gcrt's own, so it keeps what redaction would mask
+	if wt.history != nil {
+		if err := wt.history.addCerts(records); err != nil {
+			return fmt.Errorf("writing history: %s", err)
+		}
+	}
+	// redacted before anything leaves gcrt, including notifications
+	wt.rd.apply(records)
+
+	out, err := wt.o.openOutput(true)
+	if err != nil {
+		return err
+	}
+	if err := wt.o.write(out, records); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return notifyAll(ctx, wt.notifiers, records)
+}
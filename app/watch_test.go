@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhinds/gcrt/client"
+)
+
+// flakyNotifier fails its first failures notifications
+type flakyNotifier struct {
+	failures int
+	notified [][]record
+}
+
+func (n *flakyNotifier) Name() string { return "flaky" }
+
+func (n *flakyNotifier) Notify(ctx context.Context, records []record) error {
+	if n.failures > 0 {
+		n.failures--
+		return errors.New("unavailable")
+	}
+	n.notified = append(n.notified, records)
+	return nil
+}
+
+func TestWatchReportsAgainAfterNotifyFails(t *testing.T) {
+	c, _ := fakeCrtsh(t, []client.CertResponse{testCert(1, "www.example.com"), testCert(2, "api.example.com")})
+	dir := t.TempDir()
+
+	saved := watchOpts
+	defer func() { watchOpts = saved }()
+	watchOpts.state = filepath.Join(dir, "state.json")
+	watchOpts.feed = false
+	watchOpts.skipExisting = false
+
+	n := &flakyNotifier{failures: 1}
+	state, err := loadWatchState(watchOpts.state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt := &watcher{
+		o:         options{output: "json", concurrency: 1, outFile: filepath.Join(dir, "out.json")},
+		c:         c,
+		domains:   []string{"example.com"},
+		state:     state,
+		notifiers: []notifier{n},
+		rd:        &redactor{},
+		enrich:    &pipeline{},
+	}
+
+	if err := wt.poll(context.Background()); err == nil {
+		t.Fatal("the first poll succeeded, but its notification failed")
+	}
+	if d, _ := wt.state.domain("example.com"); len(d.seen) != 0 {
+		t.Fatalf("%d certs were marked as seen by a poll that failed to notify", len(d.seen))
+	}
+
+	if err := wt.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.notified) != 1 || len(n.notified[0]) != 2 {
+		t.Fatalf("the second poll notified %v, want both certs", n.notified)
+	}
+
+	// once reported, they're not reported again, even after a restart
+	if state, err = loadWatchState(watchOpts.state); err != nil {
+		t.Fatal(err)
+	}
+	wt.state = state
+	if err := wt.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.notified) != 1 {
+		t.Fatalf("the certs were notified again: %v", n.notified)
+	}
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ianaWhois is asked which server holds the registrations for a TLD
+const ianaWhois = "whois.iana.org"
+
+// how much of a whois response is read
+const whoisLimit = 64 << 10
+
+// WhoisResult is the registration of a registrable domain on a cert
+type WhoisResult struct {
+	Domain    string `json:"domain"`
+	Server    string `json:"server,omitempty"`
+	Registrar string `json:"registrar,omitempty"`
+	Created   string `json:"created,omitempty"`
+	Expires   string `json:"expires,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// whoisKeys are the names registries give the fields of a WhoisResult
+var whoisKeys = map[string][]string{
+	"registrar": {"registrar", "registrar name", "sponsoring registrar"},
+	"created":   {"creation date", "created", "created on", "registered on", "registration time"},
+	"expires":   {"registry expiry date", "registrar registration expiration date", "expiry date", "expiration date", "expires", "expires on", "paid-till"},
+}
+
+// whoisStage looks up the registration of every registrable domain on the
+// records, each distinct domain once
+func (p *pipeline) whoisStage(ctx context.Context, records []record) ([]record, error) {
+	var domains []string
+	for _, r := range records {
+		domains = append(domains, whoisDomains(r)...)
+	}
+	results := p.lookupAll(ctx, "whois", domains, func(ctx context.Context, domain string) interface{} {
+		return p.whois(ctx, domain)
+	})
+
+	for i := range records {
+		r := &records[i]
+		r.Whois = nil
+		for _, d := range whoisDomains(*r) {
+			r.Whois = append(r.Whois, results[d].(WhoisResult))
+		}
+	}
+	return records, nil
+}
+
+// whoisDomains are the distinct registrable domains of a record's names
+func whoisDomains(r record) []string {
+	seen := make(map[string]bool)
+	var domains []string
+	for _, n := range r.Names() {
+		if strings.ContainsAny(n, "@ ") || net.ParseIP(n) != nil {
+			continue
+		}
+		d := registrableDomain(n)
+		if !strings.Contains(d, ".") || seen[d] {
+			continue
+		}
+		seen[d] = true
+		domains = append(domains, d)
+	}
+	return domains
+}
+
+// whois looks up domain on the --whois-server, or the server IANA refers
+// its TLD to
+func (p *pipeline) whois(ctx context.Context, domain string) WhoisResult {
+	res := WhoisResult{Domain: domain}
+
+	server := p.o.whoisServer
+	if len(server) == 0 {
+		tld, _ := publicsuffix.PublicSuffix(domain)
+		if i := strings.LastIndex(tld, "."); i >= 0 {
+			tld = tld[i+1:]
+		}
+		if v, ok := p.cache.get("whois-server", tld); ok {
+			server = v.(string)
+		} else {
+			resp, err := whoisQuery(ctx, ianaWhois, tld, p.o.probeTimeout)
+			if err != nil {
+				res.Error = "asking IANA for the whois server: " + err.Error()
+				return res
+			}
+			fields := parseWhois(resp)
+			if server = fields["refer"]; len(server) == 0 {
+				server = fields["whois"]
+			}
+			if len(server) == 0 {
+				res.Error = "no whois server for ." + tld
+				return res
+			}
+			p.cache.put("whois-server", tld, server)
+		}
+	}
+	res.Server = server
+
+	resp, err := whoisQuery(ctx, server, domain, p.o.probeTimeout)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	fields := parseWhois(resp)
+	for field, keys := range whoisKeys {
+		for _, k := range keys {
+			v, ok := fields[k]
+			if !ok {
+				continue
+			}
+			switch field {
+			case "registrar":
+				res.Registrar = v
+			case "created":
+				res.Created = v
+			case "expires":
+				res.Expires = v
+			}
+			break
+		}
+	}
+	if len(res.Registrar) == 0 && len(res.Created) == 0 && len(res.Expires) == 0 {
+		res.Error = "no registration found"
+	}
+	return res
+}
+
+// whoisQuery sends query to a whois server, on port 43 unless the server
+// names another
+func whoisQuery(ctx context.Context, server, query string, timeout time.Duration) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "43")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := io.WriteString(conn, query+"\r\n"); err != nil {
+		return "", err
+	}
+	resp, err := ioutil.ReadAll(io.LimitReader(conn, whoisLimit))
+	if err != nil {
+		return "", err
+	}
+	return string(resp), nil
+}
+
+// parseWhois reads the "key: value" lines of a whois response, keeping the
+// first value of each lowercased key. A key with no value takes the next
+// line, as some registries put the value on its own line
+func parseWhois(resp string) map[string]string {
+	fields := make(map[string]string)
+	var pending string
+
+	sc := bufio.NewScanner(strings.NewReader(resp))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "%") || strings.HasPrefix(line, "#") {
+			pending = ""
+			continue
+		}
+		if len(pending) > 0 {
+			if _, ok := fields[pending]; !ok {
+				fields[pending] = line
+			}
+			pending = ""
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+		if len(value) == 0 {
+			pending = key
+			continue
+		}
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isWildcard reports whether a name is a wildcard like *.example.com
+func isWildcard(name string) bool {
+	return strings.HasPrefix(name, "*.")
+}
+
+// wildcardNames are the wildcard names on a cert
+func wildcardNames(r record) []string {
+	var names []string
+	for _, n := range r.Names() {
+		if isWildcard(n) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// filterWildcards keeps the certs with at least one wildcard name
+func filterWildcards(records []record) []record {
+	var kept []record
+	for _, r := range records {
+		if len(wildcardNames(r)) > 0 {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// WildcardCert is a cert for a wildcard name in the --wildcard-summary
+type WildcardCert struct {
+	ID         int    `json:"id"`
+	CrtShLink  string `json:"crt_sh_link"`
+	IssuerName string `json:"issuer_name"`
+	NotBefore  string `json:"not_before"`
+	NotAfter   string `json:"not_after"`
+	Expired    bool   `json:"expired"`
+}
+
+// WildcardSummary is every cert found for a wildcard name
+type WildcardSummary struct {
+	Name         string         `json:"name"`
+	CertCount    int            `json:"cert_count"`
+	ActiveCount  int            `json:"active_count"`
+	Issuers      []string       `json:"issuers"`
+	LatestExpiry string         `json:"latest_expiry"`
+	Certs        []WildcardCert `json:"certs"`
+
+	latestExpiry time.Time
+}
+
+// summariseWildcards groups the certs by the wildcard names on them, newest
+// cert first within each name
+func summariseWildcards(records []record, now time.Time) []WildcardSummary {
+	byName := make(map[string]*WildcardSummary)
+	issuers := make(map[string]map[string]bool)
+
+	for _, r := range records {
+		notAfter, err := r.NotAfterTime()
+		if err != nil {
+			continue
+		}
+		c := WildcardCert{
+			ID:         r.ID,
+			CrtShLink:  r.Link(),
+			IssuerName: r.IssuerName,
+			NotBefore:  r.NotBefore,
+			NotAfter:   r.NotAfter,
+			Expired:    !now.Before(notAfter),
+		}
+		for _, n := range wildcardNames(r) {
+			s, ok := byName[n]
+			if !ok {
+				s = &WildcardSummary{Name: n, Issuers: []string{}}
+				byName[n] = s
+				issuers[n] = make(map[string]bool)
+			}
+			s.CertCount++
+			if !c.Expired {
+				s.ActiveCount++
+			}
+			if !issuers[n][r.IssuerName] {
+				issuers[n][r.IssuerName] = true
+				s.Issuers = append(s.Issuers, r.IssuerName)
+			}
+			if notAfter.After(s.latestExpiry) {
+				s.latestExpiry = notAfter
+				s.LatestExpiry = r.NotAfter
+			}
+			s.Certs = append(s.Certs, c)
+		}
+	}
+
+	summaries := make([]WildcardSummary, 0, len(byName))
+	for _, s := range byName {
+		sort.Strings(s.Issuers)
+		sort.SliceStable(s.Certs, func(i, j int) bool {
+			return s.Certs[i].NotBefore > s.Certs[j].NotBefore
+		})
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// writeWildcardSummary writes the summaries as JSON, or as one row per
+// wildcard name and cert for the tabular formats
+func writeWildcardSummary(w io.Writer, format string, summaries []WildcardSummary) error {
+	header := []string{"name", "id", "issuer_name", "not_before", "not_after", "expired"}
+	var rows [][]string
+	for _, s := range summaries {
+		for _, c := range s.Certs {
+			rows = append(rows, []string{s.Name, strconv.Itoa(c.ID), c.IssuerName, c.NotBefore, c.NotAfter, strconv.FormatBool(c.Expired)})
+		}
+	}
+
+	switch format {
+	case "csv", "tsv", "table", "markdown":
+		return writeSummaryRows(w, format, header, rows)
+	default:
+		output, err := json.MarshalIndent(&summaries, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(output))
+		return nil
+	}
+}
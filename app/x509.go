@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/jhinds/gcrt/client"
+)
+
+// downloadCerts fetches the full certificate for each cert that doesn't
+// have one yet, concurrency at a time. Certs that can't be retrieved are
+// logged and left without
+func downloadCerts(ctx context.Context, c *client.Client, certs []record, concurrency int) {
+	jobs := make(chan *record)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				cert, err := c.Certificate(ctx, r.ID)
+				if err != nil {
+					log.WithError(err).Errorf("error downloading cert %d", r.ID)
+					continue
+				}
+				r.cert = cert
+			}
+		}()
+	}
+
+	for i := range certs {
+		if ctx.Err() != nil {
+			break
+		}
+		// certs without a crt.sh id, from feeds and streams, can't be
+		// downloaded
+		if certs[i].cert == nil && certs[i].ID != 0 {
+			jobs <- &certs[i]
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// CA/Browser Forum certificate policy identifiers
+var (
+	oidPolicyEV = asn1.ObjectIdentifier{2, 23, 140, 1, 1}
+	oidPolicyDV = asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}
+	oidPolicyOV = asn1.ObjectIdentifier{2, 23, 140, 1, 2, 2}
+	oidPolicyIV = asn1.ObjectIdentifier{2, 23, 140, 1, 2, 3}
+)
+
+// validationLevel classifies a cert as dv, ov, iv or ev from its policy
+// OIDs, or returns an empty string if it asserts none of them
+func validationLevel(cert *x509.Certificate) string {
+	for _, p := range cert.PolicyIdentifiers {
+		switch {
+		case p.Equal(oidPolicyEV):
+			return "ev"
+		case p.Equal(oidPolicyOV):
+			return "ov"
+		case p.Equal(oidPolicyIV):
+			return "iv"
+		case p.Equal(oidPolicyDV):
+			return "dv"
+		}
+	}
+	return ""
+}
+
+// ekuNames maps extended key usages to the names accepted by --eku
+var ekuNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// certTypeNames maps extended key usages to the names accepted by --type
+var certTypeNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageServerAuth:      "server",
+	x509.ExtKeyUsageClientAuth:      "client",
+	x509.ExtKeyUsageCodeSigning:     "code-signing",
+	x509.ExtKeyUsageEmailProtection: "email",
+	x509.ExtKeyUsageTimeStamping:    "timestamping",
+	x509.ExtKeyUsageOCSPSigning:     "ocsp-signing",
+}
+
+func extKeyUsages(cert *x509.Certificate) []string {
+	var usages []string
+	for _, u := range cert.ExtKeyUsage {
+		if n, ok := ekuNames[u]; ok {
+			usages = append(usages, n)
+		}
+	}
+	return usages
+}
+
+func certTypes(cert *x509.Certificate) []string {
+	var types []string
+	for _, u := range cert.ExtKeyUsage {
+		if n, ok := certTypeNames[u]; ok {
+			types = append(types, n)
+		}
+	}
+	return types
+}
+
+// classifyCerts records the key usage, type and validation level of each
+// downloaded cert
+func classifyCerts(certs []record) {
+	for i := range certs {
+		c := &certs[i]
+		if c.cert == nil {
+			continue
+		}
+		c.ExtKeyUsage = extKeyUsages(c.cert)
+		c.CertTypes = certTypes(c.cert)
+		c.ValidationLevel = validationLevel(c.cert)
+	}
+}
+
+// containsAny reports whether any of want is in have, ignoring case
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if strings.EqualFold(h, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterByUsage keeps classified certs that match every usage filter given
+func filterByUsage(certs []record, ekus, types, levels []string) []record {
+	var kept []record
+	for _, c := range certs {
+		if c.cert == nil {
+			continue
+		}
+		if len(ekus) > 0 && !containsAny(c.ExtKeyUsage, ekus) {
+			continue
+		}
+		if len(types) > 0 && !containsAny(c.CertTypes, types) {
+			continue
+		}
+		if len(levels) > 0 && !containsAny([]string{c.ValidationLevel}, levels) {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
+
+// keySize is the size in bits of a cert's public key, or 0 if the key
+// type isn't known
+func keySize(cert *x509.Certificate) int {
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	case *dsa.PublicKey:
+		return k.P.BitLen()
+	}
+	return 0
+}
+
+// describeCerts records the parsed details of each downloaded cert
+func describeCerts(certs []record) {
+	for i := range certs {
+		c := &certs[i]
+		if c.cert == nil {
+			continue
+		}
+
+		var sans []string
+		sans = append(sans, c.cert.DNSNames...)
+		for _, ip := range c.cert.IPAddresses {
+			sans = append(sans, ip.String())
+		}
+		sans = append(sans, c.cert.EmailAddresses...)
+		for _, u := range c.cert.URIs {
+			sans = append(sans, u.String())
+		}
+
+		sum := sha256.Sum256(c.cert.Raw)
+		c.SANs = sans
+		c.KeyAlgorithm = c.cert.PublicKeyAlgorithm.String()
+		c.KeySize = keySize(c.cert)
+		c.SignatureAlgorithm = c.cert.SignatureAlgorithm.String()
+		c.SHA256Fingerprint = hex.EncodeToString(sum[:])
+		c.ValidityDays = int(c.cert.NotAfter.Sub(c.cert.NotBefore).Hours() / 24)
+	}
+}
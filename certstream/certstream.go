@@ -0,0 +1,168 @@
+// Package certstream reads the live feed of certificates being added to the
+// Certificate Transparency logs from a CertStream server
+package certstream
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// DefaultURL is the public CertStream server. Its /full-stream endpoint
+// includes each certificate's DER as well
+const DefaultURL = "wss://certstream.calidog.io/"
+
+// readTimeout is how long to wait for a message, the server sends a
+// heartbeat every few seconds when there's nothing else
+const readTimeout = 2 * time.Minute
+
+// the update types of certificate messages
+const (
+	UpdateCertificate    = "X509LogEntry"
+	UpdatePrecertificate = "PrecertLogEntry"
+)
+
+// Entry is a certificate added to a CT log
+type Entry struct {
+	UpdateType string `json:"update_type"`
+	LeafCert   Cert   `json:"leaf_cert"`
+	CertIndex  int64  `json:"cert_index"`
+	// Seen is when the server saw the entry, in seconds since the epoch
+	Seen   float64 `json:"seen"`
+	Source struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"source"`
+}
+
+// Cert is the leaf certificate of an entry
+type Cert struct {
+	Subject      Name     `json:"subject"`
+	Issuer       Name     `json:"issuer"`
+	AllDomains   []string `json:"all_domains"`
+	NotBefore    float64  `json:"not_before"`
+	NotAfter     float64  `json:"not_after"`
+	SerialNumber string   `json:"serial_number"`
+	// Fingerprint is the SHA-1 fingerprint as colon separated hex
+	Fingerprint string `json:"fingerprint"`
+	// AsDER is the base64 DER of the cert, only sent by the full stream
+	AsDER string `json:"as_der"`
+}
+
+// Name is a subject or issuer name
+type Name struct {
+	CN string `json:"CN"`
+	O  string `json:"O"`
+	// Aggregated is the whole name, as /C=US/O=Example/CN=Example CA
+	Aggregated string `json:"aggregated"`
+}
+
+// String formats the name like crt.sh does, as C=US, O=Example, CN=Example CA
+func (n Name) String() string {
+	var parts []string
+	for _, p := range strings.Split(n.Aggregated, "/") {
+		if len(p) > 0 {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Time converts one of the timestamps of an entry
+func Time(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+}
+
+// DER decodes the cert, or returns nil when the stream doesn't include it
+func (c Cert) DER() ([]byte, error) {
+	if len(c.AsDER) == 0 {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(c.AsDER)
+}
+
+type message struct {
+	MessageType string `json:"message_type"`
+	Data        Entry  `json:"data"`
+}
+
+// Options configures the connection to a CertStream server
+type Options struct {
+	URL         string
+	UserAgent   string
+	DialTimeout time.Duration
+	TLSConfig   *tls.Config
+}
+
+// Stream connects to the server and calls fn with every certificate entry
+// until ctx is done, fn returns an error or the connection fails. It returns
+// nil once ctx is done
+func Stream(ctx context.Context, o Options, fn func(Entry) error) error {
+	config, err := websocket.NewConfig(o.URL, origin(o.URL))
+	if err != nil {
+		return fmt.Errorf("invalid CertStream URL %q: %s", o.URL, err)
+	}
+	if len(o.UserAgent) > 0 {
+		config.Header = http.Header{"User-Agent": {o.UserAgent}}
+	}
+	config.Dialer = &net.Dialer{Timeout: o.DialTimeout}
+	config.TlsConfig = o.TLSConfig
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	// closing the connection is the only way to interrupt a read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(readTimeout))
+		var m message
+		if err := websocket.JSON.Receive(ws, &m); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("reading from CertStream: %s", err)
+		}
+		if m.MessageType != "certificate_update" {
+			continue
+		}
+		if err := fn(m.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// origin is the Origin header sent when connecting, the http(s) URL of the
+// server
+func origin(server string) string {
+	u, err := url.Parse(server)
+	if err != nil {
+		return server
+	}
+	if u.Scheme == "wss" {
+		u.Scheme = "https"
+	} else {
+		u.Scheme = "http"
+	}
+	u.Path, u.RawQuery = "/", ""
+	return u.String()
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultCensysURL is the Censys Search API
+const DefaultCensysURL = "https://search.censys.io/api"
+
+// censysMaxPages caps how many pages of 100 results a Censys search reads
+const censysMaxPages = 50
+
+// Censys searches the certificates in Censys, authenticating with an API
+// id and secret
+type Censys struct {
+	c       *Client
+	baseURL string
+	id      string
+	secret  string
+}
+
+// NewCensys is a Censys source making its requests through c, so they're
+// retried and rate limited the same way
+func (c *Client) NewCensys(baseURL, id, secret string) *Censys {
+	return &Censys{c: c, baseURL: baseURL, id: id, secret: secret}
+}
+
+// Name implements Source
+func (s *Censys) Name() string {
+	return "censys"
+}
+
+type censysResponse struct {
+	Result struct {
+		Hits []struct {
+			FingerprintSHA256 string   `json:"fingerprint_sha256"`
+			Names             []string `json:"names"`
+			Parsed            struct {
+				IssuerDN       string `json:"issuer_dn"`
+				SubjectDN      string `json:"subject_dn"`
+				SerialNumber   string `json:"serial_number"`
+				ValidityPeriod struct {
+					NotBefore time.Time `json:"not_before"`
+					NotAfter  time.Time `json:"not_after"`
+				} `json:"validity_period"`
+			} `json:"parsed"`
+		} `json:"hits"`
+		Links struct {
+			Next string `json:"next"`
+		} `json:"links"`
+	} `json:"result"`
+}
+
+// Search implements Source. Censys is asked for the names under the query's
+// domain and the results are then matched like crt.sh would
+func (s *Censys) Search(ctx context.Context, q Query) ([]CertResponse, error) {
+	if err := checkNameQuery(q); err != nil {
+		return nil, err
+	}
+	search := "names: " + strings.Replace(q.Domain, "%", "*", -1)
+	header := http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(s.id+":"+s.secret))}}
+
+	var certs []CertResponse
+	cursor := ""
+	for page := 0; page < censysMaxPages; page++ {
+		u := fmt.Sprintf("%s/v2/certificates/search?q=%s&per_page=100", s.baseURL, url.QueryEscape(search))
+		if len(cursor) > 0 {
+			u += "&cursor=" + url.QueryEscape(cursor)
+		}
+		resp, err := s.c.getWith(ctx, u, header)
+		if err != nil {
+			return nil, err
+		}
+		var r censysResponse
+		err = json.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unexpected response: %s", err)
+		}
+
+		for _, h := range r.Result.Hits {
+			if !q.MatchesNames(h.Names) {
+				continue
+			}
+			c := CertResponse{
+				IssuerName:   h.Parsed.IssuerDN,
+				CommonName:   strings.ToLower(dnAttribute(h.Parsed.SubjectDN, "CN")),
+				NameValue:    joinNames(h.Names),
+				NotBefore:    formatTime(h.Parsed.ValidityPeriod.NotBefore),
+				NotAfter:     formatTime(h.Parsed.ValidityPeriod.NotAfter),
+				SerialNumber: decimalSerial(h.Parsed.SerialNumber),
+				Source:       s.Name(),
+				SHA256:       strings.ToLower(h.FingerprintSHA256),
+			}
+			if q.Matches(c) {
+				certs = append(certs, c)
+			}
+		}
+		if cursor = r.Result.Links.Next; len(cursor) == 0 {
+			break
+		}
+	}
+	return q.dedupe(certs), nil
+}
+
+// dnAttribute is the value of an attribute of a distinguished name written
+// as C=US, O=Example, CN=Example CA
+func dnAttribute(dn, attr string) string {
+	for _, part := range strings.Split(dn, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 && kv[0] == attr {
+			return kv[1]
+		}
+	}
+	return ""
+}
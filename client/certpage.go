@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogEntry is an entry for a certificate in a CT log, as its crt.sh page
+// lists them
+type LogEntry struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Index     int64  `json:"index"`
+	Operator  string `json:"operator"`
+	URL       string `json:"url"`
+}
+
+// CertDetail is what the crt.sh page of a certificate says about it beyond
+// a search
+type CertDetail struct {
+	IssuerCAID int64
+	LogEntries []LogEntry
+}
+
+var (
+	caIDPattern     = regexp.MustCompile(`[?&]caid=(\d+)`)
+	ctSectionStart  = regexp.MustCompile(`(?i)>\s*Certificate Transparency\s*<`)
+	tableEnd        = regexp.MustCompile(`(?i)</TABLE>`)
+	rowPattern      = regexp.MustCompile(`(?is)<TR[^>]*>(.*?)</TR>`)
+	cellPattern     = regexp.MustCompile(`(?is)<TD[^>]*>(.*?)</TD>`)
+	tagPattern      = regexp.MustCompile(`<[^>]*>`)
+	spacePattern    = regexp.MustCompile(`\s+`)
+	logEntryLayouts = []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05.999 MST", "2006-01-02 15:04:05"}
+)
+
+// cellText is the text of a table cell without its markup
+func cellText(cell string) string {
+	text := html.UnescapeString(tagPattern.ReplaceAllString(cell, " "))
+	return strings.TrimSpace(spacePattern.ReplaceAllString(strings.Replace(text, "\u00a0", " ", -1), " "))
+}
+
+// page downloads a crt.sh HTML page
+func (c *Client) page(ctx context.Context, u string) (string, error) {
+	resp, err := c.get(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// CertDetail reads the CT log entries and issuer of the certificate with
+// the given crt.sh id from its page, which crt.sh only has as HTML
+func (c *Client) CertDetail(ctx context.Context, id int) (CertDetail, error) {
+	body, err := c.page(ctx, fmt.Sprintf("%s/?id=%d", c.baseURL, id))
+	if err != nil {
+		return CertDetail{}, err
+	}
+
+	var d CertDetail
+	if m := caIDPattern.FindStringSubmatch(body); m != nil {
+		d.IssuerCAID, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+
+	start := ctSectionStart.FindStringIndex(body)
+	if start == nil {
+		return d, fmt.Errorf("cert %d: no CT log entries on its crt.sh page", id)
+	}
+	section := body[start[1]:]
+	if end := tableEnd.FindStringIndex(section); end != nil {
+		section = section[:end[0]]
+	}
+	for _, row := range rowPattern.FindAllStringSubmatch(section, -1) {
+		cells := cellPattern.FindAllStringSubmatch(row[1], -1)
+		// the header row has no cells, only headings
+		if len(cells) < 4 {
+			continue
+		}
+		e := LogEntry{Operator: cellText(cells[2][1]), URL: cellText(cells[3][1])}
+		e.Index, _ = strconv.ParseInt(cellText(cells[1][1]), 10, 64)
+		// timestamps in a form not known are left out
+		ts := cellText(cells[0][1])
+		for _, layout := range logEntryLayouts {
+			if t, err := time.Parse(layout, ts); err == nil {
+				e.Timestamp = t.UTC().Format(TimeLayout)
+				break
+			}
+		}
+		d.LogEntries = append(d.LogEntries, e)
+	}
+	return d, nil
+}
+
+// CACertificates are the crt.sh ids of the certificates of the CA with the
+// given crt.sh CA id, listed on its page. A CA has one for each of its
+// issuers, when it's cross-signed, and for each time it's been reissued
+func (c *Client) CACertificates(ctx context.Context, caID int64) ([]int, error) {
+	body, err := c.page(ctx, fmt.Sprintf("%s/?caid=%d", c.baseURL, caID))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	var ids []int
+	for _, m := range certIDPattern.FindAllStringSubmatch(body, -1) {
+		id, err := strconv.Atoi(m[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("CA %d: no certificates on its crt.sh page", caID)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
@@ -0,0 +1,472 @@
+// Package client searches the Certificate Transparency logs through
+// https://crt.sh
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// DefaultBaseURL is the crt.sh instance queried unless WithBaseURL is given
+const DefaultBaseURL = "https://crt.sh"
+
+// Client queries crt.sh, retrying failed requests
+type Client struct {
+	baseURL   string
+	userAgent string
+	http      *retryablehttp.Client
+	// the transport under any wrapping, for the options that configure
+	// the connection
+	transport *http.Transport
+	metrics   Metrics
+	fallback  func(q Query, params string)
+	progress  ReadProgress
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithBaseURL points the client at a different crt.sh instance
+func WithBaseURL(u string) Option {
+	return func(c *Client) {
+		c.baseURL = u
+	}
+}
+
+// WithTimeout sets the timeout of each individual request
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.http.HTTPClient.Timeout = d
+	}
+}
+
+// WithRetries sets how many times a failed request is retried
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.http.RetryMax = n
+	}
+}
+
+// WithRetryWaitMax caps the exponential backoff between retries
+func WithRetryWaitMax(d time.Duration) Option {
+	return func(c *Client) {
+		c.http.RetryWaitMax = d
+		if c.http.RetryWaitMin > d {
+			c.http.RetryWaitMin = d
+		}
+	}
+}
+
+// WithRateLimit limits the client to perSecond requests a second, counting
+// retries, however many searches are running at once
+func WithRateLimit(perSecond float64) Option {
+	return func(c *Client) {
+		limiter := newRateLimiter(perSecond)
+		hook := c.http.RequestLogHook
+		c.http.RequestLogHook = func(l retryablehttp.Logger, req *http.Request, attempt int) {
+			// a cancelled request fails as soon as it's attempted
+			limiter.wait(req.Context())
+			if hook != nil {
+				hook(l, req, attempt)
+			}
+		}
+	}
+}
+
+// WithFallback retries a search that crt.sh answers with its HTML error
+// page, which it does when a query takes too long, asking it to deduplicate
+// the results and then to leave out expired certs as well. fn is told about
+// each retry, as the results can differ
+func WithFallback(fn func(q Query, params string)) Option {
+	return func(c *Client) {
+		c.fallback = fn
+	}
+}
+
+// Metrics are told about the client's requests, for monitoring it. Any of
+// them can be nil
+type Metrics struct {
+	// Attempt is called before each attempt at a request, retry is whether
+	// it's a retry
+	Attempt func(retry bool)
+	// Failed is called for each request that failed after any retries
+	Failed func()
+}
+
+// WithMetrics reports the client's requests to m
+func WithMetrics(m Metrics) Option {
+	return func(c *Client) {
+		c.metrics = m
+		if m.Attempt == nil {
+			return
+		}
+		hook := c.http.RequestLogHook
+		c.http.RequestLogHook = func(l retryablehttp.Logger, req *http.Request, attempt int) {
+			if hook != nil {
+				hook(l, req, attempt)
+			}
+			m.Attempt(attempt > 0)
+		}
+	}
+}
+
+// ReadProgress is told how much of a search's response has been read so
+// far: the entries decoded, and the pages crt.sh splits its results into,
+// each a JSON array, that have been started
+type ReadProgress func(q Query, entries, pages int)
+
+// WithReadProgress reports on each search's response as it's read, which
+// for a large domain can take minutes
+func WithReadProgress(fn ReadProgress) Option {
+	return func(c *Client) {
+		c.progress = fn
+	}
+}
+
+// WithRequestLog calls fn before each attempt at a request, attempt being
+// 0 for the first. Credentials in the URL are redacted as they are by
+// WithTrace
+func WithRequestLog(fn func(method, u string, attempt int)) Option {
+	return func(c *Client) {
+		hook := c.http.RequestLogHook
+		c.http.RequestLogHook = func(l retryablehttp.Logger, req *http.Request, attempt int) {
+			if hook != nil {
+				hook(l, req, attempt)
+			}
+			fn(req.Method, sanitizeURL(req.URL), attempt)
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every request, including
+// those to connect through a proxy
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+		if len(ua) > 0 {
+			c.transport.ProxyConnectHeader = http.Header{"User-Agent": {ua}}
+		}
+	}
+}
+
+// WithProxy sends every request through the proxy at u, which can be an
+// http, https or socks5 URL. By default the proxy is taken from the
+// HTTPS_PROXY and NO_PROXY environment variables
+func WithProxy(u *url.URL) Option {
+	return func(c *Client) {
+		c.transport.Proxy = http.ProxyURL(u)
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to crt.sh and
+// any proxy, e.g. to trust another CA
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.transport.TLSClientConfig = config
+	}
+}
+
+// WithTrace writes a sanitized transcript of every request and response
+// made by the client to w
+func WithTrace(w io.Writer) Option {
+	return func(c *Client) {
+		c.http.HTTPClient.Transport = newTraceTransport(w, c.http.HTTPClient.Transport)
+	}
+}
+
+// WithTransport wraps the transport used for every request, e.g. to
+// instrument it
+func WithTransport(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) {
+		c.http.HTTPClient.Transport = wrap(c.http.HTTPClient.Transport)
+	}
+}
+
+// attemptsKey is the context key of the count of a request's attempts
+type attemptsKey struct{}
+
+// WithRetryBudget limits the retries made across every request by the
+// client to n, so a struggling crt.sh can't stretch a whole run out.
+// Once the budget is spent failed requests aren't retried
+func WithRetryBudget(n int) Option {
+	return func(c *Client) {
+		var used int64
+		policy := c.http.CheckRetry
+		c.http.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+			retry, checkErr := policy(ctx, resp, err)
+			// a request's last attempt isn't retried, whatever the policy says
+			if attempts, ok := ctx.Value(attemptsKey{}).(*int); ok {
+				*attempts++
+				if *attempts > c.http.RetryMax {
+					return retry, checkErr
+				}
+			}
+			if retry && atomic.AddInt64(&used, 1) > int64(n) {
+				return false, checkErr
+			}
+			return retry, checkErr
+		}
+	}
+}
+
+// New creates a Client
+func New(opts ...Option) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	rc := retryablehttp.NewClient()
+	rc.HTTPClient = &http.Client{
+		Timeout:   time.Second * 30,
+		Transport: transport,
+	}
+	rc.Logger = nil
+
+	c := &Client{
+		baseURL:   DefaultBaseURL,
+		http:      rc,
+		transport: transport,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+func (c *Client) get(ctx context.Context, u string) (*http.Response, error) {
+	return c.getWith(ctx, u, nil)
+}
+
+// getWith is get with extra headers, e.g. the credentials of another source
+func (c *Client) getWith(ctx context.Context, u string, header http.Header) (*http.Response, error) {
+	req, err := retryablehttp.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if len(c.userAgent) > 0 {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	// the attempts are counted for the retry budget
+	ctx = context.WithValue(ctx, attemptsKey{}, new(int))
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err == nil && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	if err != nil {
+		if c.metrics.Failed != nil {
+			c.metrics.Failed()
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Search returns the certificates matching q. crt.sh reports both the
+// precertificate and the leaf certificate, only the first, usually the leaf,
+// is returned unless q.Dedupe says otherwise. When the response is cut off
+// the certs read before it was are returned along with a *PartialError
+func (c *Client) Search(ctx context.Context, q Query) ([]CertResponse, error) {
+	certs := make([]CertResponse, 0)
+	err := c.Each(ctx, q, func(cert CertResponse) bool {
+		certs = append(certs, cert)
+		return true
+	})
+	var partial *PartialError
+	if errors.As(err, &partial) {
+		return certs, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// Each calls fn with every certificate Search would return, in the same
+// order, as they're read from crt.sh rather than once the whole response
+// has arrived. Returning false stops reading the response
+func (c *Client) Each(ctx context.Context, q Query, fn func(CertResponse) bool) error {
+	err := c.each(ctx, q, "", fn)
+	if c.fallback == nil {
+		return err
+	}
+	// nothing has been passed to fn when the response isn't JSON, so the
+	// search can start over. Fallbacks asking for nothing more than the
+	// query already did are skipped
+	tried := map[string]bool{q.extraParams(""): true}
+	for _, params := range q.fallbacks() {
+		var notJSON *NotJSONError
+		if !errors.As(err, &notJSON) {
+			break
+		}
+		if tried[q.extraParams(params)] {
+			continue
+		}
+		tried[q.extraParams(params)] = true
+		c.fallback(q, params)
+		err = c.each(ctx, q, params, fn)
+	}
+	return err
+}
+
+// each is Each with extra crt.sh parameters
+func (c *Client) each(ctx context.Context, q Query, params string, fn func(CertResponse) bool) error {
+	u := fmt.Sprintf("%s/?%s=%s&output=json", c.baseURL, q.param(), url.QueryEscape(q.Domain))
+	if extra := q.extraParams(params); len(extra) > 0 {
+		u += "&" + extra
+	}
+	resp, err := c.get(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var progress func(entries, pages int)
+	if c.progress != nil {
+		progress = func(entries, pages int) { c.progress(q, entries, pages) }
+	}
+
+	// keep the first entry of each cert
+	seen := make(map[string]struct{})
+	err = decodeCerts(resp.Body, progress, func(cert CertResponse) bool {
+		if !q.Matches(cert) {
+			return true
+		}
+		if key := q.dedupeKey(cert); len(key) > 0 {
+			if _, ok := seen[key]; ok {
+				return true
+			}
+			seen[key] = struct{}{}
+		}
+		return fn(cert)
+	})
+	if err != nil {
+		return fmt.Errorf("GET %s: unexpected response: %w", resp.Request.URL, err)
+	}
+	return nil
+}
+
+// NotJSONError is returned when crt.sh answers with something other than
+// JSON, usually its HTML error page for a query that took too long
+type NotJSONError struct {
+	Err error
+}
+
+func (e *NotJSONError) Error() string {
+	return fmt.Sprintf("crt.sh didn't return JSON, it may have timed out: %s", e.Err)
+}
+
+// PartialError is returned when a crt.sh response stops parsing part way
+// through, after Decoded certs were read from it
+type PartialError struct {
+	Decoded int
+	Err     error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("the response was cut off after %d certs: %s", e.Decoded, e.Err)
+}
+
+// decodeCerts reads the certs in a crt.sh response one at a time, until fn
+// returns false. progress, when it isn't nil, is told about each entry and
+// page read
+func decodeCerts(r io.Reader, progress func(entries, pages int), fn func(CertResponse) bool) error {
+	dec := json.NewDecoder(r)
+	decoded, pages := 0, 0
+	fail := func(err error) error {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return &PartialError{Decoded: decoded, Err: err}
+	}
+
+	// The crt.sh API is a little funky... It returns multiple
+	// JSON arrays with no delimiter, so you just have to keep
+	// reading arrays until you hit EOF
+	for first := true; ; first = false {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if delim, ok := tok.(json.Delim); err != nil || !ok || delim != '[' {
+			if err == nil {
+				err = fmt.Errorf("expected a JSON array, got %v", tok)
+			}
+			// a response that isn't JSON at all is an error page, not
+			// an empty result
+			if first {
+				return &NotJSONError{Err: err}
+			}
+			return fail(err)
+		}
+		pages++
+		if progress != nil {
+			progress(decoded, pages)
+		}
+
+		for dec.More() {
+			var cert CertResponse
+			if err := dec.Decode(&cert); err != nil {
+				return fail(err)
+			}
+			decoded++
+			if progress != nil {
+				progress(decoded, pages)
+			}
+			if !fn(cert) {
+				return nil
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return fail(err)
+		}
+	}
+}
+
+// Certificate downloads and parses the certificate with the given crt.sh id
+func (c *Client) Certificate(ctx context.Context, id int) (*x509.Certificate, error) {
+	resp, err := c.get(ctx, fmt.Sprintf("%s/?d=%d", c.baseURL, id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("cert %d: no PEM data in response", id)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// RemoveDuplicates drops the precertificate of each certificate found
+func RemoveDuplicates(certs []CertResponse) []CertResponse {
+	m := make(map[string]struct{})
+	dedupedCerts := make([]CertResponse, 0)
+
+	for _, c := range certs {
+		// keep the first cert which is the leaf certificate
+		if _, ok := m[c.NameValue+c.NotBefore]; !ok {
+			m[c.NameValue+c.NotBefore] = struct{}{}
+			dedupedCerts = append(dedupedCerts, c)
+		}
+	}
+	return dedupedCerts
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDecodeCertsNotJSON(t *testing.T) {
+	err := decodeCerts(strings.NewReader("<html><body>Sorry, something went wrong</body></html>"), nil, func(CertResponse) bool { return true })
+	var notJSON *NotJSONError
+	if !errors.As(err, &notJSON) {
+		t.Errorf("decodeCerts of an HTML page = %v, want a NotJSONError", err)
+	}
+}
+
+func TestDecodeCertsEmpty(t *testing.T) {
+	for _, body := range []string{"", "[]", "[][]"} {
+		n := 0
+		err := decodeCerts(strings.NewReader(body), nil, func(CertResponse) bool { n++; return true })
+		if err != nil || n != 0 {
+			t.Errorf("decodeCerts(%q) = %d certs, %v, want none and no error", body, n, err)
+		}
+	}
+}
+
+func TestDecodeCertsCutOff(t *testing.T) {
+	n := 0
+	err := decodeCerts(strings.NewReader(`[{"id":1},{"id":2},{"id"`), nil, func(CertResponse) bool { n++; return true })
+	var partial *PartialError
+	if !errors.As(err, &partial) || partial.Decoded != 2 || n != 2 {
+		t.Errorf("decodeCerts of a cut off response = %d certs, %v, want 2 and a PartialError", n, err)
+	}
+}
+
+func TestRetryBudgetLastAttempt(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// each request's retry is charged, its last failed attempt isn't
+	c := New(WithBaseURL(srv.URL), WithRetries(1), WithRetryWaitMax(time.Millisecond), WithRetryBudget(2))
+	for i := 0; i < 2; i++ {
+		if _, err := c.Search(context.Background(), Query{Domain: "example.com"}); err == nil {
+			t.Fatal("search succeeded, want it failing")
+		}
+	}
+	if hits != 4 {
+		t.Errorf("crt.sh was asked %d times, want 4: two requests retried once each", hits)
+	}
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ctLogBatchSize is how many entries are asked for at once. Logs may
+// return fewer
+const ctLogBatchSize = 1000
+
+// CTLog searches the most recent entries of Certificate Transparency logs
+// directly, using the RFC 6962 get-entries API. A log can't be searched by
+// name, so every one of the entries is read and matched against the query
+type CTLog struct {
+	c       *Client
+	logs    []string
+	entries int64
+}
+
+// NewCTLog is a source reading the last entries of each of the logs, given
+// by their URLs, e.g. https://ct.googleapis.com/logs/us1/argon2025h2
+func (c *Client) NewCTLog(logs []string, entries int64) *CTLog {
+	return &CTLog{c: c, logs: logs, entries: entries}
+}
+
+// Name implements Source
+func (s *CTLog) Name() string {
+	return "ctlog"
+}
+
+// Search implements Source, failing only if every log does
+func (s *CTLog) Search(ctx context.Context, q Query) ([]CertResponse, error) {
+	if err := checkNameQuery(q); err != nil {
+		return nil, err
+	}
+	if len(s.logs) == 0 {
+		return nil, errors.New("no CT logs to search")
+	}
+
+	var certs []CertResponse
+	var firstErr error
+	failed := 0
+	for _, l := range s.logs {
+		found, err := s.searchLog(ctx, strings.TrimSuffix(l, "/"), q)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %s", l, err)
+			}
+			failed++
+			continue
+		}
+		certs = append(certs, found...)
+	}
+	if failed == len(s.logs) {
+		return nil, firstErr
+	}
+	return q.dedupe(certs), nil
+}
+
+type signedTreeHead struct {
+	TreeSize int64 `json:"tree_size"`
+}
+
+type logEntries struct {
+	Entries []struct {
+		LeafInput []byte `json:"leaf_input"`
+		ExtraData []byte `json:"extra_data"`
+	} `json:"entries"`
+}
+
+func (s *CTLog) searchLog(ctx context.Context, log string, q Query) ([]CertResponse, error) {
+	resp, err := s.c.get(ctx, log+"/ct/v1/get-sth")
+	if err != nil {
+		return nil, err
+	}
+	var sth signedTreeHead
+	err = json.NewDecoder(resp.Body).Decode(&sth)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unexpected response: %s", err)
+	}
+
+	start := sth.TreeSize - s.entries
+	if start < 0 {
+		start = 0
+	}
+	var certs []CertResponse
+	for start < sth.TreeSize {
+		end := start + ctLogBatchSize - 1
+		if end >= sth.TreeSize {
+			end = sth.TreeSize - 1
+		}
+		resp, err := s.c.get(ctx, fmt.Sprintf("%s/ct/v1/get-entries?start=%d&end=%d", log, start, end))
+		if err != nil {
+			return nil, err
+		}
+		var entries logEntries
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unexpected response: %s", err)
+		}
+		if len(entries.Entries) == 0 {
+			return nil, fmt.Errorf("no entries returned from %d", start)
+		}
+
+		for i, e := range entries.Entries {
+			cert, logged, err := parseLogEntry(e.LeafInput, e.ExtraData)
+			if err != nil {
+				// one bad entry doesn't spoil the rest
+				continue
+			}
+			names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+			if !q.MatchesNames(names) {
+				continue
+			}
+			c := certificateResponse(cert)
+			c.EntryTimestamp = formatTime(logged)
+			c.Source = s.Name()
+			c.CTLog = log
+			c.CTLogIndex = start + int64(i)
+			if q.Matches(c) {
+				certs = append(certs, c)
+			}
+		}
+		start += int64(len(entries.Entries))
+	}
+	return certs, nil
+}
+
+// the entry types of a MerkleTreeLeaf
+const (
+	x509Entry    = 0
+	precertEntry = 1
+)
+
+// parseLogEntry decodes the certificate of a get-entries entry and when it
+// was logged. A precertificate is read from the extra data, where it's
+// logged whole
+func parseLogEntry(leaf, extra []byte) (*x509.Certificate, time.Time, error) {
+	// version, leaf type, timestamp and entry type
+	if len(leaf) < 12 {
+		return nil, time.Time{}, errors.New("short leaf")
+	}
+	logged := time.Unix(0, int64(binary.BigEndian.Uint64(leaf[2:10]))*int64(time.Millisecond)).UTC()
+
+	var der []byte
+	var err error
+	switch binary.BigEndian.Uint16(leaf[10:12]) {
+	case x509Entry:
+		der, _, err = readUint24Bytes(leaf[12:])
+	case precertEntry:
+		der, _, err = readUint24Bytes(extra)
+	default:
+		err = errors.New("unknown entry type")
+	}
+	if err != nil {
+		return nil, logged, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, logged, err
+}
+
+// readUint24Bytes reads a TLS opaque value with a 24 bit length
+func readUint24Bytes(b []byte) ([]byte, []byte, error) {
+	if len(b) < 3 {
+		return nil, nil, errors.New("truncated length")
+	}
+	n := int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	if len(b) < 3+n {
+		return nil, nil, errors.New("truncated value")
+	}
+	return b[3 : 3+n], b[3+n:], nil
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultFacebookURL is the Facebook Graph API
+const DefaultFacebookURL = "https://graph.facebook.com"
+
+// facebookMaxPages caps how many pages of results a Facebook search reads
+const facebookMaxPages = 50
+
+// Facebook searches Facebook's Certificate Transparency monitoring, with
+// an app access token
+type Facebook struct {
+	c       *Client
+	baseURL string
+	token   string
+}
+
+// NewFacebook is a Facebook source making its requests through c
+func (c *Client) NewFacebook(baseURL, token string) *Facebook {
+	return &Facebook{c: c, baseURL: baseURL, token: token}
+}
+
+// Name implements Source
+func (s *Facebook) Name() string {
+	return "facebook"
+}
+
+type facebookResponse struct {
+	Data []struct {
+		CertificatePEM string   `json:"certificate_pem"`
+		Domains        []string `json:"domains"`
+	} `json:"data"`
+	Paging struct {
+		Next string `json:"next"`
+	} `json:"paging"`
+}
+
+// Search implements Source. Facebook returns a domain's subdomains as well,
+// so the results are matched like crt.sh would
+func (s *Facebook) Search(ctx context.Context, q Query) ([]CertResponse, error) {
+	if err := checkNameQuery(q); err != nil {
+		return nil, err
+	}
+	base, _ := q.baseDomain()
+	// the token is sent as a header so it's kept out of error messages,
+	// which include the URL
+	header := http.Header{"Authorization": {"Bearer " + s.token}}
+
+	var certs []CertResponse
+	u := fmt.Sprintf("%s/certificates?query=%s&fields=certificate_pem,domains&limit=1000", s.baseURL, url.QueryEscape(base))
+	for page := 0; page < facebookMaxPages && len(u) > 0; page++ {
+		resp, err := s.c.getWith(ctx, u, header)
+		if err != nil {
+			return nil, err
+		}
+		var r facebookResponse
+		err = json.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unexpected response: %s", err)
+		}
+
+		for _, d := range r.Data {
+			if !q.MatchesNames(d.Domains) {
+				continue
+			}
+			block, _ := pem.Decode([]byte(d.CertificatePEM))
+			if block == nil {
+				return nil, fmt.Errorf("unexpected response: no PEM data for %s", strings.Join(d.Domains, ", "))
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing a certificate for %s: %s", strings.Join(d.Domains, ", "), err)
+			}
+			c := certificateResponse(cert)
+			c.Source = s.Name()
+			if q.Matches(c) {
+				certs = append(certs, c)
+			}
+		}
+		u = r.Paging.Next
+	}
+	return q.dedupe(certs), nil
+}
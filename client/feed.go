@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeedEntry is an entry in the crt.sh Atom feed for a query
+type FeedEntry struct {
+	ID        string    `xml:"id"`
+	Title     string    `xml:"title"`
+	Summary   string    `xml:"summary"`
+	Published time.Time `xml:"published"`
+	Updated   time.Time `xml:"updated"`
+	Links     []struct {
+		Rel  string `xml:"rel,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"link"`
+}
+
+type atomFeed struct {
+	Entries []FeedEntry `xml:"entry"`
+}
+
+var (
+	certIDPattern = regexp.MustCompile(`[?&]id=(\d+)`)
+	issuerPattern = regexp.MustCompile(`Issuer:\s*([^;]+)`)
+	serialPattern = regexp.MustCompile(`Serial[^:]*:\s*([0-9A-Fa-fx]+)`)
+)
+
+// Link is the crt.sh page the entry describes
+func (e FeedEntry) Link() string {
+	for _, l := range e.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return e.ID
+}
+
+// CertID is the crt.sh id of the certificate in the entry, or 0 if the
+// entry doesn't link to one
+func (e FeedEntry) CertID() int {
+	for _, s := range []string{e.Link(), e.ID} {
+		if m := certIDPattern.FindStringSubmatch(s); m != nil {
+			id, _ := strconv.Atoi(m[1])
+			return id
+		}
+	}
+	return 0
+}
+
+// CertResponse fills in what the entry says about its certificate. The
+// feed carries less than a search, fields it doesn't have are left empty
+func (e FeedEntry) CertResponse() CertResponse {
+	c := CertResponse{ID: e.CertID()}
+	if !e.Published.IsZero() {
+		c.EntryTimestamp = e.Published.UTC().Format(TimeLayout)
+	}
+	if m := issuerPattern.FindStringSubmatch(e.Title); m != nil {
+		c.IssuerName = strings.TrimSpace(m[1])
+	}
+	if m := serialPattern.FindStringSubmatch(e.Title); m != nil {
+		c.SerialNumber = strings.TrimPrefix(strings.ToLower(m[1]), "0x")
+	}
+	return c
+}
+
+// Feed returns the entries of the crt.sh Atom feed for q. It's much
+// cheaper than Search for polling busy domains, but carries fewer details.
+// The date limits of q aren't applied
+func (c *Client) Feed(ctx context.Context, q Query) ([]FeedEntry, error) {
+	u := fmt.Sprintf("%s/atom?%s=%s", c.baseURL, q.param(), url.QueryEscape(q.Domain))
+	if extra := q.extraParams(""); len(extra) > 0 {
+		u += "&" + extra
+	}
+	resp, err := c.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var feed atomFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("parsing feed: %s", err)
+	}
+	return feed.Entries, nil
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Query describes a certificate search
+type Query struct {
+	// Domain to find certificates for. % is a wildcard
+	Domain string
+
+	// Field is the crt.sh search parameter Domain is matched against, such
+	// as O for organisation names. By default it's q, which crt.sh matches
+	// against every identity in a cert
+	Field string
+
+	// Since and Until, when set, limit the results to certificates whose
+	// not_before falls between them, inclusive
+	Since time.Time
+	Until time.Time
+	// NotAfterSince and NotAfterUntil, when set, limit them to certificates
+	// whose not_after falls between them, inclusive
+	NotAfterSince time.Time
+	NotAfterUntil time.Time
+
+	// Dedupe is how the precertificate and leaf entries of a certificate
+	// are recognised, so only the first is kept
+	Dedupe DedupeKey
+
+	// ExcludeExpired leaves out certificates that have expired. crt.sh
+	// does so itself, which can shrink the response a great deal, and the
+	// other sources' results are filtered
+	ExcludeExpired bool
+	// ServerDedupe asks crt.sh to drop the precertificates of leaf
+	// certificates before answering, with deduplicate=Y
+	ServerDedupe bool
+	// Params are extra parameters sent with crt.sh searches
+	Params url.Values
+}
+
+// DedupeKey is what identifies the entries of the same certificate
+type DedupeKey string
+
+// the ways of deduplicating results
+const (
+	// DedupeNameDate treats entries with the same names and not before
+	// date as one certificate, which can merge distinct certificates
+	DedupeNameDate DedupeKey = ""
+	// DedupeSerial treats entries from the same issuer with the same serial
+	// number as one certificate, as a precertificate and its leaf are
+	DedupeSerial DedupeKey = "serial"
+	// DedupeID only drops entries crt.sh returns more than once, keeping
+	// both precertificates and leaf certificates
+	DedupeID DedupeKey = "id"
+	// DedupeNone keeps every entry
+	DedupeNone DedupeKey = "none"
+)
+
+// dedupeKey identifies the certificate an entry is for, or is empty when
+// entries aren't deduplicated
+func (q Query) dedupeKey(c CertResponse) string {
+	switch q.Dedupe {
+	case DedupeSerial:
+		return fmt.Sprintf("%d/%s", c.IssuerCAID, c.SerialNumber)
+	case DedupeID:
+		return fmt.Sprint(c.ID)
+	case DedupeNone:
+		return ""
+	}
+	return c.NameValue + c.NotBefore
+}
+
+// Matches reports whether a cert satisfies the date limits of the query,
+// and hasn't expired when they're excluded
+func (q Query) Matches(c CertResponse) bool {
+	if q.ExcludeExpired {
+		notAfter, err := c.NotAfterTime()
+		if err == nil && notAfter.Before(time.Now().UTC()) {
+			return false
+		}
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		notBefore, err := c.NotBeforeTime()
+		if err != nil || !within(notBefore, q.Since, q.Until) {
+			return false
+		}
+	}
+	if !q.NotAfterSince.IsZero() || !q.NotAfterUntil.IsZero() {
+		notAfter, err := c.NotAfterTime()
+		if err != nil || !within(notAfter, q.NotAfterSince, q.NotAfterUntil) {
+			return false
+		}
+	}
+	return true
+}
+
+// within reports whether t is between since and until inclusive, either of
+// which may be zero for no limit
+func within(t, since, until time.Time) bool {
+	if !since.IsZero() && t.Before(since) {
+		return false
+	}
+	return until.IsZero() || !t.After(until)
+}
+
+// limited reports whether the query has any limits to filter certs by
+func (q Query) limited() bool {
+	return !q.Since.IsZero() || !q.Until.IsZero() || !q.NotAfterSince.IsZero() || !q.NotAfterUntil.IsZero() || q.ExcludeExpired
+}
+
+// Filter returns the certs that match the query
+func (q Query) Filter(certs []CertResponse) []CertResponse {
+	if !q.limited() {
+		return certs
+	}
+
+	filtered := make([]CertResponse, 0, len(certs))
+	for _, c := range certs {
+		if q.Matches(c) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// param is the crt.sh search parameter for the query
+func (q Query) param() string {
+	if len(q.Field) == 0 {
+		return "q"
+	}
+	return q.Field
+}
+
+// extraParams are the crt.sh parameters sent along with the search:
+// those the query asks for, and then fallback, which is itself encoded
+// parameters. They're empty when there are none
+func (q Query) extraParams(fallback string) string {
+	v := url.Values{}
+	for key, values := range q.Params {
+		v[key] = append([]string(nil), values...)
+	}
+	if q.ExcludeExpired {
+		v.Set("exclude", "expired")
+	}
+	if q.ServerDedupe {
+		v.Set("deduplicate", "Y")
+	}
+	extra, _ := url.ParseQuery(fallback)
+	for key := range extra {
+		v.Set(key, extra.Get(key))
+	}
+	return v.Encode()
+}
+
+// fallbacks are the crt.sh parameters tried in turn when a search times
+// out, each asking crt.sh for less. deduplicate=Y drops the precertificates
+// of leaf certificates, so it's only asked for when they're dropped anyway
+func (q Query) fallbacks() []string {
+	if q.Dedupe == DedupeID || q.Dedupe == DedupeNone {
+		return []string{"exclude=expired"}
+	}
+	return []string{"deduplicate=Y", "deduplicate=Y&exclude=expired"}
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out requests, retries included, so there's at least
+// interval between the start of each
+type rateLimiter struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// when the next request is allowed to start
+	at time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until it's the caller's turn to make a request or ctx is done.
+// It's called before each attempt rather than from the transport so the
+// wait doesn't count towards the request's timeout
+func (l *rateLimiter) wait(ctx context.Context) {
+	l.mu.Lock()
+	now := time.Now()
+	start := l.at
+	if start.Before(now) {
+		start = now
+	}
+	l.at = start.Add(l.interval)
+	l.mu.Unlock()
+
+	wait := time.Until(start)
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// shardChars are the characters a name can start with. _ is left out as
+// it's a single character wildcard to crt.sh, so names starting with one are
+// missed by a sharded search
+const shardChars = "abcdefghijklmnopqrstuvwxyz0123456789-*"
+
+// maxShardDepth is how many leading characters a failing shard is split on
+// before giving up
+const maxShardDepth = 3
+
+// Shards splits a query whose domain starts with the % wildcard into one
+// query per possible first character of the names it matches, e.g.
+// %.example.com becomes a%.example.com, b%.example.com and so on. Queries
+// of a Field, or that don't start with %, can't be split and are returned
+// as they are
+func (q Query) Shards() []Query {
+	if !q.shardable() {
+		return []Query{q}
+	}
+	shards := make([]Query, 0, len(shardChars))
+	for _, ch := range shardChars {
+		shards = append(shards, q.shard(string(ch)))
+	}
+	return shards
+}
+
+func (q Query) shardable() bool {
+	return len(q.Field) == 0 && strings.HasPrefix(q.Domain, "%") && len(q.Domain) > 1
+}
+
+// shard is the part of q matching names that start with prefix
+func (q Query) shard(prefix string) Query {
+	s := q
+	s.Domain = prefix + q.Domain
+	return s
+}
+
+// Progress is told how many of the shards of a query have completed
+type Progress func(done, total int)
+
+// SearchSharded is Search for queries too large for crt.sh to answer in a
+// single request. The query is split into Shards which are searched
+// concurrency at a time, and any shard that fails is split again on its
+// next character. The results are the union of every shard's, so a cert
+// matching several shards is returned for each. They're ordered newest
+// first by crt.sh id
+func (c *Client) SearchSharded(ctx context.Context, q Query, concurrency int, progress Progress) ([]CertResponse, error) {
+	if !q.shardable() {
+		return c.Search(ctx, q)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		certs  []CertResponse
+		failed []string
+		done   int
+		total  int
+		sem    = make(chan struct{}, concurrency)
+		search func(prefix string)
+	)
+
+	search = func(prefix string) {
+		defer wg.Done()
+
+		s := q.shard(prefix)
+		sem <- struct{}{}
+		found, err := c.Search(ctx, s)
+		<-sem
+
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		switch {
+		case err == nil:
+			certs = append(certs, found...)
+		case len(prefix) < maxShardDepth && ctx.Err() == nil:
+			for _, ch := range shardChars {
+				total++
+				wg.Add(1)
+				go search(prefix + string(ch))
+			}
+		default:
+			failed = append(failed, s.Domain)
+		}
+		if progress != nil {
+			progress(done, total)
+		}
+	}
+
+	mu.Lock()
+	for _, ch := range shardChars {
+		total++
+		wg.Add(1)
+		go search(string(ch))
+	}
+	mu.Unlock()
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("searching %s: %d shard(s) failed, including %s", q.Domain, len(failed), failed[0])
+	}
+	sort.SliceStable(certs, func(i, j int) bool {
+		return certs[i].ID > certs[j].ID
+	})
+	return certs, nil
+}
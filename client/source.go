@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source is somewhere certificates can be searched for. Sources other than
+// crt.sh return certs without a crt.sh id, with Source and SHA256 set
+// instead when they know them
+type Source interface {
+	Name() string
+	Search(ctx context.Context, q Query) ([]CertResponse, error)
+}
+
+// Name is crtsh, the name of the source for --source
+func (c *Client) Name() string {
+	return "crtsh"
+}
+
+// Merged searches several sources at once and returns the union of their
+// results, keeping the first copy of a cert found by more than one. Sources
+// are tried in order, so putting crt.sh first keeps its ids
+type Merged struct {
+	Sources []Source
+	// OnError is told about each source that fails, the search only fails
+	// when they all do
+	OnError func(s Source, err error)
+}
+
+// Name lists the merged sources
+func (m Merged) Name() string {
+	names := make([]string, len(m.Sources))
+	for i, s := range m.Sources {
+		names[i] = s.Name()
+	}
+	return strings.Join(names, "+")
+}
+
+// Search implements Source. Certs from different sources are recognised by
+// their serial number and not before date, which the precertificate and
+// leaf entries of a cert share, so only one of them is kept unless q.Dedupe
+// keeps every entry
+func (m Merged) Search(ctx context.Context, q Query) ([]CertResponse, error) {
+	results := make([][]CertResponse, len(m.Sources))
+	errs := make([]error, len(m.Sources))
+	var wg sync.WaitGroup
+	for i, s := range m.Sources {
+		wg.Add(1)
+		go func(i int, s Source) {
+			defer wg.Done()
+			results[i], errs[i] = s.Search(ctx, q)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var certs []CertResponse
+	seen := make(map[string]bool)
+	failed := 0
+	for i, s := range m.Sources {
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("%s: %s", s.Name(), errs[i])
+			if m.OnError != nil {
+				m.OnError(s, errs[i])
+			}
+			failed++
+			continue
+		}
+		// a source's own results are already deduplicated as q says, only
+		// the certs earlier sources found are skipped
+		found := make(map[string]bool)
+		for _, c := range results[i] {
+			key := c.CrossSourceKey()
+			if seen[key] && q.Dedupe != DedupeNone {
+				continue
+			}
+			found[key] = true
+			if len(c.Source) == 0 {
+				c.Source = s.Name()
+			}
+			certs = append(certs, c)
+		}
+		for key := range found {
+			seen[key] = true
+		}
+	}
+	if failed > 0 && failed == len(m.Sources) {
+		return nil, errs[0]
+	}
+	return certs, nil
+}
+
+// CrossSourceKey identifies a cert whichever source found it: its
+// normalised serial number and not before date, or its fingerprint when the
+// source doesn't give its serial
+func (c CertResponse) CrossSourceKey() string {
+	if len(c.SerialNumber) == 0 {
+		return "sha256/" + c.SHA256
+	}
+	return NormaliseSerial(c.SerialNumber) + "/" + c.NotBefore
+}
+
+// NormaliseSerial converts a hex serial number to lowercase without 0x,
+// colons or leading zeros, so the serials of different sources compare
+// equal
+func NormaliseSerial(serial string) string {
+	s := strings.ToLower(strings.Replace(strings.TrimSpace(serial), ":", "", -1))
+	s = strings.TrimPrefix(s, "0x")
+	if trimmed := strings.TrimLeft(s, "0"); len(trimmed) > 0 {
+		return trimmed
+	}
+	return s
+}
+
+// decimalSerial converts a serial number written in decimal to hex
+func decimalSerial(serial string) string {
+	n, ok := new(big.Int).SetString(serial, 10)
+	if !ok {
+		return NormaliseSerial(serial)
+	}
+	return n.Text(16)
+}
+
+// MatchesNames reports whether any of names matches the query's domain,
+// with % as a wildcard and ignoring case like crt.sh. Sources that can only
+// search more broadly than crt.sh filter their results with it
+func (q Query) MatchesNames(names []string) bool {
+	re := q.namePattern()
+	for _, n := range names {
+		if re.MatchString(strings.ToLower(n)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q Query) namePattern() *regexp.Regexp {
+	parts := strings.Split(strings.ToLower(q.Domain), "%")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// baseDomain is the domain a query's names are under, without the leading
+// wildcard, and whether it had one
+func (q Query) baseDomain() (string, bool) {
+	base := strings.TrimLeft(q.Domain, "%.")
+	return base, base != q.Domain
+}
+
+// checkNameQuery rejects the queries sources other than crt.sh can't run
+func checkNameQuery(q Query) error {
+	if len(q.Field) > 0 && q.Field != "q" {
+		return fmt.Errorf("can only search by name, not %s", q.Field)
+	}
+	if base, _ := q.baseDomain(); len(base) == 0 || strings.Contains(base, "%") {
+		return fmt.Errorf("can only search for a domain or %%.domain, not %s", q.Domain)
+	}
+	return nil
+}
+
+// formatTime converts a source's timestamp to crt.sh's format
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(TimeLayout)
+}
+
+// joinNames formats names as crt.sh's name_value, lowercased and without
+// duplicates
+func joinNames(names []string) string {
+	var kept []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		if n = strings.ToLower(strings.TrimSpace(n)); len(n) > 0 && !seen[n] {
+			seen[n] = true
+			kept = append(kept, n)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// certificateResponse fills in the crt.sh fields of a cert another source
+// returned in full
+func certificateResponse(cert *x509.Certificate) CertResponse {
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return CertResponse{
+		IssuerName:   distinguishedName(cert.Issuer),
+		CommonName:   strings.ToLower(cert.Subject.CommonName),
+		NameValue:    joinNames(names),
+		NotBefore:    formatTime(cert.NotBefore),
+		NotAfter:     formatTime(cert.NotAfter),
+		SerialNumber: cert.SerialNumber.Text(16),
+		SHA256:       hex.EncodeToString(sum[:]),
+	}
+}
+
+// attributeNames are the short names crt.sh gives the attributes of a
+// distinguished name
+var attributeNames = map[string]string{
+	"2.5.4.3":              "CN",
+	"2.5.4.5":              "serialNumber",
+	"2.5.4.6":              "C",
+	"2.5.4.7":              "L",
+	"2.5.4.8":              "ST",
+	"2.5.4.10":             "O",
+	"2.5.4.11":             "OU",
+	"1.2.840.113549.1.9.1": "emailAddress",
+}
+
+// distinguishedName formats a name the way crt.sh does, in the order of
+// the certificate: C=US, O=Example, CN=Example CA
+func distinguishedName(n pkix.Name) string {
+	parts := make([]string, 0, len(n.Names))
+	for _, atv := range n.Names {
+		t := atv.Type.String()
+		if short, ok := attributeNames[t]; ok {
+			t = short
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", t, atv.Value))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// dedupe keeps the first entry of each cert a source other than crt.sh
+// found, as Each does for crt.sh. Without crt.sh ids each entry is
+// identified by its fingerprint
+func (q Query) dedupe(certs []CertResponse) []CertResponse {
+	seen := make(map[string]bool)
+	kept := certs[:0]
+	for _, c := range certs {
+		key := q.dedupeKey(c)
+		if q.Dedupe == DedupeID {
+			key = c.SHA256
+		}
+		if len(key) > 0 && seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, c)
+	}
+	return kept
+}
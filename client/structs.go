@@ -0,0 +1,99 @@
+package client
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeLayout is the format of the timestamps in crt.sh responses. They
+// have no zone and are UTC. Fractional seconds are accepted when parsing
+const TimeLayout = "2006-01-02T15:04:05"
+
+// ParseTime parses a crt.sh timestamp as UTC. RFC 3339 timestamps, as
+// other sources and earlier output may have, are accepted too and
+// converted to UTC
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(TimeLayout, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// CertResponse represents a certificate response object
+type CertResponse struct {
+	IssuerCAID     int64  `json:"issuer_ca_id"`
+	IssuerName     string `json:"issuer_name"`
+	CommonName     string `json:"common_name"`
+	NameValue      string `json:"name_value"`
+	ID             int    `json:"id"`
+	EntryTimestamp string `json:"entry_timestamp"`
+	NotBefore      string `json:"not_before"`
+	NotAfter       string `json:"not_after"`
+	SerialNumber   string `json:"serial_number"`
+
+	// set for certs from a search of more than one Source, or from sources
+	// other than crt.sh, which don't have a crt.sh id. The log and index
+	// are set for certs read from a CT log
+	Source     string `json:"source,omitempty"`
+	CTLog      string `json:"ct_log,omitempty"`
+	CTLogIndex int64  `json:"ct_log_index,omitempty"`
+	SHA256     string `json:"-"`
+}
+
+// Link is the crt.sh page for the cert
+func (c CertResponse) Link() string {
+	return `https://crt.sh/?id=` + strconv.Itoa(c.ID)
+}
+
+// NotBeforeTime parses the start of the cert's validity period
+func (c CertResponse) NotBeforeTime() (time.Time, error) {
+	return ParseTime(c.NotBefore)
+}
+
+// NotAfterTime parses the end of the cert's validity period
+func (c CertResponse) NotAfterTime() (time.Time, error) {
+	return ParseTime(c.NotAfter)
+}
+
+// EntryTime parses when the cert was logged
+func (c CertResponse) EntryTime() (time.Time, error) {
+	return ParseTime(c.EntryTimestamp)
+}
+
+// Names returns the distinct, lowercased names on the cert. crt.sh
+// separates the names in name_value with newlines
+func (c CertResponse) Names() []string {
+	seen := make(map[string]struct{})
+	var names []string
+
+	for _, n := range append(strings.Split(c.NameValue, "\n"), c.CommonName) {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if len(n) == 0 {
+			continue
+		}
+		if _, ok := seen[n]; !ok {
+			seen[n] = struct{}{}
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+type enrichedCertResponse CertResponse
+
+// MarshalJSON adds in a link to the crt.sh page for each cert
+func (c CertResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		CertShLink string `json:"crt_sh_link"`
+		enrichedCertResponse
+	}{
+		CertShLink:           c.Link(),
+		enrichedCertResponse: enrichedCertResponse(c),
+	})
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceBodyLimit is the number of body bytes kept in a trace transcript
+const traceBodyLimit = 4096
+
+// sensitiveHeaders are never written to a trace file verbatim
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+// traceTransport writes a transcript of every request and response that
+// passes through it
+type traceTransport struct {
+	next http.RoundTripper
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newTraceTransport(w io.Writer, next http.RoundTripper) *traceTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &traceTransport{next: next, w: w}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var buf bytes.Buffer
+	start := time.Now()
+
+	fmt.Fprintf(&buf, "=== %s %s %s\n", start.UTC().Format(time.RFC3339Nano), req.Method, sanitizeURL(req.URL))
+	writeHeaders(&buf, "> ", req.Header)
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			writeBody(&buf, body, 0)
+			body.Close()
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Fprintf(&buf, "! error after %s: %s\n\n", elapsed, err)
+		t.flush(&buf)
+		return resp, err
+	}
+
+	fmt.Fprintf(&buf, "< %s (%s to headers)\n", resp.Status, elapsed)
+	writeHeaders(&buf, "< ", resp.Header)
+
+	// the body is recorded as it is read so that large responses are still
+	// streamed to the caller; the entry is written once the body is closed
+	resp.Body = &tracedBody{
+		ReadCloser: resp.Body,
+		transport:  t,
+		entry:      &buf,
+		start:      start,
+	}
+	return resp, nil
+}
+
+func (t *traceTransport) flush(entry *bytes.Buffer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(entry.Bytes())
+}
+
+type tracedBody struct {
+	io.ReadCloser
+	transport *traceTransport
+	entry     *bytes.Buffer
+	start     time.Time
+
+	head   bytes.Buffer
+	total  int64
+	closed bool
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if room := traceBodyLimit - b.head.Len(); room > 0 {
+			if room > n {
+				room = n
+			}
+			b.head.Write(p[:room])
+		}
+		b.total += int64(n)
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+
+	writeBody(b.entry, &b.head, b.total)
+	fmt.Fprintf(b.entry, "--- %d body bytes read, %s total\n\n", b.total, time.Since(b.start))
+	b.transport.flush(b.entry)
+	return err
+}
+
+func writeHeaders(w io.Writer, prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := strings.Join(h[k], ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(k)] {
+			v = "[redacted]"
+		}
+		fmt.Fprintf(w, "%s%s: %s\n", prefix, k, v)
+	}
+}
+
+// writeBody copies at most traceBodyLimit bytes of r into w. total is the
+// full body size when it is known up front, otherwise zero
+func writeBody(w io.Writer, r io.Reader, total int64) {
+	head, _ := ioutil.ReadAll(io.LimitReader(r, traceBodyLimit))
+	if len(head) == 0 {
+		return
+	}
+	w.Write(head)
+	if total > int64(len(head)) {
+		fmt.Fprintf(w, "\n... [truncated %d bytes]", total-int64(len(head)))
+	}
+	fmt.Fprintln(w)
+}
+
+// SanitizeURL is sanitizeURL for a URL given as a string. Anything that
+// isn't an absolute URL is returned as it is
+func SanitizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return raw
+	}
+	return sanitizeURL(u)
+}
+
+// sanitizeURL masks query parameters that look like credentials
+func sanitizeURL(u *url.URL) string {
+	clean := *u
+	clean.User = nil
+
+	q := clean.Query()
+	redacted := false
+	for k := range q {
+		lk := strings.ToLower(k)
+		if strings.Contains(lk, "token") || strings.Contains(lk, "key") || strings.Contains(lk, "secret") || strings.Contains(lk, "password") {
+			q.Set(k, "[redacted]")
+			redacted = true
+		}
+	}
+	if redacted {
+		clean.RawQuery = q.Encode()
+	}
+	return clean.String()
+}
@@ -6,6 +6,10 @@ require (
 	github.com/apex/log v1.9.0
 	github.com/hashicorp/go-retryablehttp v0.7.0
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/spf13/cobra v0.0.3
-	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/spf13/pflag v1.0.3
+	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
+	golang.org/x/sys v0.0.0-20210330210617-4fbd30eecc44
+	gopkg.in/yaml.v3 v3.0.0-20200605160147-a5ece683394c
 )
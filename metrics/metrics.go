@@ -0,0 +1,180 @@
+// Package metrics keeps counters and gauges and serves them in the
+// Prometheus text exposition format.
+//
+// Every method is a no-op on a nil metric, so callers never need to check
+// whether metrics are enabled
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry is a set of metrics served together
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// family is a metric and its values for each set of label values
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*value
+}
+
+type value struct {
+	labels []string
+	v      float64
+}
+
+func (r *Registry) add(name, help, kind string, labels []string) *family {
+	f := &family{name: name, help: help, kind: kind, labels: labels, values: make(map[string]*value)}
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+	return f
+}
+
+// update applies fn to the value for the label values, which must be given
+// in the order the labels were
+func (f *family) update(labelValues []string, fn func(v float64) float64) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, not %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.values[key]
+	if !ok {
+		v = &value{labels: append([]string(nil), labelValues...)}
+		f.values[key] = v
+	}
+	v.v = fn(v.v)
+}
+
+// Counter is a count that only goes up
+type Counter struct{ f *family }
+
+// Counter adds a counter to the registry. Its name should end in _total
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.add(name, help, "counter", labels)}
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds n to the counter. n can't be negative
+func (c *Counter) Add(n float64, labelValues ...string) {
+	if c == nil || n < 0 {
+		return
+	}
+	c.f.update(labelValues, func(v float64) float64 { return v + n })
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct{ f *family }
+
+// Gauge adds a gauge to the registry
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.add(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge to n
+func (g *Gauge) Set(n float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.f.update(labelValues, func(float64) float64 { return n })
+}
+
+// Add adds n, which may be negative, to the gauge
+func (g *Gauge) Add(n float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	g.f.update(labelValues, func(v float64) float64 { return v + n })
+}
+
+// ServeHTTP writes every metric in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.kind)
+
+		f.mu.Lock()
+		values := make([]*value, 0, len(f.values))
+		for _, v := range f.values {
+			values = append(values, &value{labels: v.labels, v: v.v})
+		}
+		f.mu.Unlock()
+		sort.Slice(values, func(i, j int) bool {
+			return strings.Join(values[i].labels, "\xff") < strings.Join(values[j].labels, "\xff")
+		})
+
+		// an unlabelled metric is always shown, even before it's been set
+		if len(values) == 0 && len(f.labels) == 0 {
+			values = append(values, &value{})
+		}
+		for _, v := range values {
+			b.WriteString(f.name)
+			if len(f.labels) > 0 {
+				pairs := make([]string, len(f.labels))
+				for i, l := range f.labels {
+					pairs[i] = l + `="` + escapeLabel(v.labels[i]) + `"`
+				}
+				b.WriteString("{" + strings.Join(pairs, ",") + "}")
+			}
+			b.WriteString(" " + formatValue(v.v) + "\n")
+		}
+	}
+	w.Write([]byte(b.String()))
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
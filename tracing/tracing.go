@@ -0,0 +1,278 @@
+// Package tracing records spans and exports them to an OpenTelemetry
+// collector using OTLP over HTTP with JSON encoding.
+//
+// Spans are started from a context. When the context carries no Tracer the
+// returned span is nil, and every Span method is a no-op on a nil span, so
+// callers never need to check whether tracing is enabled
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// spans are exported once this many have ended, or every flushInterval
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+)
+
+// Span kinds from the OTLP specification
+const (
+	KindInternal = 1
+	KindClient   = 3
+)
+
+// Tracer collects ended spans and exports them in batches
+type Tracer struct {
+	url     string
+	service string
+	http    *http.Client
+
+	mu      sync.Mutex
+	pending []*Span
+
+	flush chan struct{}
+	done  chan struct{}
+	errs  chan error
+}
+
+// New creates a Tracer exporting to the OTLP/HTTP collector at endpoint,
+// e.g. http://localhost:4318
+func New(endpoint, service string) *Tracer {
+	u := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(u, "/v1/traces") {
+		u += "/v1/traces"
+	}
+
+	t := &Tracer{
+		url:     u,
+		service: service,
+		http:    &http.Client{Timeout: 10 * time.Second},
+		flush:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		errs:    make(chan error, 1),
+	}
+	go t.loop()
+	return t
+}
+
+func (t *Tracer) loop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		case <-t.done:
+			if err := t.export(); err != nil {
+				lastErr = err
+			}
+			t.errs <- lastErr
+			return
+		}
+		if err := t.export(); err != nil {
+			lastErr = err
+		}
+	}
+}
+
+// Shutdown exports any remaining spans and stops the tracer. It returns
+// the last export error, if any
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	close(t.done)
+	select {
+	case err := <-t.errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) end(s *Span) {
+	t.mu.Lock()
+	t.pending = append(t.pending, s)
+	full := len(t.pending) >= batchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) export() error {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = s.otlp()
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{attribute("service.name", t.service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: t.service},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.http.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("exporting spans: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("exporting spans: %s", resp.Status)
+	}
+	return nil
+}
+
+// Span is a single timed operation
+type Span struct {
+	tracer   *Tracer
+	name     string
+	kind     int
+	traceID  string
+	spanID   string
+	parentID string
+	start    time.Time
+
+	mu       sync.Mutex
+	end      time.Time
+	attrs    []otlpAttribute
+	errorMsg string
+	failed   bool
+}
+
+type tracerKey struct{}
+type spanKey struct{}
+
+// WithTracer returns a context whose spans are recorded by t
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// Start begins a span that is a child of the span in ctx, if any
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal)
+}
+
+// StartKind is Start for spans of a particular kind
+func StartKind(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	t, _ := ctx.Value(tracerKey{}).(*Tracer)
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		spanID: randomID(8),
+		start:  time.Now(),
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the current span in ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttribute records a string, bool or integer attribute on the span.
+// Other types are recorded using their default string format
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attribute(key, value))
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed = true
+	s.errorMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.end(s)
+}
+
+// traceparent formats the span as a W3C trace context header
+func (s *Span) traceparent() string {
+	return "00-" + s.traceID + "-" + s.spanID + "-01"
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        s.attrs,
+	}
+	if s.failed {
+		span.Status = &otlpStatus{Code: 2, Message: s.errorMsg}
+	}
+	return span
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}